@@ -7,8 +7,13 @@ import (
 	"log"
 )
 
+// DefaultMaxConns is the size of the connection pool used when nothing else is configured
+const DefaultMaxConns = 20
+
 type PostgreSQLConnection struct {
 	Pool *pgxpool.Pool
+	// MaxConns is the most connections the pool opens to the database
+	MaxConns int32
 }
 
 type DatabaseService interface {
@@ -17,8 +22,9 @@ type DatabaseService interface {
 	GetPool() *pgxpool.Pool
 }
 
-func NewDatabaseService() DatabaseService {
-	return &PostgreSQLConnection{}
+// NewDatabaseService creates a database service whose pool opens at most the given number of connections
+func NewDatabaseService(maxConns int32) DatabaseService {
+	return &PostgreSQLConnection{MaxConns: maxConns}
 }
 
 // NewDatabase initializes a connection to PostgreSQL, checks if the target database exists,
@@ -54,7 +60,14 @@ func (db *PostgreSQLConnection) NewDatabase(connStr string, dbName string) (*Pos
 	finalConnStr := fmt.Sprintf("%s%s?sslmode=disable", connStr, dbName)
 	log.Println("Connecting to database")
 
-	pool, err := pgxpool.Connect(context.Background(), finalConnStr)
+	config, err := pgxpool.ParseConfig(finalConnStr)
+	if err != nil {
+		log.Println("Unable to parse database connection string")
+		return nil, err
+	}
+	config.MaxConns = db.MaxConns
+
+	pool, err := pgxpool.ConnectConfig(context.Background(), config)
 	if err != nil {
 		log.Println("Unable to connect to database")
 		return nil, err
@@ -69,7 +82,7 @@ func (db *PostgreSQLConnection) NewDatabase(connStr string, dbName string) (*Pos
 		return nil, err
 	}
 
-	return &PostgreSQLConnection{Pool: pool}, nil
+	return &PostgreSQLConnection{Pool: pool, MaxConns: db.MaxConns}, nil
 }
 
 // CreateTablesIfNotExist creates tables if they do not exist
@@ -77,15 +90,23 @@ func (db *PostgreSQLConnection) CreateTablesIfNotExist() error {
 
 	queries := []string{
 		`CREATE TABLE IF NOT EXISTS users (
-    	 id INT AUTO_INCREMENT PRIMARY KEY
+    	 id SERIAL PRIMARY KEY,
+    	 max_in_flight INT,
+    	 max_file_bytes BIGINT,
+    	 max_pages INT,
+    	 max_parse_seconds INT,
+    	 last_claimed_at TIMESTAMP
 		 );`,
 
 		`CREATE TABLE IF NOT EXISTS files (
-    	 id INT AUTO_INCREMENT PRIMARY KEY,
+    	 id SERIAL PRIMARY KEY,
     	 filename VARCHAR(255) NOT NULL,
     	 file_hash VARCHAR(64) UNIQUE NOT NULL,
      	 parsed_file BYTEA,
-     	 status VARCHAR(20) CHECK (status IN ('queued', 'processing', 'parsed')) NOT NULL DEFAULT 'queued'
+     	 blob_key VARCHAR(64),
+     	 file_size BIGINT,
+     	 status VARCHAR(20) CHECK (status IN ('in_queue', 'parsing', 'error', 'success', 'imported', 'cancelled', 'limit_exceeded')) NOT NULL DEFAULT 'in_queue',
+     	 created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 		 );`,
 
 		`CREATE TABLE IF NOT EXISTS user_files (
@@ -98,11 +119,135 @@ func (db *PostgreSQLConnection) CreateTablesIfNotExist() error {
     	FOREIGN KEY (file_id) REFERENCES files(id) ON DELETE CASCADE
 		);`,
 
+		`CREATE TABLE IF NOT EXISTS workers (
+    	id SERIAL PRIMARY KEY,
+    	name VARCHAR(255) NOT NULL,
+    	version VARCHAR(50) NOT NULL DEFAULT '',
+    	capabilities TEXT[] NOT NULL DEFAULT '{}',
+    	registered_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    	last_seen TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);`,
+
 		`CREATE TABLE IF NOT EXISTS queue (
-    	INT AUTO_INCREMENT PRIMARY KEY,
+    	id SERIAL PRIMARY KEY,
+    	file_id INT UNIQUE NOT NULL,
+    	blob_key VARCHAR(64) NOT NULL,
+    	file_size BIGINT NOT NULL DEFAULT 0,
+    	user_id INT,
+    	priority VARCHAR(10) CHECK (priority IN ('high', 'normal', 'bulk')) NOT NULL DEFAULT 'normal',
+    	lease_token VARCHAR(36),
+    	leased_until TIMESTAMP,
+    	claimed_at TIMESTAMP,
+    	parse_deadline TIMESTAMP,
+    	worker_id INT,
+    	attempts INT NOT NULL DEFAULT 0,
+    	retry_at TIMESTAMP,
+    	last_error TEXT,
+    	not_before TIMESTAMP,
+    	required_capabilities TEXT[] NOT NULL DEFAULT '{}',
+    	max_pages INT NOT NULL DEFAULT 0,
+    	max_parse_seconds DOUBLE PRECISION NOT NULL DEFAULT 0,
+    	pages_processed INT,
+    	total_pages INT,
+    	stage VARCHAR(50),
+    	progress_at TIMESTAMP,
+    	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    	FOREIGN KEY (file_id) REFERENCES files(id) ON DELETE CASCADE,
+    	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL,
+    	FOREIGN KEY (worker_id) REFERENCES workers(id) ON DELETE SET NULL
+		);`,
+
+		`CREATE TABLE IF NOT EXISTS dead_letter (
+    	id SERIAL PRIMARY KEY,
+    	file_id INT UNIQUE NOT NULL,
+    	blob_key VARCHAR(64) NOT NULL,
+    	file_size BIGINT NOT NULL DEFAULT 0,
+    	user_id INT,
+    	priority VARCHAR(10) NOT NULL DEFAULT 'normal',
+    	required_capabilities TEXT[] NOT NULL DEFAULT '{}',
+    	max_pages INT NOT NULL DEFAULT 0,
+    	max_parse_seconds DOUBLE PRECISION NOT NULL DEFAULT 0,
+    	attempts INT NOT NULL,
+    	last_error TEXT,
+    	failed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    	FOREIGN KEY (file_id) REFERENCES files(id) ON DELETE CASCADE
+		);`,
+
+		`CREATE TABLE IF NOT EXISTS cancellations (
+    	file_id INT NOT NULL,
+    	worker_id INT NOT NULL,
+    	lease_token VARCHAR(36) NOT NULL,
+    	cancelled_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    	PRIMARY KEY (worker_id, file_id),
+    	FOREIGN KEY (file_id) REFERENCES files(id) ON DELETE CASCADE,
+    	FOREIGN KEY (worker_id) REFERENCES workers(id) ON DELETE CASCADE
+		);`,
+
+		`CREATE TABLE IF NOT EXISTS file_status_history (
+    	id SERIAL PRIMARY KEY,
+    	file_id INT NOT NULL,
+    	status VARCHAR(20) NOT NULL,
+    	priority VARCHAR(10),
+    	changed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    	FOREIGN KEY (file_id) REFERENCES files(id) ON DELETE CASCADE
+		);`,
+
+		`CREATE INDEX IF NOT EXISTS file_status_history_changed_at ON file_status_history (changed_at);`,
+
+		`CREATE INDEX IF NOT EXISTS file_status_history_file_id ON file_status_history (file_id, id);`,
+
+		`CREATE TABLE IF NOT EXISTS parse_results (
+    	id SERIAL PRIMARY KEY,
+    	file_id INT NOT NULL,
+    	parser_name VARCHAR(255) NOT NULL DEFAULT '',
+    	parser_version VARCHAR(50) NOT NULL DEFAULT '',
+    	parsed_file BYTEA,
+    	title TEXT,
+    	author TEXT,
+    	producer TEXT,
+    	document_created_at TIMESTAMP,
+    	document_modified_at TIMESTAMP,
+    	pdf_version VARCHAR(10),
+    	page_count INT,
+    	outline JSONB,
+    	form_fields JSONB,
+    	is_current BOOLEAN NOT NULL DEFAULT FALSE,
+    	parsed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    	FOREIGN KEY (file_id) REFERENCES files(id) ON DELETE CASCADE
+		);`,
+
+		`CREATE UNIQUE INDEX IF NOT EXISTS parse_results_current ON parse_results (file_id) WHERE is_current;`,
+
+		`CREATE TABLE IF NOT EXISTS parsed_pages (
+    	result_id INT NOT NULL,
+    	page_number INT NOT NULL,
+    	width DOUBLE PRECISION NOT NULL DEFAULT 0,
+    	height DOUBLE PRECISION NOT NULL DEFAULT 0,
+    	rotation INT NOT NULL DEFAULT 0,
+    	text TEXT NOT NULL DEFAULT '',
+    	char_count INT NOT NULL DEFAULT 0,
+    	PRIMARY KEY (result_id, page_number),
+    	FOREIGN KEY (result_id) REFERENCES parse_results(id) ON DELETE CASCADE
+		);`,
+
+		`CREATE TABLE IF NOT EXISTS result_submissions (
+    	lease_token VARCHAR(36) PRIMARY KEY,
     	file_id INT NOT NULL,
+    	attempt INT NOT NULL,
+    	result_hash VARCHAR(64) NOT NULL,
+    	submitted_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     	FOREIGN KEY (file_id) REFERENCES files(id) ON DELETE CASCADE
 		);`,
+
+		`CREATE INDEX IF NOT EXISTS result_submissions_submitted_at ON result_submissions (submitted_at);`,
+
+		`CREATE TABLE IF NOT EXISTS queue_control (
+    	id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+    	state VARCHAR(10) NOT NULL DEFAULT 'running' CHECK (state IN ('running', 'paused', 'draining')),
+    	changed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);`,
+
+		`INSERT INTO queue_control (id) VALUES (TRUE) ON CONFLICT DO NOTHING;`,
 	}
 
 	for _, query := range queries {
@@ -6,6 +6,90 @@ import (
 	"fmt"
 )
 
+// ErrQueueEmpty is returned when there is no file waiting to be claimed from the queue
+var ErrQueueEmpty = errors.New("Queue is empty")
+
+// ErrQueuePaused is returned when a file is claimed while the queue is paused or draining
+var ErrQueuePaused = errors.New("Queue is paused")
+
+// ErrNotLeased is returned when a result is reported for a file that is not leased to a worker
+var ErrNotLeased = errors.New("File is not leased")
+
+// ErrNotQueued is returned when a file is looked up in the queue but is not in it
+var ErrNotQueued = errors.New("File is not in the queue")
+
+// ErrAlreadyQueued is returned when a file is queued while it is already in the queue
+var ErrAlreadyQueued = errors.New("File is already in the queue")
+
+// ErrNotDeadLettered is returned when a file is requeued from the dead-letter queue but is not in it
+var ErrNotDeadLettered = errors.New("File is not in the dead-letter queue")
+
+// ErrLeaseTokenRequired is returned when a result is reported without the lease token and attempt of its claim
+var ErrLeaseTokenRequired = errors.New("Lease token and attempt are required")
+
+// ErrStaleLease is returned when a result is reported for a lease that was since released or taken over
+var ErrStaleLease = errors.New("Lease token or attempt does not match the current lease")
+
+// ErrDuplicateSubmission is returned when a different result is reported again under the same lease
+var ErrDuplicateSubmission = errors.New("A different result was already submitted for this lease")
+
+// ErrJobCancelled is returned to a worker reporting on a file whose parsing was cancelled
+var ErrJobCancelled = errors.New("Parsing of the file was cancelled")
+
+// ErrLimitExceeded is returned to a worker reporting on a file that went over its parse limits
+var ErrLimitExceeded = errors.New("Parsing of the file went over its limits")
+
+// ErrFileTooLarge is returned when an uploaded file is larger than the limit of the user
+var ErrFileTooLarge = errors.New("File is too large")
+
+// ErrTooManyPages is returned when an uploaded file has more pages than the limit of the user
+var ErrTooManyPages = errors.New("File has too many pages")
+
+// ErrFileFinal is returned when a file is cancelled after its parsing already finished
+var ErrFileFinal = errors.New("File is already in a final state")
+
+// ErrNotReparsable is returned when a file is reparsed before its parsing succeeded or went over its limits
+var ErrNotReparsable = errors.New("Only parsed files or files over their limits can be reparsed")
+
+// ErrPDFNotStored is returned when a file is reparsed but its PDF is not in the blob store
+var ErrPDFNotStored = errors.New("The PDF of the file is not stored")
+
+// ErrParseResultNotFound is returned when a parse result does not exist or does not belong to the file
+var ErrParseResultNotFound = errors.New("Parse result does not exist")
+
+// ErrPageNotFound is returned when a page is requested that the current parse result of the file does not have
+var ErrPageNotFound = errors.New("Page does not exist")
+
+// ErrInvalidPages is returned when a result is reported with pages that are not numbered from 1 without repeats
+var ErrInvalidPages = errors.New("Pages must be numbered from 1 without repeats, with a rotation of 0, 90, 180 or 270")
+
+// ErrInvalidMetadata is returned when a result is reported with a negative page count or an overlong PDF version
+var ErrInvalidMetadata = errors.New("Metadata must have a page count of at least 0 and a PDF version of at most 10 characters")
+
+// ErrInvalidOutline is returned when a result is reported with an outline item leading to a negative page
+var ErrInvalidOutline = errors.New("Outline items must lead to a page number of at least 0")
+
+// ErrInvalidFormFields is returned when a result is reported with a form field of an unknown type or page
+var ErrInvalidFormFields = errors.New("Form fields must be of type text, checkbox, radio, button, choice or signature, on a page number of at least 0")
+
+// ErrNotParsed is returned when the content of a parse result is requested for a file that has none yet
+var ErrNotParsed = errors.New("File has not been parsed yet")
+
+// ErrInvalidSort is returned when files are listed in an order that is not supported
+var ErrInvalidSort = errors.New("Invalid sort, it must be one of upload_date, title, author, producer, created_at, modified_at, pdf_version or page_count")
+
+// ErrInvalidPriority is returned when a file is queued with a priority that is not one of the queue lanes
+var ErrInvalidPriority = errors.New("Invalid priority, it must be one of high, normal or bulk")
+
+// ErrUserNotFound is returned when a user id does not belong to an existing user
+var ErrUserNotFound = errors.New("User does not exist")
+
+// ErrFileNotFound is returned when a file does not exist or does not belong to the user
+var ErrFileNotFound = errors.New("File does not exist")
+
+// ErrWorkerNotFound is returned when a worker id does not belong to a registered worker
+var ErrWorkerNotFound = errors.New("Worker does not exist")
+
 // HandleDeadlineExceededError checks if the given error is a context deadline exceeded error.
 func HandleDeadlineExceededError(err error) error {
 	if err == context.DeadlineExceeded {
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// DeadLetter represents a file that failed every parse attempt and was taken out of the queue
+type DeadLetter struct {
+	ID        int       `json:"id"`
+	FileID    int       `json:"file_id"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"last_error"`
+	FailedAt  time.Time `json:"failed_at"`
+}
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// ParseResult represents one parse of a file, the current one is the result served as the parsed file
+type ParseResult struct {
+	ID            int       `json:"id"`
+	FileID        int       `json:"file_id"`
+	ParserName    string    `json:"parser_name"`
+	ParserVersion string    `json:"parser_version"`
+	ParsedAt      time.Time `json:"parsed_at"`
+	Current       bool      `json:"current"`
+	ParsedFile    string    `json:"parsed_file,omitempty"`
+	// Metadata, Outline and Fields hold what was found in the parsed file, only returned with the parsed file
+	Metadata *DocumentMetadata `json:"metadata,omitempty"`
+	Outline  []OutlineItem     `json:"outline,omitempty"`
+	Fields   []FormField       `json:"fields,omitempty"`
+}
@@ -1,7 +1,78 @@
 package models
 
+import "time"
+
 type Parser struct {
+	// LeaseToken and Attempt identify the claim the result is for, they are handed out with the job
+	LeaseToken   string `json:"lease_token"`
+	Attempt      int    `json:"attempt"`
 	ParsedFile   string `json:"parsed_file"`
 	ParsedStatus string `json:"parsed_status"`
 	ParsedError  string `json:"parsed_errors"`
+	// ParserName and ParserVersion default to the name and version the worker registered with
+	ParserName    string `json:"parser_name,omitempty"`
+	ParserVersion string `json:"parser_version,omitempty"`
+	// Pages holds the text of every page, the parsed file defaults to their texts separated by form feeds
+	Pages []ParsedPage `json:"pages,omitempty"`
+	// Metadata is what the document says about itself, its page count defaults to the number of pages
+	Metadata *DocumentMetadata `json:"metadata,omitempty"`
+	// Outline holds the bookmarks of the document as they are nested
+	Outline []OutlineItem `json:"outline,omitempty"`
+	// Fields holds the fields of the form of the document with the values they were filled in with
+	Fields []FormField `json:"fields,omitempty"`
+}
+
+// ParsedPage represents the text of one page of a parsed file. Width and Height are in points, before the page
+// is turned by Rotation degrees clockwise.
+type ParsedPage struct {
+	Number    int     `json:"number"`
+	Width     float64 `json:"width"`
+	Height    float64 `json:"height"`
+	Rotation  int     `json:"rotation"`
+	Text      string  `json:"text"`
+	CharCount int     `json:"char_count"`
+}
+
+// BatchResult is the parse result of one file in a batch submitted by a worker
+type BatchResult struct {
+	FileID int `json:"file_id"`
+	Parser
+}
+
+// BatchOutcome reports whether the result of one file in a batch was accepted
+type BatchOutcome struct {
+	FileID   int    `json:"file_id"`
+	Accepted bool   `json:"accepted"`
+	Error    string `json:"error,omitempty"`
+}
+
+// OutlineItem represents a bookmark of a parsed file. Page is the number of the page it leads to, 0 when it
+// leads to no page of the file.
+type OutlineItem struct {
+	Title    string        `json:"title"`
+	Page     int           `json:"page"`
+	Children []OutlineItem `json:"children,omitempty"`
+}
+
+// FormField represents a field of the form of a parsed file. Name is its fully qualified name, Type is one of
+// text, checkbox, radio, button, choice or signature, and Page is the number of the page it is shown on, 0 when
+// it is not shown. Selected lists every selected option of a choice field allowing several.
+type FormField struct {
+	Name     string   `json:"name"`
+	Type     string   `json:"type"`
+	Value    string   `json:"value"`
+	Selected []string `json:"selected,omitempty"`
+	Options  []string `json:"options,omitempty"`
+	Page     int      `json:"page"`
+}
+
+// DocumentMetadata represents what a PDF says about itself in its Info dictionary and XMP packet
+type DocumentMetadata struct {
+	Title      string     `json:"title,omitempty"`
+	Author     string     `json:"author,omitempty"`
+	Producer   string     `json:"producer,omitempty"`
+	CreatedAt  *time.Time `json:"created_at,omitempty"`
+	ModifiedAt *time.Time `json:"modified_at,omitempty"`
+	PDFVersion string     `json:"pdf_version,omitempty"`
+	PageCount  int        `json:"page_count,omitempty"`
 }
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// ProgressReport is sent by a worker to report how far it got with a leased file
+type ProgressReport struct {
+	LeaseToken     string `json:"lease_token"`
+	PagesProcessed int    `json:"pages_processed"`
+	TotalPages     int    `json:"total_pages"`
+	Stage          string `json:"stage,omitempty"`
+}
+
+// Progress represents how far the parsing of a file got, as last reported by its worker
+type Progress struct {
+	PagesProcessed int        `json:"pages_processed"`
+	TotalPages     int        `json:"total_pages"`
+	Stage          string     `json:"stage,omitempty"`
+	Percent        float64    `json:"percent"`
+	ETA            *time.Time `json:"eta,omitempty"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
@@ -1,8 +1,73 @@
 package models
 
-// Queue represents a file waiting for processing
+import "time"
+
+// Queue represents a file waiting for processing, or leased to the worker processing it
 type Queue struct {
-	ID      int    `json:"id"`
-	FileID  int    `json:"file_id"`
-	PDFFile []byte `json:"pdf_file"`
+	ID       int    `json:"id"`
+	FileID   int    `json:"file_id"`
+	BlobKey  string `json:"blob_key"`
+	FileSize int64  `json:"file_size"`
+	UserID   *int   `json:"user_id,omitempty"`
+	Priority string `json:"priority"`
+	// LeaseToken is set while the file is leased to a worker
+	LeaseToken  string     `json:"lease_token,omitempty"`
+	LeasedUntil *time.Time `json:"leased_until,omitempty"`
+	WorkerID    *int       `json:"worker_id,omitempty"`
+	ClaimedAt   *time.Time `json:"claimed_at,omitempty"`
+	// ParseDeadline is when the current attempt goes over its time budget, nil for no limit
+	ParseDeadline *time.Time `json:"parse_deadline,omitempty"`
+	Attempts      int        `json:"attempts"`
+	RetryAt       *time.Time `json:"retry_at,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+	NotBefore     *time.Time `json:"not_before,omitempty"`
+	// RequiredCapabilities must all be declared by a worker before it is handed the file
+	RequiredCapabilities []string `json:"required_capabilities"`
+	// MaxPages and MaxParseSeconds are the limits of the file, resolved when it is queued, 0 for no limit
+	MaxPages        int     `json:"max_pages,omitempty"`
+	MaxParseSeconds float64 `json:"max_parse_seconds,omitempty"`
+	// The progress last reported by the worker holding the lease
+	PagesProcessed *int       `json:"pages_processed,omitempty"`
+	TotalPages     *int       `json:"total_pages,omitempty"`
+	Stage          string     `json:"stage,omitempty"`
+	ProgressAt     *time.Time `json:"progress_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// Job represents a file claimed from the queue by a worker, held under a lease until a result is reported.
+// The PDF is kept in the blob store under BlobKey, and downloaded from DownloadURL.
+type Job struct {
+	FileID      int       `json:"file_id"`
+	LeaseToken  string    `json:"lease_token"`
+	LeaseExpiry time.Time `json:"lease_expiry"`
+	Attempt     int       `json:"attempt"`
+	FileHash    string    `json:"file_hash"`
+	Priority    string    `json:"priority"`
+	// RequiredCapabilities are the capabilities of the worker the file needs, such as ocr
+	RequiredCapabilities []string `json:"required_capabilities"`
+	// MaxPages is how many pages the worker may parse, 0 for no limit
+	MaxPages int `json:"max_pages,omitempty"`
+	// ParseDeadline is when the file goes over its time budget and ends in limit_exceeded, nil for no limit
+	ParseDeadline *time.Time `json:"parse_deadline,omitempty"`
+	FileSize      int64      `json:"file_size"`
+	DownloadURL   string     `json:"download_url"`
+	BlobKey       string     `json:"-"`
+}
+
+// QueueControl represents the administrative state of the queue, shared by every API instance
+type QueueControl struct {
+	State     string    `json:"state"`
+	ChangedAt time.Time `json:"changed_at"`
+	InFlight  int       `json:"in_flight"`
+	// Drained is set once a draining queue has no leased files left
+	Drained bool `json:"drained"`
+}
+
+// LaneDepth represents how many files are waiting in, and leased from, one priority lane of the queue
+type LaneDepth struct {
+	Priority string `json:"priority"`
+	Waiting  int    `json:"waiting"`
+	Leased   int    `json:"leased"`
+	// OldestAgeSeconds is how long the oldest waiting file has been in the queue
+	OldestAgeSeconds float64 `json:"oldest_age_seconds"`
 }
@@ -0,0 +1,43 @@
+package models
+
+import "time"
+
+// QueueStats represents the state of the parse pipeline, with rates computed over a sliding window
+type QueueStats struct {
+	WindowSeconds float64       `json:"window_seconds"`
+	ByStatus      []StatusStats `json:"by_status"`
+	ByPriority    []LaneStats   `json:"by_priority"`
+	Total         LaneStats     `json:"total"`
+	// UnroutableCount counts the waiting files that no live worker can parse, Unroutable lists the oldest ones
+	UnroutableCount int             `json:"unroutable_count"`
+	Unroutable      []UnroutableJob `json:"unroutable"`
+}
+
+// UnroutableJob represents a waiting file that needs capabilities no live worker declared
+type UnroutableJob struct {
+	FileID               int       `json:"file_id"`
+	Priority             string    `json:"priority"`
+	RequiredCapabilities []string  `json:"required_capabilities"`
+	QueuedAt             time.Time `json:"queued_at"`
+}
+
+// StatusStats represents how many files are in one status and how long the oldest has been in it
+type StatusStats struct {
+	Status           string  `json:"status"`
+	Depth            int     `json:"depth"`
+	OldestAgeSeconds float64 `json:"oldest_age_seconds"`
+}
+
+// LaneStats represents the backlog and throughput of one priority lane. Depth and age count the files waiting
+// to be claimed, completions and parse durations cover the successful parses and the error rate every finished
+// attempt.
+type LaneStats struct {
+	Priority           string  `json:"priority,omitempty"`
+	Depth              int     `json:"depth"`
+	OldestAgeSeconds   float64 `json:"oldest_age_seconds"`
+	ClaimedPerMinute   float64 `json:"claimed_per_minute"`
+	CompletedPerMinute float64 `json:"completed_per_minute"`
+	MeanParseSeconds   float64 `json:"mean_parse_seconds"`
+	P95ParseSeconds    float64 `json:"p95_parse_seconds"`
+	ErrorRate          float64 `json:"error_rate"`
+}
@@ -4,3 +4,11 @@ package models
 type User struct {
 	ID int `json:"id"`
 }
+
+// UserLimits represents the per-user overrides of the queue settings, nil falls back to the server default
+type UserLimits struct {
+	MaxInFlight     *int   `json:"max_in_flight"`
+	MaxFileBytes    *int64 `json:"max_file_bytes"`
+	MaxPages        *int   `json:"max_pages"`
+	MaxParseSeconds *int   `json:"max_parse_seconds"`
+}
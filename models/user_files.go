@@ -9,4 +9,10 @@ type UserFile struct {
 	Filename   string    `json:"filename"`
 	UploadDate time.Time `json:"upload_date"`
 	Status     string    `json:"status"`
+	// ScheduledFor is when a scheduled file becomes due for parsing
+	ScheduledFor *time.Time `json:"scheduled_for,omitempty"`
+	// Progress is reported while the file is being parsed
+	Progress *Progress `json:"progress,omitempty"`
+	// Metadata comes from the current parse result, it is missing until the file is parsed
+	Metadata *DocumentMetadata `json:"metadata,omitempty"`
 }
@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// Worker represents a parser process registered to claim files from the queue
+type Worker struct {
+	ID           int       `json:"id"`
+	Name         string    `json:"name"`
+	Version      string    `json:"version"`
+	Capabilities []string  `json:"capabilities"`
+	RegisteredAt time.Time `json:"registered_at"`
+	LastSeen     time.Time `json:"last_seen"`
+	Alive        bool      `json:"alive"`
+	CurrentJobs  []int     `json:"current_jobs"`
+}
+
+// Heartbeat is the answer to a worker's heartbeat, listing the files it should stop parsing
+type Heartbeat struct {
+	CancelledJobs []int `json:"cancelled_jobs"`
+}
@@ -0,0 +1,218 @@
+package parser
+
+import (
+	"unicode/utf16"
+)
+
+// codespace is a range of codes of the same length in bytes
+type codespace struct {
+	low, high []byte
+}
+
+// cmapRange maps a range of codes of one length to consecutive values
+type cmapRange struct {
+	size      int
+	low, high uint32
+	// text is the text of the first code, the last character is incremented for the following codes
+	text []rune
+	// texts lists the text of every code, when the range maps to an array
+	texts []string
+	// cid is the CID of the first code, for encoding CMaps
+	cid int
+}
+
+// cmap is a character map, either a ToUnicode map from codes to text or an encoding map from codes to CIDs
+type cmap struct {
+	codespaces []codespace
+	chars      map[string]string
+	ranges     []cmapRange
+	cids       map[string]int
+	cidRanges  []cmapRange
+}
+
+// parseCMap reads the codespaces and mappings of a CMap stream, ignoring everything else in its PostScript
+func parseCMap(data []byte) *cmap {
+	m := &cmap{chars: make(map[string]string), cids: make(map[string]int)}
+	l := &lexer{data: data}
+
+	var operands []Object
+	for !l.eof() {
+		obj, err := l.readObject()
+		if err != nil {
+			break
+		}
+		kw, ok := obj.(keyword)
+		if !ok {
+			operands = append(operands, obj)
+			continue
+		}
+
+		switch kw {
+		case "endcodespacerange":
+			for i := 0; i+1 < len(operands); i += 2 {
+				low, ok1 := operands[i].(String)
+				high, ok2 := operands[i+1].(String)
+				if ok1 && ok2 && len(low) == len(high) && len(low) > 0 {
+					m.codespaces = append(m.codespaces, codespace{low: low, high: high})
+				}
+			}
+		case "endbfchar":
+			for i := 0; i+1 < len(operands); i += 2 {
+				code, ok := operands[i].(String)
+				if !ok {
+					continue
+				}
+				switch dst := operands[i+1].(type) {
+				case String:
+					m.chars[string(code)] = utf16Text(dst)
+				case Name:
+					if text, ok := glyphText(string(dst)); ok {
+						m.chars[string(code)] = text
+					}
+				}
+			}
+		case "endbfrange":
+			for i := 0; i+2 < len(operands); i += 3 {
+				low, ok1 := operands[i].(String)
+				high, ok2 := operands[i+1].(String)
+				if !ok1 || !ok2 || len(low) != len(high) || len(low) == 0 || len(low) > 4 {
+					continue
+				}
+				r := cmapRange{size: len(low), low: codeValue(low), high: codeValue(high)}
+				switch dst := operands[i+2].(type) {
+				case String:
+					r.text = []rune(utf16Text(dst))
+				case Array:
+					for _, item := range dst {
+						text, _ := item.(String)
+						r.texts = append(r.texts, utf16Text(text))
+					}
+				}
+				m.ranges = append(m.ranges, r)
+			}
+		case "endcidchar":
+			for i := 0; i+1 < len(operands); i += 2 {
+				code, ok1 := operands[i].(String)
+				cid, ok2 := operands[i+1].(int)
+				if ok1 && ok2 {
+					m.cids[string(code)] = cid
+				}
+			}
+		case "endcidrange":
+			for i := 0; i+2 < len(operands); i += 3 {
+				low, ok1 := operands[i].(String)
+				high, ok2 := operands[i+1].(String)
+				cid, ok3 := operands[i+2].(int)
+				if ok1 && ok2 && ok3 && len(low) == len(high) && len(low) > 0 && len(low) <= 4 {
+					m.cidRanges = append(m.cidRanges, cmapRange{size: len(low), low: codeValue(low), high: codeValue(high), cid: cid})
+				}
+			}
+		}
+		operands = operands[:0]
+	}
+
+	return m
+}
+
+// utf16Text decodes the UTF-16BE text of a ToUnicode mapping
+func utf16Text(s String) string {
+	units := make([]uint16, 0, len(s)/2)
+	for i := 0; i+1 < len(s); i += 2 {
+		units = append(units, uint16(s[i])<<8|uint16(s[i+1]))
+	}
+	if len(s)%2 == 1 {
+		// Some writers store single-byte values
+		units = append(units, uint16(s[len(s)-1]))
+	}
+	return string(utf16.Decode(units))
+}
+
+func codeValue(code []byte) uint32 {
+	var v uint32
+	for _, c := range code {
+		v = v<<8 | uint32(c)
+	}
+	return v
+}
+
+// nextCode returns the length of the code starting s, following the codespaces of the map, or 0 without
+// codespaces
+func (m *cmap) nextCode(s []byte) int {
+	if m == nil || len(m.codespaces) == 0 {
+		return 0
+	}
+
+	for size := 1; size <= 4 && size <= len(s); size++ {
+		for _, cs := range m.codespaces {
+			if len(cs.low) != size {
+				continue
+			}
+			inside := true
+			for i := 0; i < size; i++ {
+				if s[i] < cs.low[i] || s[i] > cs.high[i] {
+					inside = false
+					break
+				}
+			}
+			if inside {
+				return size
+			}
+		}
+	}
+
+	// A code outside of every codespace takes the length of the shortest codespace
+	shortest := len(m.codespaces[0].low)
+	for _, cs := range m.codespaces[1:] {
+		shortest = min(shortest, len(cs.low))
+	}
+	return min(shortest, len(s))
+}
+
+// text returns the text mapped to a code
+func (m *cmap) text(code []byte) (string, bool) {
+	if m == nil {
+		return "", false
+	}
+	if text, ok := m.chars[string(code)]; ok {
+		return text, true
+	}
+
+	v := codeValue(code)
+	for _, r := range m.ranges {
+		if r.size != len(code) || v < r.low || v > r.high {
+			continue
+		}
+		offset := int(v - r.low)
+		if r.texts != nil {
+			if offset < len(r.texts) {
+				return r.texts[offset], true
+			}
+			return "", false
+		}
+		if len(r.text) == 0 {
+			return "", false
+		}
+		text := append([]rune(nil), r.text...)
+		text[len(text)-1] += rune(offset)
+		return string(text), true
+	}
+	return "", false
+}
+
+// cid returns the CID an encoding CMap maps a code to
+func (m *cmap) cid(code []byte) (int, bool) {
+	if m == nil {
+		return 0, false
+	}
+	if cid, ok := m.cids[string(code)]; ok {
+		return cid, true
+	}
+
+	v := codeValue(code)
+	for _, r := range m.cidRanges {
+		if r.size == len(code) && v >= r.low && v <= r.high {
+			return r.cid + int(v-r.low), true
+		}
+	}
+	return 0, false
+}
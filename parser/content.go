@@ -0,0 +1,357 @@
+package parser
+
+import (
+	"context"
+	"math"
+	"strings"
+)
+
+const (
+	// maxFormDepth bounds how deep form XObjects can be nested in each other
+	maxFormDepth = 16
+	// maxStateDepth bounds how many graphics states can be saved with q
+	maxStateDepth = 256
+	// cancelCheckInterval is how many operators are run between two checks for cancellation
+	cancelCheckInterval = 4096
+)
+
+// textState holds the text parameters of the graphics state
+type textState struct {
+	font      *font
+	fontSize  float64
+	charSpace float64
+	wordSpace float64
+	// scale is the horizontal scaling, 1 for 100%
+	scale   float64
+	leading float64
+	rise    float64
+}
+
+type graphicsState struct {
+	ctm  matrix
+	text textState
+}
+
+// extractor runs the text operators of content streams and writes the text they show, in the order it is
+// shown. Line breaks and spaces are inferred from where the glyphs are placed.
+type extractor struct {
+	d   *Document
+	ctx context.Context
+	out strings.Builder
+
+	state    graphicsState
+	saved    []graphicsState
+	tm, tlm  matrix
+	forms    map[int]bool
+	ops      int
+	started  bool
+	endX     float64
+	endY     float64
+	fontSize float64
+}
+
+// PageText extracts the text of a page by its number, starting from 1
+func (d *Document) PageText(ctx context.Context, number int) (string, error) {
+	p, err := d.page(number)
+	if err != nil {
+		return "", err
+	}
+
+	e := &extractor{
+		d:     d,
+		ctx:   ctx,
+		state: graphicsState{ctm: identity, text: textState{scale: 1}},
+		forms: make(map[int]bool),
+	}
+	err = e.run(d.contents(p), p.resources, 0)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(e.out.String()), nil
+}
+
+// run interprets a content stream with the given resources
+func (e *extractor) run(data []byte, resources Dict, depth int) error {
+	l := &lexer{data: data}
+	var operands []Object
+	for !l.eof() {
+		obj, err := l.readObject()
+		if err != nil {
+			// The rest of a damaged content stream cannot be read
+			return nil
+		}
+		op, ok := obj.(keyword)
+		if !ok {
+			operands = append(operands, obj)
+			continue
+		}
+
+		e.ops++
+		if e.ops%cancelCheckInterval == 0 && e.ctx.Err() != nil {
+			return e.ctx.Err()
+		}
+
+		if op == "ID" {
+			l.skipInlineImage()
+		} else if err := e.operator(op, operands, resources, depth); err != nil {
+			return err
+		}
+		operands = operands[:0]
+	}
+	return nil
+}
+
+func (e *extractor) operator(op keyword, operands []Object, resources Dict, depth int) error {
+	ts := &e.state.text
+	switch op {
+	case "q":
+		if len(e.saved) < maxStateDepth {
+			e.saved = append(e.saved, e.state)
+		}
+	case "Q":
+		if n := len(e.saved); n > 0 {
+			e.state = e.saved[n-1]
+			e.saved = e.saved[:n-1]
+		}
+	case "cm":
+		if m, ok := numbers(operands, 6); ok {
+			e.state.ctm = matrix(m).multiply(e.state.ctm)
+		}
+	case "BT":
+		e.tm, e.tlm = identity, identity
+	case "Tf":
+		if len(operands) == 2 {
+			name, _ := operands[0].(Name)
+			ts.font = e.d.fontResource(resources, name)
+			ts.fontSize, _ = number(operands[1])
+		}
+	case "Tc":
+		if v, ok := numbers(operands, 1); ok {
+			ts.charSpace = v[0]
+		}
+	case "Tw":
+		if v, ok := numbers(operands, 1); ok {
+			ts.wordSpace = v[0]
+		}
+	case "Tz":
+		if v, ok := numbers(operands, 1); ok {
+			ts.scale = v[0] / 100
+		}
+	case "TL":
+		if v, ok := numbers(operands, 1); ok {
+			ts.leading = v[0]
+		}
+	case "Ts":
+		if v, ok := numbers(operands, 1); ok {
+			ts.rise = v[0]
+		}
+	case "Td":
+		if v, ok := numbers(operands, 2); ok {
+			e.moveLine(v[0], v[1])
+		}
+	case "TD":
+		if v, ok := numbers(operands, 2); ok {
+			ts.leading = -v[1]
+			e.moveLine(v[0], v[1])
+		}
+	case "Tm":
+		if m, ok := numbers(operands, 6); ok {
+			e.tm, e.tlm = matrix(m), matrix(m)
+		}
+	case "T*":
+		e.moveLine(0, -ts.leading)
+	case "Tj":
+		if len(operands) == 1 {
+			s, _ := operands[0].(String)
+			e.show(s)
+		}
+	case "'":
+		e.moveLine(0, -ts.leading)
+		if len(operands) == 1 {
+			s, _ := operands[0].(String)
+			e.show(s)
+		}
+	case "\"":
+		if len(operands) == 3 {
+			ts.wordSpace, _ = number(operands[0])
+			ts.charSpace, _ = number(operands[1])
+			e.moveLine(0, -ts.leading)
+			s, _ := operands[2].(String)
+			e.show(s)
+		}
+	case "TJ":
+		if len(operands) == 1 {
+			arr, _ := operands[0].(Array)
+			for _, item := range arr {
+				switch v := item.(type) {
+				case String:
+					e.show(v)
+				case int, float64:
+					// Numbers move the next glyph back by thousandths of the font size
+					offset, _ := number(v)
+					e.tm = matrix{1, 0, 0, 1, -offset / 1000 * ts.fontSize * ts.scale, 0}.multiply(e.tm)
+				}
+			}
+		}
+	case "Do":
+		if len(operands) == 1 {
+			name, _ := operands[0].(Name)
+			return e.form(resources, name, depth)
+		}
+	}
+	return nil
+}
+
+// numbers returns the last n operands as numbers
+func numbers(operands []Object, n int) ([]float64, bool) {
+	if len(operands) < n {
+		return nil, false
+	}
+	values := make([]float64, n)
+	for i, obj := range operands[len(operands)-n:] {
+		v, ok := number(obj)
+		if !ok {
+			return nil, false
+		}
+		values[i] = v
+	}
+	return values, true
+}
+
+// moveLine starts a new line offset from the start of the current one
+func (e *extractor) moveLine(tx, ty float64) {
+	e.tlm = matrix{1, 0, 0, 1, tx, ty}.multiply(e.tlm)
+	e.tm = e.tlm
+}
+
+// show writes the text of a string and moves past its glyphs
+func (e *extractor) show(s String) {
+	ts := e.state.text
+	if ts.font == nil {
+		return
+	}
+
+	for _, g := range ts.font.decode(s) {
+		trm := matrix{ts.fontSize * ts.scale, 0, 0, ts.fontSize, 0, ts.rise}.multiply(e.tm).multiply(e.state.ctm)
+		e.place(trm, g.text)
+
+		advance := g.width*ts.fontSize + ts.charSpace
+		if g.space {
+			advance += ts.wordSpace
+		}
+		e.tm = matrix{1, 0, 0, 1, advance * ts.scale, 0}.multiply(e.tm)
+
+		end := matrix{1, 0, 0, 1, 0, ts.rise}.multiply(e.tm).multiply(e.state.ctm)
+		e.endX, e.endY = end[4], end[5]
+	}
+}
+
+// place writes the text of a glyph drawn with the text rendering matrix trm, preceded by a line break when it
+// starts a new line and by a space when it is set apart from the previous glyph
+func (e *extractor) place(trm matrix, text string) {
+	size := math.Hypot(trm[2], trm[3])
+	if size == 0 {
+		size = 1
+	}
+	dirX, dirY := trm[0], trm[1]
+	if length := math.Hypot(dirX, dirY); length > 0 {
+		dirX, dirY = dirX/length, dirY/length
+	} else {
+		dirX, dirY = 1, 0
+	}
+
+	if e.started && text != "" {
+		dx, dy := trm[4]-e.endX, trm[5]-e.endY
+		along := dx*dirX + dy*dirY
+		across := dy*dirX - dx*dirY
+		// Compare with the smaller of the two sizes, so a large heading is not merged with a small line
+		lineSize := min(size, e.fontSize)
+		if lineSize <= 0 {
+			lineSize = size
+		}
+
+		switch {
+		case math.Abs(across) > lineSize*0.5:
+			e.newLine()
+		case along > lineSize*0.2:
+			e.space(text)
+		}
+	}
+
+	if text != "" {
+		e.out.WriteString(text)
+		e.started = true
+		e.fontSize = size
+	}
+}
+
+func (e *extractor) newLine() {
+	out := e.out.String()
+	if out != "" && !strings.HasSuffix(out, "\n") {
+		e.out.WriteByte('\n')
+	}
+}
+
+func (e *extractor) space(next string) {
+	out := e.out.String()
+	if out == "" || strings.HasSuffix(out, " ") || strings.HasSuffix(out, "\n") || strings.HasPrefix(next, " ") {
+		return
+	}
+	e.out.WriteByte(' ')
+}
+
+// fontResource loads a font of the resources, caching the fonts that are indirect objects
+func (d *Document) fontResource(resources Dict, name Name) *font {
+	obj := d.dict(resources["Font"])[name]
+	ref, isRef := obj.(Ref)
+	if isRef {
+		if f, ok := d.fonts[ref.Num]; ok {
+			return f
+		}
+	}
+
+	dict := d.dict(obj)
+	if dict == nil {
+		return nil
+	}
+	f := d.loadFont(dict)
+	if isRef {
+		d.fonts[ref.Num] = f
+	}
+	return f
+}
+
+// form runs the content stream of a form XObject, images and other XObjects show no text
+func (e *extractor) form(resources Dict, name Name, depth int) error {
+	obj := e.d.dict(resources["XObject"])[name]
+	stream := e.d.stream(obj)
+	if stream == nil || stream.Dict["Subtype"] != Name("Form") || depth >= maxFormDepth {
+		return nil
+	}
+	// A form drawing itself, directly or through other forms, is only drawn once
+	if ref, ok := obj.(Ref); ok {
+		if e.forms[ref.Num] {
+			return nil
+		}
+		e.forms[ref.Num] = true
+		defer delete(e.forms, ref.Num)
+	}
+
+	data, err := e.d.decodeStream(stream)
+	if err != nil {
+		return nil
+	}
+	formResources := e.d.dict(stream.Dict["Resources"])
+	if formResources == nil {
+		formResources = resources
+	}
+
+	saved := e.state
+	savedDepth := len(e.saved)
+	e.state.ctm = toMatrix(e.d.array(stream.Dict["Matrix"])).multiply(e.state.ctm)
+	err = e.run(data, formResources, depth+1)
+	e.state = saved
+	e.saved = e.saved[:min(savedDepth, len(e.saved))]
+	return err
+}
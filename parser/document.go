@@ -0,0 +1,533 @@
+package parser
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+)
+
+// maxRefDepth bounds how many references are followed to reach an object
+const maxRefDepth = 32
+
+// xrefEntry locates an object, either at an offset in the file or inside an object stream
+type xrefEntry struct {
+	offset   int
+	gen      int
+	inStream bool
+	stream   int
+}
+
+// objectStream holds the decoded data of an object stream and where each of its objects starts
+type objectStream struct {
+	data    []byte
+	offsets map[int]int
+}
+
+// Document is a PDF file opened for reading. The whole file is held in memory.
+type Document struct {
+	data    []byte
+	version string
+	trailer Dict
+	xref    map[int]xrefEntry
+
+	objects map[int]Object
+	loading map[int]bool
+	streams map[int]*objectStream
+	// scanned holds the objects found by scanning the file, used when the cross-reference table is wrong
+	scanned map[int]int
+	fonts   map[int]*font
+
+	pages []page
+}
+
+var (
+	headerPattern = regexp.MustCompile(`%PDF-(\d\.\d)`)
+	objectPattern = regexp.MustCompile(`(?m)(?:^|[\s>])(\d+)[ \t\r\n]+(\d+)[ \t\r\n]+obj\b`)
+)
+
+// Open reads the cross-reference table and the page tree of a PDF. A damaged cross-reference table is
+// rebuilt by scanning the file for its objects.
+func Open(data []byte) (*Document, error) {
+	header := headerPattern.FindSubmatch(data[:min(len(data), 1024)])
+	if header == nil {
+		return nil, ErrNotPDF
+	}
+
+	d := &Document{
+		data:    data,
+		version: string(header[1]),
+		xref:    make(map[int]xrefEntry),
+		objects: make(map[int]Object),
+		loading: make(map[int]bool),
+		streams: make(map[int]*objectStream),
+		fonts:   make(map[int]*font),
+	}
+
+	err := d.readXref()
+	if err != nil || d.catalog() == nil {
+		d.rebuildXref()
+		if d.catalog() == nil {
+			return nil, ErrMalformed
+		}
+	}
+
+	if d.trailer["Encrypt"] != nil {
+		return nil, ErrEncrypted
+	}
+
+	// The catalog can name a later version than the header
+	if version, ok := d.resolve(d.catalog()["Version"]).(Name); ok && string(version) > d.version {
+		d.version = string(version)
+	}
+
+	d.pages = d.collectPages()
+	return d, nil
+}
+
+// Version returns the PDF version of the document, such as 1.7
+func (d *Document) Version() string {
+	return d.version
+}
+
+// NumPages returns the number of pages of the document
+func (d *Document) NumPages() int {
+	return len(d.pages)
+}
+
+// catalog returns the document catalog, the root of the object tree
+func (d *Document) catalog() Dict {
+	return d.dict(d.trailer["Root"])
+}
+
+// readXref reads the cross-reference sections starting from the last one, which takes precedence
+func (d *Document) readXref() error {
+	i := bytes.LastIndex(d.data, []byte("startxref"))
+	if i < 0 {
+		return ErrMalformed
+	}
+	l := &lexer{data: d.data, pos: i + len("startxref")}
+	offset, ok := mustObject(l).(int)
+	if !ok {
+		return ErrMalformed
+	}
+
+	visited := make(map[int]bool)
+	for offset > 0 && offset < len(d.data) && !visited[offset] {
+		visited[offset] = true
+
+		trailer, err := d.readXrefSection(offset)
+		if err != nil {
+			return err
+		}
+		if d.trailer == nil {
+			d.trailer = trailer
+		}
+
+		// A hybrid file keeps the objects of object streams in a cross-reference stream next to the table
+		if stm, ok := trailer["XRefStm"].(int); ok && !visited[stm] {
+			visited[stm] = true
+			if _, err := d.readXrefSection(stm); err != nil {
+				return err
+			}
+		}
+
+		offset, _ = trailer["Prev"].(int)
+	}
+
+	return nil
+}
+
+// readXrefSection reads a cross-reference table or stream at the offset and returns its trailer. Entries
+// already known from a later section are kept.
+func (d *Document) readXrefSection(offset int) (Dict, error) {
+	l := &lexer{data: d.data, pos: offset}
+	l.skipSpace()
+	if bytes.HasPrefix(d.data[l.pos:], []byte("xref")) {
+		l.pos += len("xref")
+		return d.readXrefTable(l)
+	}
+
+	_, obj, err := d.readIndirect(l)
+	if err != nil {
+		return nil, err
+	}
+	stream, ok := obj.(*Stream)
+	if !ok {
+		return nil, ErrMalformed
+	}
+	return stream.Dict, d.readXrefStream(stream)
+}
+
+func (d *Document) readXrefTable(l *lexer) (Dict, error) {
+	for {
+		obj, err := l.readObject()
+		if err != nil {
+			return nil, err
+		}
+		if obj == keyword("trailer") {
+			break
+		}
+		start, ok1 := obj.(int)
+		count, ok2 := mustObject(l).(int)
+		if !ok1 || !ok2 {
+			return nil, ErrMalformed
+		}
+
+		for i := 0; i < count; i++ {
+			offset, ok1 := mustObject(l).(int)
+			gen, ok2 := mustObject(l).(int)
+			kind, ok3 := mustObject(l).(keyword)
+			if !ok1 || !ok2 || !ok3 {
+				return nil, ErrMalformed
+			}
+			if _, known := d.xref[start+i]; known {
+				continue
+			}
+			if kind == "n" {
+				d.xref[start+i] = xrefEntry{offset: offset, gen: gen}
+			} else {
+				// A free entry hides the object from older sections
+				d.xref[start+i] = xrefEntry{offset: -1}
+			}
+		}
+	}
+
+	trailer, ok := mustObject(l).(Dict)
+	if !ok {
+		return nil, ErrMalformed
+	}
+	return trailer, nil
+}
+
+func (d *Document) readXrefStream(stream *Stream) error {
+	data, err := d.decodeStream(stream)
+	if err != nil {
+		return err
+	}
+
+	var widths [3]int
+	w := d.array(stream.Dict["W"])
+	if len(w) != 3 {
+		return ErrMalformed
+	}
+	for i := range widths {
+		widths[i], _ = d.resolve(w[i]).(int)
+		if widths[i] < 0 || widths[i] > 8 {
+			return ErrMalformed
+		}
+	}
+	rowSize := widths[0] + widths[1] + widths[2]
+	if rowSize == 0 {
+		return ErrMalformed
+	}
+
+	index := d.array(stream.Dict["Index"])
+	if index == nil {
+		size, _ := d.resolve(stream.Dict["Size"]).(int)
+		index = Array{0, size}
+	}
+
+	pos := 0
+	for i := 0; i+1 < len(index); i += 2 {
+		start, _ := d.resolve(index[i]).(int)
+		count, _ := d.resolve(index[i+1]).(int)
+		for n := 0; n < count && pos+rowSize <= len(data); n++ {
+			row := data[pos : pos+rowSize]
+			pos += rowSize
+
+			kind := 1
+			if widths[0] > 0 {
+				kind = readField(row[:widths[0]])
+			}
+			field2 := readField(row[widths[0] : widths[0]+widths[1]])
+			field3 := readField(row[widths[0]+widths[1]:])
+
+			if _, known := d.xref[start+n]; known {
+				continue
+			}
+			switch kind {
+			case 0:
+				d.xref[start+n] = xrefEntry{offset: -1}
+			case 1:
+				d.xref[start+n] = xrefEntry{offset: field2, gen: field3}
+			case 2:
+				d.xref[start+n] = xrefEntry{inStream: true, stream: field2}
+			}
+		}
+	}
+
+	return nil
+}
+
+// readField reads a big-endian number of a cross-reference stream row
+func readField(b []byte) int {
+	v := 0
+	for _, c := range b {
+		v = v<<8 | int(c)
+	}
+	return v
+}
+
+// rebuildXref scans the whole file for objects, for files whose cross-reference table is missing or wrong
+func (d *Document) rebuildXref() {
+	d.xref = make(map[int]xrefEntry)
+	d.objects = make(map[int]Object)
+	d.streams = make(map[int]*objectStream)
+	for num, offset := range d.scan() {
+		d.xref[num] = xrefEntry{offset: offset}
+	}
+
+	// The trailer is the last one in the file, or the dictionary of the last cross-reference stream
+	trailer := Dict{}
+	if i := bytes.LastIndex(d.data, []byte("trailer")); i >= 0 {
+		l := &lexer{data: d.data, pos: i + len("trailer")}
+		if dict, ok := mustObject(l).(Dict); ok {
+			trailer = dict
+		}
+	}
+	for num := range d.xref {
+		stream, ok := d.object(num).(*Stream)
+		if !ok {
+			continue
+		}
+		if stream.Dict["Type"] == Name("XRef") && trailer["Root"] == nil {
+			trailer = stream.Dict
+		}
+		// Objects compressed in object streams are only found through the streams themselves
+		if stream.Dict["Type"] == Name("ObjStm") {
+			if objStm, err := d.objectStream(num); err == nil {
+				for inner := range objStm.offsets {
+					if _, known := d.xref[inner]; !known {
+						d.xref[inner] = xrefEntry{inStream: true, stream: num}
+					}
+				}
+			}
+		}
+	}
+
+	// Without a usable trailer, look for the catalog itself
+	if d.dict(trailer["Root"]) == nil {
+		for num := range d.xref {
+			if dict, ok := d.object(num).(Dict); ok && dict["Type"] == Name("Catalog") {
+				trailer["Root"] = Ref{Num: num}
+				break
+			}
+		}
+	}
+	d.trailer = trailer
+}
+
+// scan finds where every object starts in the file, a later definition of the same object wins
+func (d *Document) scan() map[int]int {
+	if d.scanned != nil {
+		return d.scanned
+	}
+
+	d.scanned = make(map[int]int)
+	for _, match := range objectPattern.FindAllSubmatchIndex(d.data, -1) {
+		num, err := strconv.Atoi(string(d.data[match[2]:match[3]]))
+		if err != nil {
+			continue
+		}
+		d.scanned[num] = match[2]
+	}
+	return d.scanned
+}
+
+// object loads an indirect object, or returns nil when it does not exist or cannot be read
+func (d *Document) object(num int) Object {
+	if obj, ok := d.objects[num]; ok {
+		return obj
+	}
+	// An object referring to itself while it is read, such as through the length of its own stream
+	if d.loading[num] {
+		return nil
+	}
+	d.loading[num] = true
+	defer delete(d.loading, num)
+
+	obj := d.loadObject(num)
+	d.objects[num] = obj
+	return obj
+}
+
+func (d *Document) loadObject(num int) Object {
+	entry, ok := d.xref[num]
+	if !ok || entry.offset < 0 {
+		return nil
+	}
+
+	if entry.inStream {
+		objStm, err := d.objectStream(entry.stream)
+		if err != nil {
+			return nil
+		}
+		offset, ok := objStm.offsets[num]
+		if !ok {
+			return nil
+		}
+		obj, err := (&lexer{data: objStm.data, pos: offset}).readObject()
+		if err != nil {
+			return nil
+		}
+		return obj
+	}
+
+	if entry.offset < len(d.data) {
+		gotNum, obj, err := d.readIndirect(&lexer{data: d.data, pos: entry.offset})
+		if err == nil && gotNum == num {
+			return obj
+		}
+	}
+
+	// The offset is wrong, look for the object where it really is
+	if offset, ok := d.scan()[num]; ok && offset != entry.offset {
+		gotNum, obj, err := d.readIndirect(&lexer{data: d.data, pos: offset})
+		if err == nil && gotNum == num {
+			return obj
+		}
+	}
+	return nil
+}
+
+// readIndirect reads "num gen obj" followed by the object and, for a stream, its data
+func (d *Document) readIndirect(l *lexer) (int, Object, error) {
+	num, ok1 := mustObject(l).(int)
+	_, ok2 := mustObject(l).(int)
+	kw, ok3 := mustObject(l).(keyword)
+	if !ok1 || !ok2 || !ok3 || kw != "obj" {
+		return 0, nil, ErrMalformed
+	}
+
+	obj, err := l.readObject()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	dict, ok := obj.(Dict)
+	if !ok {
+		return num, obj, nil
+	}
+	save := l.pos
+	if next, _ := l.readObject(); next != keyword("stream") {
+		l.pos = save
+		return num, dict, nil
+	}
+
+	// The data starts after the end of line following the keyword
+	if l.pos < len(d.data) && d.data[l.pos] == '\r' {
+		l.pos++
+	}
+	if l.pos < len(d.data) && d.data[l.pos] == '\n' {
+		l.pos++
+	}
+	start := l.pos
+
+	if length, ok := d.resolve(dict["Length"]).(int); ok && length >= 0 && start+length <= len(d.data) {
+		end := &lexer{data: d.data, pos: start + length}
+		end.skipSpace()
+		if bytes.HasPrefix(d.data[end.pos:], []byte("endstream")) {
+			return num, &Stream{Dict: dict, raw: d.data[start : start+length]}, nil
+		}
+	}
+
+	// The length is missing or wrong, the data runs up to the endstream keyword
+	i := bytes.Index(d.data[start:], []byte("endstream"))
+	if i < 0 {
+		return 0, nil, ErrMalformed
+	}
+	raw := d.data[start : start+i]
+	raw = bytes.TrimSuffix(raw, []byte("\n"))
+	raw = bytes.TrimSuffix(raw, []byte("\r"))
+	return num, &Stream{Dict: dict, raw: raw}, nil
+}
+
+// objectStream decodes an object stream and reads where each of its objects starts
+func (d *Document) objectStream(num int) (*objectStream, error) {
+	if objStm, ok := d.streams[num]; ok {
+		if objStm == nil {
+			return nil, ErrMalformed
+		}
+		return objStm, nil
+	}
+	d.streams[num] = nil
+
+	stream, ok := d.object(num).(*Stream)
+	if !ok {
+		return nil, ErrMalformed
+	}
+	data, err := d.decodeStream(stream)
+	if err != nil {
+		return nil, err
+	}
+	count, _ := d.resolve(stream.Dict["N"]).(int)
+	first, _ := d.resolve(stream.Dict["First"]).(int)
+	if first < 0 || first > len(data) {
+		return nil, ErrMalformed
+	}
+
+	objStm := &objectStream{data: data, offsets: make(map[int]int)}
+	l := &lexer{data: data[:first]}
+	for i := 0; i < count; i++ {
+		inner, ok1 := mustObject(l).(int)
+		offset, ok2 := mustObject(l).(int)
+		if !ok1 || !ok2 {
+			break
+		}
+		if offset >= 0 && first+offset < len(data) {
+			objStm.offsets[inner] = first + offset
+		}
+	}
+
+	d.streams[num] = objStm
+	return objStm, nil
+}
+
+// mustObject reads the next object, or nil when there is none
+func mustObject(l *lexer) Object {
+	obj, err := l.readObject()
+	if err != nil {
+		return nil
+	}
+	return obj
+}
+
+// resolve follows references until it reaches a direct object
+func (d *Document) resolve(obj Object) Object {
+	for i := 0; i < maxRefDepth; i++ {
+		ref, ok := obj.(Ref)
+		if !ok {
+			return obj
+		}
+		obj = d.object(ref.Num)
+	}
+	return nil
+}
+
+// dict resolves obj to a dictionary, the dictionary of a stream included, or nil
+func (d *Document) dict(obj Object) Dict {
+	switch v := d.resolve(obj).(type) {
+	case Dict:
+		return v
+	case *Stream:
+		return v.Dict
+	}
+	return nil
+}
+
+// array resolves obj to an array, or nil
+func (d *Document) array(obj Object) Array {
+	arr, _ := d.resolve(obj).(Array)
+	return arr
+}
+
+// stream resolves obj to a stream, or nil
+func (d *Document) stream(obj Object) *Stream {
+	stream, _ := d.resolve(obj).(*Stream)
+	return stream
+}
+
+// number resolves obj to a number
+func (d *Document) number(obj Object) (float64, bool) {
+	return number(d.resolve(obj))
+}
@@ -0,0 +1,185 @@
+package parser
+
+import (
+	"strconv"
+	"strings"
+)
+
+// asciiNames are the glyph names of the printable ASCII characters, from space to asciitilde
+var asciiNames = strings.Fields(`space exclam quotedbl numbersign dollar percent ampersand quotesingle
+	parenleft parenright asterisk plus comma hyphen period slash zero one two three four five six seven eight
+	nine colon semicolon less equal greater question at A B C D E F G H I J K L M N O P Q R S T U V W X Y Z
+	bracketleft backslash bracketright asciicircum underscore grave a b c d e f g h i j k l m n o p q r s t u v
+	w x y z braceleft bar braceright asciitilde`)
+
+// latin1Names are the glyph names of the Latin-1 characters from U+00A0 to U+00FF
+var latin1Names = strings.Fields(`nbspace exclamdown cent sterling currency yen brokenbar section dieresis
+	copyright ordfeminine guillemotleft logicalnot softhyphen registered macron degree plusminus twosuperior
+	threesuperior acute mu paragraph periodcentered cedilla onesuperior ordmasculine guillemotright onequarter
+	onehalf threequarters questiondown Agrave Aacute Acircumflex Atilde Adieresis Aring AE Ccedilla Egrave
+	Eacute Ecircumflex Edieresis Igrave Iacute Icircumflex Idieresis Eth Ntilde Ograve Oacute Ocircumflex
+	Otilde Odieresis multiply Oslash Ugrave Uacute Ucircumflex Udieresis Yacute Thorn germandbls agrave aacute
+	acircumflex atilde adieresis aring ae ccedilla egrave eacute ecircumflex edieresis igrave iacute
+	icircumflex idieresis eth ntilde ograve oacute ocircumflex otilde odieresis divide oslash ugrave uacute
+	ucircumflex udieresis yacute thorn ydieresis`)
+
+// glyphNames maps the glyph names used by the standard encodings to their text. Ligatures are spelled out,
+// so the extracted text can be searched.
+var glyphNames = map[string]string{
+	"quoteleft": "‘", "quoteright": "’", "quotedblleft": "“", "quotedblright": "”",
+	"quotesinglbase": "‚", "quotedblbase": "„", "guilsinglleft": "‹", "guilsinglright": "›",
+	"bullet": "•", "endash": "–", "emdash": "—", "ellipsis": "…", "dagger": "†",
+	"daggerdbl": "‡", "perthousand": "‰", "fraction": "⁄", "florin": "ƒ", "Euro": "€",
+	"trademark": "™", "minus": "−", "Lslash": "Ł", "lslash": "ł", "OE": "Œ",
+	"oe": "œ", "Scaron": "Š", "scaron": "š", "Zcaron": "Ž", "zcaron": "ž",
+	"Ydieresis": "Ÿ", "dotlessi": "ı", "circumflex": "ˆ", "tilde": "˜", "breve": "˘",
+	"dotaccent": "˙", "ring": "˚", "ogonek": "˛", "caron": "ˇ", "hungarumlaut": "˝",
+	"fi": "fi", "fl": "fl", "ff": "ff", "ffi": "ffi", "ffl": "ffl", "dotlessj": "ȷ", "space": " ",
+	"nonbreakingspace": " ", "hyphen": "-", "sfthyphen": "­", "lozenge": "◊",
+	"notequal": "≠", "infinity": "∞", "lessequal": "≤", "greaterequal": "≥",
+	"partialdiff": "∂", "summation": "∑", "product": "∏", "pi": "π", "integral": "∫",
+	"Omega": "Ω", "radical": "√", "approxequal": "≈", "Delta": "∆", "apple": "",
+}
+
+func init() {
+	for i, name := range asciiNames {
+		if _, ok := glyphNames[name]; !ok {
+			glyphNames[name] = string(rune(0x20 + i))
+		}
+	}
+	for i, name := range latin1Names {
+		if _, ok := glyphNames[name]; !ok {
+			glyphNames[name] = string(rune(0xa0 + i))
+		}
+	}
+}
+
+// glyphText returns the text of a glyph name, including the uniXXXX and uXXXX forms and names with a suffix
+// such as a.sc or composed such as f_f. It returns false for names without a known meaning.
+func glyphText(name string) (string, bool) {
+	if text, ok := glyphNames[name]; ok {
+		return text, true
+	}
+
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		return glyphText(name[:i])
+	}
+	if strings.Contains(name, "_") {
+		var text strings.Builder
+		for _, part := range strings.Split(name, "_") {
+			partText, ok := glyphText(part)
+			if !ok {
+				return "", false
+			}
+			text.WriteString(partText)
+		}
+		return text.String(), true
+	}
+
+	if hex, ok := strings.CutPrefix(name, "uni"); ok && len(hex) >= 4 && len(hex)%4 == 0 {
+		var text strings.Builder
+		for i := 0; i < len(hex); i += 4 {
+			v, err := strconv.ParseUint(hex[i:i+4], 16, 16)
+			if err != nil {
+				return "", false
+			}
+			text.WriteRune(rune(v))
+		}
+		return text.String(), true
+	}
+	if hex, ok := strings.CutPrefix(name, "u"); ok && len(hex) >= 4 && len(hex) <= 6 {
+		if v, err := strconv.ParseUint(hex, 16, 32); err == nil {
+			return string(rune(v)), true
+		}
+	}
+	return "", false
+}
+
+// encoding maps the 256 codes of a simple font to their text, an empty string for unmapped codes
+type encoding [256]string
+
+var (
+	standardEncoding = newEncoding(map[byte]rune{
+		0x27: '’', 0x60: '‘', 0xa1: '¡', 0xa2: '¢', 0xa3: '£', 0xa4: '⁄', 0xa5: '¥', 0xa6: 'ƒ',
+		0xa7: '§', 0xa8: '¤', 0xa9: '\'', 0xaa: '“', 0xab: '«', 0xac: '‹', 0xad: '›', 0xae: 'ﬁ',
+		0xaf: 'ﬂ', 0xb1: '–', 0xb2: '†', 0xb3: '‡', 0xb4: '·', 0xb6: '¶', 0xb7: '•', 0xb8: '‚', 0xb9: '„',
+		0xba: '”', 0xbb: '»', 0xbc: '…', 0xbd: '‰', 0xbf: '¿', 0xc1: '`', 0xc2: '´', 0xc3: 'ˆ', 0xc4: '˜',
+		0xc5: '¯', 0xc6: '˘', 0xc7: '˙', 0xc8: '¨', 0xca: '˚', 0xcb: '¸', 0xcd: '˝', 0xce: '˛', 0xcf: 'ˇ',
+		0xd0: '—', 0xe1: 'Æ', 0xe3: 'ª', 0xe8: 'Ł', 0xe9: 'Ø', 0xea: 'Œ', 0xeb: 'º', 0xf1: 'æ', 0xf5: 'ı',
+		0xf8: 'ł', 0xf9: 'ø', 0xfa: 'œ', 0xfb: 'ß',
+	}, false)
+	winAnsiEncoding = newEncoding(map[byte]rune{
+		0x80: '€', 0x82: '‚', 0x83: 'ƒ', 0x84: '„', 0x85: '…', 0x86: '†', 0x87: '‡', 0x88: 'ˆ', 0x89: '‰',
+		0x8a: 'Š', 0x8b: '‹', 0x8c: 'Œ', 0x8e: 'Ž', 0x91: '‘', 0x92: '’', 0x93: '“', 0x94: '”', 0x95: '•',
+		0x96: '–', 0x97: '—', 0x98: '˜', 0x99: '™', 0x9a: 'š', 0x9b: '›', 0x9c: 'œ', 0x9e: 'ž', 0x9f: 'Ÿ',
+	}, true)
+	macRomanEncoding = newEncoding(runeTable(0x80, []rune(
+		"ÄÅÇÉÑÖÜáàâäãåçéèêëíìîïñóòôöõúùûü†°¢£§•¶ß®©™´¨≠ÆØ∞±≤≥¥µ∂∑∏π∫ªºΩæø¿¡¬√ƒ≈∆«»… ÀÃÕŒœ–—“”‘’÷◊ÿŸ⁄¤‹›ﬁﬂ‡·‚„‰ÂÊÁËÈÍÎÏÌÓÔÒÚÛÙıˆ˜¯˘˙˚¸˝˛ˇ")), false)
+	// pdfDocEncoding is the encoding of text strings without a byte order mark, such as in the Info dictionary
+	pdfDocEncoding = newEncoding(mergeRunes(
+		runeTable(0x18, []rune("˘ˇˆ˙˝˛˚˜")),
+		runeTable(0x80, []rune("•†‡…—–ƒ⁄‹›−‰„“”‘’‚™ﬁﬂŁŒŠŸŽıłœšž�€")),
+	), true)
+)
+
+func runeTable(first byte, runes []rune) map[byte]rune {
+	table := make(map[byte]rune, len(runes))
+	for i, r := range runes {
+		table[first+byte(i)] = r
+	}
+	return table
+}
+
+func mergeRunes(tables ...map[byte]rune) map[byte]rune {
+	merged := make(map[byte]rune)
+	for _, table := range tables {
+		for code, r := range table {
+			merged[code] = r
+		}
+	}
+	return merged
+}
+
+// newEncoding builds an encoding that is ASCII below 0x7f, changed by the given codes. With latin1 the codes
+// from 0xa0 up are the Latin-1 characters.
+func newEncoding(changes map[byte]rune, latin1 bool) *encoding {
+	var enc encoding
+	for code := 0x20; code < 0x7f; code++ {
+		enc[code] = string(rune(code))
+	}
+	if latin1 {
+		for code := 0xa0; code <= 0xff; code++ {
+			enc[code] = string(rune(code))
+		}
+	}
+	for code, r := range changes {
+		enc[code] = string(r)
+	}
+	// Ligatures are spelled out, so the extracted text can be searched
+	for code, text := range enc {
+		switch text {
+		case "ﬁ":
+			enc[code] = "fi"
+		case "ﬂ":
+			enc[code] = "fl"
+		case "�":
+			enc[code] = ""
+		}
+	}
+	return &enc
+}
+
+// namedEncoding returns the standard encoding of the given name, or nil
+func namedEncoding(name Name) *encoding {
+	switch name {
+	case "StandardEncoding":
+		return standardEncoding
+	case "WinAnsiEncoding":
+		return winAnsiEncoding
+	case "MacRomanEncoding":
+		return macRomanEncoding
+	case "PDFDocEncoding":
+		return pdfDocEncoding
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+package parser
+
+import "errors"
+
+var (
+	// ErrNotPDF is returned for data that does not start with a PDF header
+	ErrNotPDF = errors.New("File is not a PDF")
+	// ErrMalformed is returned when the structure of the file cannot be read
+	ErrMalformed = errors.New("PDF is malformed")
+	// ErrEncrypted is returned for encrypted files, which are not supported
+	ErrEncrypted = errors.New("Encrypted PDFs are not supported")
+	// ErrUnsupportedFilter is returned for streams encoded with a filter that cannot be decoded
+	ErrUnsupportedFilter = errors.New("Stream filter is not supported")
+	// ErrStreamTooLarge is returned for streams that decode to more than maxStreamSize bytes
+	ErrStreamTooLarge = errors.New("Decoded stream is too large")
+	// ErrTooManyPages is returned when a document has more pages than allowed
+	ErrTooManyPages = errors.New("Document has more pages than allowed")
+	// ErrPageNotFound is returned for a page number outside of the document
+	ErrPageNotFound = errors.New("Page does not exist")
+	// ErrInternal is returned when the parser fails on a document because of a bug of its own
+	ErrInternal = errors.New("Parser failed on the document")
+)
@@ -0,0 +1,297 @@
+package parser
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"encoding/ascii85"
+	"io"
+)
+
+// maxStreamSize bounds how large a decoded stream can be, so a small file cannot expand into gigabytes
+const maxStreamSize = 256 << 20
+
+// decodeStream applies the filters of a stream to its data, in the order they are listed
+func (d *Document) decodeStream(stream *Stream) ([]byte, error) {
+	var filters []Object
+	var params []Object
+	switch filter := d.resolve(stream.Dict["Filter"]).(type) {
+	case Name:
+		filters = Array{filter}
+		params = Array{d.resolve(stream.Dict["DecodeParms"])}
+	case Array:
+		filters = filter
+		params = d.array(stream.Dict["DecodeParms"])
+	}
+
+	data := stream.raw
+	for i, filter := range filters {
+		name, _ := d.resolve(filter).(Name)
+		var param Dict
+		if i < len(params) {
+			param = d.dict(params[i])
+		}
+
+		var err error
+		data, err = d.applyFilter(name, param, data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+func (d *Document) applyFilter(name Name, param Dict, data []byte) ([]byte, error) {
+	switch name {
+	case "FlateDecode", "Fl":
+		decoded, err := inflate(data)
+		if err != nil {
+			return nil, err
+		}
+		return d.unpredict(param, decoded)
+	case "LZWDecode", "LZW":
+		earlyChange := 1
+		if v, ok := d.resolve(param["EarlyChange"]).(int); ok {
+			earlyChange = v
+		}
+		decoded, err := decodeLZW(data, earlyChange == 1)
+		if err != nil {
+			return nil, err
+		}
+		return d.unpredict(param, decoded)
+	case "ASCII85Decode", "A85":
+		return decodeASCII85(data)
+	case "ASCIIHexDecode", "AHx":
+		return (&lexer{data: append([]byte{'<'}, data...)}).readHexString(), nil
+	case "RunLengthDecode", "RL":
+		return decodeRunLength(data)
+	case "Crypt":
+		// Only the identity crypt filter can appear in an unencrypted file
+		return data, nil
+	}
+	return nil, ErrUnsupportedFilter
+}
+
+// inflate decompresses zlib data. Damaged streams are common, so whatever could be decompressed before an
+// error is kept, and data without a zlib header is read as a raw deflate stream.
+func inflate(data []byte) ([]byte, error) {
+	var r io.Reader
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		r = flate.NewReader(bytes.NewReader(data))
+	} else {
+		r = zr
+	}
+
+	decoded, err := io.ReadAll(io.LimitReader(r, maxStreamSize+1))
+	if len(decoded) > maxStreamSize {
+		return nil, ErrStreamTooLarge
+	}
+	if err != nil && len(decoded) == 0 {
+		return nil, ErrMalformed
+	}
+	return decoded, nil
+}
+
+// unpredict reverses the PNG or TIFF predictor named in the parameters of a Flate or LZW stream
+func (d *Document) unpredict(param Dict, data []byte) ([]byte, error) {
+	predictor, _ := d.resolve(param["Predictor"]).(int)
+	if predictor <= 1 {
+		return data, nil
+	}
+
+	colors, bitsPerComponent, columns := 1, 8, 1
+	if v, ok := d.resolve(param["Colors"]).(int); ok && v > 0 {
+		colors = v
+	}
+	if v, ok := d.resolve(param["BitsPerComponent"]).(int); ok && v > 0 {
+		bitsPerComponent = v
+	}
+	if v, ok := d.resolve(param["Columns"]).(int); ok && v > 0 {
+		columns = v
+	}
+	bytesPerPixel := max(1, colors*bitsPerComponent/8)
+	rowSize := (colors*bitsPerComponent*columns + 7) / 8
+	if rowSize > maxStreamSize {
+		return nil, ErrMalformed
+	}
+
+	if predictor == 2 {
+		// TIFF predictor, only supported for whole bytes per component
+		if bitsPerComponent != 8 {
+			return data, nil
+		}
+		out := append([]byte(nil), data...)
+		for row := 0; row+rowSize <= len(out); row += rowSize {
+			for i := bytesPerPixel; i < rowSize; i++ {
+				out[row+i] += out[row+i-bytesPerPixel]
+			}
+		}
+		return out, nil
+	}
+
+	// PNG predictors prefix every row with the filter it was encoded with
+	out := make([]byte, 0, len(data))
+	prev := make([]byte, rowSize)
+	for pos := 0; pos < len(data); pos += rowSize + 1 {
+		filter := data[pos]
+		row := make([]byte, rowSize)
+		copy(row, data[pos+1:min(len(data), pos+1+rowSize)])
+
+		for i := range row {
+			var left, upLeft byte
+			if i >= bytesPerPixel {
+				left = row[i-bytesPerPixel]
+				upLeft = prev[i-bytesPerPixel]
+			}
+			up := prev[i]
+			switch filter {
+			case 1:
+				row[i] += left
+			case 2:
+				row[i] += up
+			case 3:
+				row[i] += byte((int(left) + int(up)) / 2)
+			case 4:
+				row[i] += paeth(left, up, upLeft)
+			}
+		}
+
+		out = append(out, row...)
+		prev = row
+	}
+	return out, nil
+}
+
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	if pa <= pb && pa <= pc {
+		return a
+	}
+	if pb <= pc {
+		return b
+	}
+	return c
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// decodeLZW decompresses LZW data with codes of 9 to 12 bits. With earlyChange the code size grows one
+// code early, as most writers do.
+func decodeLZW(data []byte, earlyChange bool) ([]byte, error) {
+	const clear, eod = 256, 257
+
+	var out []byte
+	var table [][]byte
+	reset := func() {
+		table = table[:0]
+		for i := 0; i < 256; i++ {
+			table = append(table, []byte{byte(i)})
+		}
+		table = append(table, nil, nil)
+	}
+	table = make([][]byte, 0, 4096)
+	reset()
+
+	var bits, bitCount uint32
+	codeSize := uint32(9)
+	var prev []byte
+	for _, c := range data {
+		bits = bits<<8 | uint32(c)
+		bitCount += 8
+		for bitCount >= codeSize {
+			code := int(bits >> (bitCount - codeSize) & (1<<codeSize - 1))
+			bitCount -= codeSize
+
+			switch {
+			case code == clear:
+				reset()
+				codeSize = 9
+				prev = nil
+				continue
+			case code == eod:
+				return out, nil
+			}
+
+			var entry []byte
+			switch {
+			case code < len(table) && table[code] != nil:
+				entry = table[code]
+			case code == len(table) && prev != nil:
+				entry = append(append([]byte(nil), prev...), prev[0])
+			default:
+				return out, ErrMalformed
+			}
+
+			out = append(out, entry...)
+			if len(out) > maxStreamSize {
+				return nil, ErrStreamTooLarge
+			}
+			if prev != nil && len(table) < 4096 {
+				table = append(table, append(append([]byte(nil), prev...), entry[0]))
+			}
+			prev = entry
+
+			next := len(table)
+			if earlyChange {
+				next++
+			}
+			switch {
+			case next >= 2048:
+				codeSize = 12
+			case next >= 1024:
+				codeSize = 11
+			case next >= 512:
+				codeSize = 10
+			}
+		}
+	}
+	return out, nil
+}
+
+// decodeASCII85 decodes base-85 data up to its ~> end marker
+func decodeASCII85(data []byte) ([]byte, error) {
+	data = bytes.TrimSpace(data)
+	data = bytes.TrimPrefix(data, []byte("<~"))
+	if i := bytes.Index(data, []byte("~>")); i >= 0 {
+		data = data[:i]
+	}
+
+	// Every z stands for four zero bytes
+	out := make([]byte, 4*len(data)+4)
+	n, _, err := ascii85.Decode(out, data, true)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	return out[:n], nil
+}
+
+// decodeRunLength decodes data where runs of a byte are stored once with their length
+func decodeRunLength(data []byte) ([]byte, error) {
+	var out []byte
+	for i := 0; i < len(data); {
+		n := int(data[i])
+		i++
+		switch {
+		case n == 128:
+			return out, nil
+		case n < 128:
+			end := min(len(data), i+n+1)
+			out = append(out, data[i:end]...)
+			i = end
+		case i < len(data):
+			out = append(out, bytes.Repeat(data[i:i+1], 257-n)...)
+			i++
+		}
+		if len(out) > maxStreamSize {
+			return nil, ErrStreamTooLarge
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,267 @@
+package parser
+
+import (
+	"strings"
+)
+
+// font decodes the strings shown with a font into text and glyph widths
+type font struct {
+	// composite fonts (Type0) have multi-byte codes mapped to CIDs by their encoding CMap
+	composite bool
+	encoding  *cmap
+	// identity is set for composite fonts using Identity-H or Identity-V, where codes are two-byte CIDs
+	identity bool
+
+	toUnicode *cmap
+	// simple maps the codes of a simple font to their text, when it has no ToUnicode map for them
+	simple *encoding
+
+	// widths of simple fonts are indexed by code from firstChar, those of composite fonts by CID
+	firstChar    int
+	widths       []float64
+	cidWidths    map[int]float64
+	defaultWidth float64
+	// scale converts widths from glyph space to text space, 1/1000 except for Type3 fonts
+	scale float64
+}
+
+// glyph is a code shown with a font
+type glyph struct {
+	text  string
+	width float64
+	// space is set for the single-byte code 32, which word spacing applies to
+	space bool
+}
+
+// loadFont reads a font dictionary, fonts are cached by the page loader as they are shared between pages
+func (d *Document) loadFont(dict Dict) *font {
+	f := &font{scale: 0.001, defaultWidth: 0}
+
+	subtype, _ := d.resolve(dict["Subtype"]).(Name)
+	if stream := d.stream(dict["ToUnicode"]); stream != nil {
+		if data, err := d.decodeStream(stream); err == nil {
+			f.toUnicode = parseCMap(data)
+		}
+	}
+
+	if subtype == "Type0" {
+		d.loadCompositeFont(f, dict)
+		return f
+	}
+
+	if subtype == "Type3" {
+		if m := d.array(dict["FontMatrix"]); len(m) == 6 {
+			f.scale, _ = d.number(m[0])
+		}
+	}
+
+	f.firstChar, _ = d.resolve(dict["FirstChar"]).(int)
+	for _, w := range d.array(dict["Widths"]) {
+		width, _ := d.number(w)
+		f.widths = append(f.widths, width)
+	}
+	descriptor := d.dict(dict["FontDescriptor"])
+	if w, ok := d.number(descriptor["MissingWidth"]); ok {
+		f.defaultWidth = w
+	}
+
+	baseFont, _ := d.resolve(dict["BaseFont"]).(Name)
+	if f.widths == nil {
+		// The standard 14 fonts can be used without widths, their metrics are known to every reader
+		f.firstChar = 32
+		f.widths = standardWidths(string(baseFont))
+		f.defaultWidth = 500
+	}
+
+	f.simple = d.simpleEncoding(dict, subtype, string(baseFont), descriptor)
+	return f
+}
+
+// simpleEncoding builds the encoding of a simple font from its base encoding and its differences
+func (d *Document) simpleEncoding(dict Dict, subtype Name, baseFont string, descriptor Dict) *encoding {
+	flags, _ := d.resolve(descriptor["Flags"]).(int)
+	symbolic := flags&4 != 0 && flags&32 == 0
+
+	enc := standardEncoding
+	switch {
+	case subtype == "Type3" || strings.Contains(baseFont, "Symbol") || strings.Contains(baseFont, "Dingbats"):
+		// These fonts draw their own glyphs, only their differences give meaning to the codes
+		enc = &encoding{}
+	case subtype == "TrueType" && !symbolic:
+		enc = winAnsiEncoding
+	}
+
+	var differences Array
+	switch v := d.resolve(dict["Encoding"]).(type) {
+	case Name:
+		if named := namedEncoding(v); named != nil {
+			enc = named
+		}
+	case Dict:
+		if base, ok := d.resolve(v["BaseEncoding"]).(Name); ok {
+			if named := namedEncoding(base); named != nil {
+				enc = named
+			}
+		}
+		differences = d.array(v["Differences"])
+	}
+	if differences == nil {
+		return enc
+	}
+
+	changed := *enc
+	code := 0
+	for _, item := range differences {
+		switch v := d.resolve(item).(type) {
+		case int:
+			code = v
+		case Name:
+			if code >= 0 && code < 256 {
+				changed[code], _ = glyphText(string(v))
+			}
+			code++
+		}
+	}
+	return &changed
+}
+
+func (d *Document) loadCompositeFont(f *font, dict Dict) {
+	f.composite = true
+	f.defaultWidth = 1000
+	f.cidWidths = make(map[int]float64)
+
+	switch enc := d.resolve(dict["Encoding"]).(type) {
+	case Name:
+		f.identity = enc == "Identity-H" || enc == "Identity-V"
+	case *Stream:
+		if data, err := d.decodeStream(enc); err == nil {
+			f.encoding = parseCMap(data)
+		}
+	}
+	if f.encoding == nil && !f.identity {
+		// Predefined CMaps other than Identity are not bundled, most of them use two-byte codes
+		f.identity = true
+	}
+
+	descendants := d.array(dict["DescendantFonts"])
+	if len(descendants) == 0 {
+		return
+	}
+	descendant := d.dict(descendants[0])
+	if w, ok := d.number(descendant["DW"]); ok {
+		f.defaultWidth = w
+	}
+
+	// W lists either "first [w1 w2 ...]" or "first last w"
+	w := d.array(descendant["W"])
+	for i := 0; i < len(w); {
+		first, ok := d.resolve(w[i]).(int)
+		if !ok || i+1 >= len(w) {
+			break
+		}
+		if widths, ok := d.resolve(w[i+1]).(Array); ok {
+			for j, width := range widths {
+				f.cidWidths[first+j], _ = d.number(width)
+			}
+			i += 2
+			continue
+		}
+		last, ok := d.resolve(w[i+1]).(int)
+		if !ok || i+2 >= len(w) || last-first > 65535 {
+			break
+		}
+		width, _ := d.number(w[i+2])
+		for cid := first; cid <= last; cid++ {
+			f.cidWidths[cid] = width
+		}
+		i += 3
+	}
+}
+
+// decode splits a shown string into glyphs
+func (f *font) decode(s []byte) []glyph {
+	var glyphs []glyph
+	for len(s) > 0 {
+		size := 1
+		if f.composite {
+			size = 2
+			if f.encoding != nil {
+				size = f.encoding.nextCode(s)
+			} else if f.toUnicode != nil && len(f.toUnicode.codespaces) > 0 {
+				size = f.toUnicode.nextCode(s)
+			}
+			size = max(1, min(size, len(s)))
+		}
+		code := s[:size]
+		s = s[size:]
+
+		g := glyph{space: size == 1 && code[0] == ' '}
+		text, ok := f.toUnicode.text(code)
+		if !ok && f.simple != nil {
+			text = f.simple[code[0]]
+		}
+		g.text = text
+		g.width = f.width(code) * f.scale
+		glyphs = append(glyphs, g)
+	}
+	return glyphs
+}
+
+// width returns the width of a code in glyph space
+func (f *font) width(code []byte) float64 {
+	if !f.composite {
+		i := int(code[0]) - f.firstChar
+		if i >= 0 && i < len(f.widths) && f.widths[i] > 0 {
+			return f.widths[i]
+		}
+		return f.defaultWidth
+	}
+
+	cid := int(codeValue(code))
+	if !f.identity {
+		cid, _ = f.encoding.cid(code)
+	}
+	if w, ok := f.cidWidths[cid]; ok {
+		return w
+	}
+	return f.defaultWidth
+}
+
+// helveticaWidths and timesWidths are the widths of the printable ASCII characters of the standard fonts
+var (
+	helveticaWidths = []float64{
+		278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+		556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+		1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+		667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+		333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+		556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+	}
+	timesWidths = []float64{
+		250, 333, 408, 500, 500, 833, 778, 180, 333, 333, 500, 564, 250, 333, 250, 278,
+		500, 500, 500, 500, 500, 500, 500, 500, 500, 500, 278, 278, 564, 564, 564, 444,
+		921, 722, 667, 667, 722, 611, 556, 722, 722, 333, 389, 722, 611, 889, 722, 722,
+		556, 722, 667, 556, 611, 722, 722, 944, 722, 722, 611, 333, 278, 333, 469, 500,
+		333, 444, 500, 444, 500, 444, 333, 500, 500, 278, 278, 500, 278, 778, 500, 500,
+		500, 500, 333, 389, 278, 500, 500, 722, 500, 500, 444, 480, 200, 480, 541,
+	}
+	courierWidths = func() []float64 {
+		widths := make([]float64, 95)
+		for i := range widths {
+			widths[i] = 600
+		}
+		return widths
+	}()
+)
+
+// standardWidths returns the widths of a standard font from 32 up, the bold and italic styles are taken to
+// be as wide as the regular one
+func standardWidths(baseFont string) []float64 {
+	switch {
+	case strings.Contains(baseFont, "Courier"):
+		return courierWidths
+	case strings.Contains(baseFont, "Times"):
+		return timesWidths
+	}
+	return helveticaWidths
+}
@@ -0,0 +1,285 @@
+package parser
+
+import (
+	"sort"
+	"strconv"
+)
+
+const (
+	// maxFormFields bounds how many form fields are read, so a malformed form cannot exhaust memory
+	maxFormFields = 10000
+	// maxFieldDepth bounds how deep form fields can be nested in each other
+	maxFieldDepth = 32
+)
+
+// Types of the form fields
+const (
+	FieldText      = "text"
+	FieldCheckbox  = "checkbox"
+	FieldRadio     = "radio"
+	FieldButton    = "button"
+	FieldChoice    = "choice"
+	FieldSignature = "signature"
+)
+
+// Field flags deciding the kind of button and choice fields
+const (
+	flagRadio       = 1 << 15
+	flagPushButton  = 1 << 16
+	flagMultiSelect = 1 << 21
+)
+
+// FormField is a field of the interactive form of the document, with the value it was filled in with
+type FormField struct {
+	// Name is the fully qualified name of the field, the names of its ancestors and its own joined by periods
+	Name string
+	Type string
+	// Value is the text of a text field, the export value of the checked box or radio button, the selected
+	// option of a choice field or the name of the signer of a signature, empty when the field is not filled in
+	Value string
+	// Selected lists every option selected in a choice field that allows several
+	Selected []string
+	// Options are the export values a choice field, checkbox or radio button can take
+	Options []string
+	// Page is the number of the page the field is shown on, or 0 when it is not shown
+	Page int
+}
+
+// formReader walks the field tree of an interactive form
+type formReader struct {
+	d       *Document
+	visited map[int]bool
+	fields  []FormField
+	// annotations maps the object numbers of the annotations to the number of the page showing them, loaded when
+	// a widget does not give its page
+	annotations map[int]int
+	pages       map[int]int
+}
+
+// inheritedField holds the attributes a field inherits from its ancestors
+type inheritedField struct {
+	name      string
+	fieldType Name
+	flags     int
+	value     Object
+	options   Array
+}
+
+// FormFields reads the fields of the interactive form of the document in the order of its field tree, or nil
+// when it has no form
+func (d *Document) FormFields() []FormField {
+	form := d.dict(d.catalog()["AcroForm"])
+	if form == nil {
+		return nil
+	}
+
+	r := &formReader{d: d, visited: make(map[int]bool), pages: make(map[int]int)}
+	for i, p := range d.pages {
+		if p.num != 0 {
+			r.pages[p.num] = i + 1
+		}
+	}
+	for _, field := range d.array(form["Fields"]) {
+		r.field(field, inheritedField{}, 0)
+	}
+	return r.fields
+}
+
+// field reads a field and its descendants
+func (r *formReader) field(obj Object, inherited inheritedField, depth int) {
+	if depth >= maxFieldDepth || len(r.fields) >= maxFormFields {
+		return
+	}
+	if ref, ok := obj.(Ref); ok {
+		if r.visited[ref.Num] {
+			return
+		}
+		r.visited[ref.Num] = true
+	}
+	node := r.d.dict(obj)
+	if node == nil {
+		return
+	}
+
+	if name := r.d.textString(node["T"]); name != "" {
+		if inherited.name != "" {
+			name = inherited.name + "." + name
+		}
+		inherited.name = name
+	}
+	if fieldType, ok := r.d.resolve(node["FT"]).(Name); ok {
+		inherited.fieldType = fieldType
+	}
+	if flags, ok := r.d.resolve(node["Ff"]).(int); ok {
+		inherited.flags = flags
+	}
+	if value, ok := node["V"]; ok {
+		inherited.value = value
+	}
+	if options := r.d.array(node["Opt"]); options != nil {
+		inherited.options = options
+	}
+
+	// Kids with a name are fields of their own, kids without one are the widgets showing this field
+	var widgets []Object
+	var children []Object
+	for _, kid := range r.d.array(node["Kids"]) {
+		if _, named := r.d.dict(kid)["T"]; named {
+			children = append(children, kid)
+		} else {
+			widgets = append(widgets, kid)
+		}
+	}
+	if children != nil {
+		for _, child := range children {
+			r.field(child, inherited, depth+1)
+		}
+		return
+	}
+	if widgets == nil {
+		// A field without kids is its own widget
+		widgets = []Object{obj}
+	}
+
+	r.fields = append(r.fields, r.newField(inherited, widgets))
+}
+
+// newField describes a terminal field shown by the given widgets
+func (r *formReader) newField(inherited inheritedField, widgets []Object) FormField {
+	field := FormField{Name: inherited.name}
+
+	for _, widget := range widgets {
+		if field.Page = r.widgetPage(widget); field.Page != 0 {
+			break
+		}
+	}
+
+	switch inherited.fieldType {
+	case "Tx":
+		field.Type = FieldText
+		field.Value = r.text(inherited.value)
+	case "Btn":
+		switch {
+		case inherited.flags&flagPushButton != 0:
+			field.Type = FieldButton
+		case inherited.flags&flagRadio != 0:
+			field.Type = FieldRadio
+		default:
+			field.Type = FieldCheckbox
+		}
+		if field.Type != FieldButton {
+			field.Options, field.Value = r.buttonStates(inherited, widgets)
+		}
+	case "Ch":
+		field.Type = FieldChoice
+		for _, option := range inherited.options {
+			// An option is either its text or a pair of its export value and its text
+			if pair := r.d.array(option); len(pair) > 0 {
+				option = pair[0]
+			}
+			field.Options = append(field.Options, r.d.textString(option))
+		}
+		switch v := r.d.resolve(inherited.value).(type) {
+		case String:
+			field.Value = r.d.textString(v)
+		case Array:
+			for _, item := range v {
+				if text := r.d.textString(item); text != "" {
+					field.Selected = append(field.Selected, text)
+				}
+			}
+			if len(field.Selected) > 0 {
+				field.Value = field.Selected[0]
+			}
+		}
+		if inherited.flags&flagMultiSelect == 0 {
+			field.Selected = nil
+		}
+	case "Sig":
+		field.Type = FieldSignature
+		field.Value = r.d.textString(r.d.dict(inherited.value)["Name"])
+	default:
+		field.Type = FieldText
+		field.Value = r.text(inherited.value)
+	}
+
+	return field
+}
+
+// text returns the value of a text field, which long texts give as a stream
+func (r *formReader) text(value Object) string {
+	stream := r.d.stream(value)
+	if stream == nil {
+		return r.d.textString(value)
+	}
+	data, err := r.d.decodeStream(stream)
+	if err != nil {
+		return ""
+	}
+	return r.d.textString(String(data))
+}
+
+// buttonStates returns the export values a checkbox or radio button can take and the one it is set to. The
+// values are given by the options of the field when it has some, and named by the appearances of its widgets
+// otherwise.
+func (r *formReader) buttonStates(inherited inheritedField, widgets []Object) ([]string, string) {
+	var options []string
+	for _, option := range inherited.options {
+		options = append(options, r.d.textString(option))
+	}
+
+	if options == nil {
+		seen := make(map[string]bool)
+		for _, widget := range widgets {
+			appearances := r.d.dict(r.d.dict(r.d.dict(widget)["AP"])["N"])
+			var states []string
+			for state := range appearances {
+				if state != "Off" && !seen[string(state)] {
+					seen[string(state)] = true
+					states = append(states, string(state))
+				}
+			}
+			sort.Strings(states)
+			options = append(options, states...)
+		}
+	}
+
+	state, _ := r.d.resolve(inherited.value).(Name)
+	value := string(state)
+	if value == "Off" {
+		value = ""
+	}
+	// With options, the states are the indexes of the options
+	if index, err := strconv.Atoi(value); err == nil && inherited.options != nil && index >= 0 && index < len(options) {
+		value = options[index]
+	}
+	return options, value
+}
+
+// widgetPage returns the number of the page showing a widget, from the page it names or else from the
+// annotations of the pages
+func (r *formReader) widgetPage(widget Object) int {
+	if ref, ok := r.d.dict(widget)["P"].(Ref); ok {
+		if number, ok := r.pages[ref.Num]; ok {
+			return number
+		}
+	}
+
+	ref, ok := widget.(Ref)
+	if !ok {
+		return 0
+	}
+	if r.annotations == nil {
+		r.annotations = make(map[int]int)
+		for i, p := range r.d.pages {
+			for _, annotation := range r.d.array(p.dict["Annots"]) {
+				if annotationRef, ok := annotation.(Ref); ok {
+					if _, exists := r.annotations[annotationRef.Num]; !exists {
+						r.annotations[annotationRef.Num] = i + 1
+					}
+				}
+			}
+		}
+	}
+	return r.annotations[ref.Num]
+}
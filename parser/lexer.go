@@ -0,0 +1,373 @@
+package parser
+
+import (
+	"bytes"
+	"strconv"
+)
+
+// maxNesting bounds how deep arrays and dictionaries can be nested, so a malformed file cannot exhaust the stack
+const maxNesting = 64
+
+// lexer reads objects from PDF syntax, either a file or a content stream
+type lexer struct {
+	data []byte
+	pos  int
+}
+
+func isSpace(c byte) bool {
+	switch c {
+	case 0, '\t', '\n', '\f', '\r', ' ':
+		return true
+	}
+	return false
+}
+
+func isDelimiter(c byte) bool {
+	switch c {
+	case '(', ')', '<', '>', '[', ']', '{', '}', '/', '%':
+		return true
+	}
+	return false
+}
+
+func isRegular(c byte) bool {
+	return !isSpace(c) && !isDelimiter(c)
+}
+
+// skipSpace moves past white space and comments
+func (l *lexer) skipSpace() {
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		if c == '%' {
+			for l.pos < len(l.data) && l.data[l.pos] != '\n' && l.data[l.pos] != '\r' {
+				l.pos++
+			}
+			continue
+		}
+		if !isSpace(c) {
+			return
+		}
+		l.pos++
+	}
+}
+
+// eof reports whether only white space and comments are left
+func (l *lexer) eof() bool {
+	l.skipSpace()
+	return l.pos >= len(l.data)
+}
+
+// readObject reads the next object. Keywords, including stray closing delimiters, are returned as keyword.
+func (l *lexer) readObject() (Object, error) {
+	return l.readNested(0)
+}
+
+func (l *lexer) readNested(depth int) (Object, error) {
+	if depth > maxNesting {
+		return nil, ErrMalformed
+	}
+
+	l.skipSpace()
+	if l.pos >= len(l.data) {
+		return nil, ErrMalformed
+	}
+
+	c := l.data[l.pos]
+	switch {
+	case c == '/':
+		return l.readName(), nil
+	case c == '(':
+		return l.readLiteralString(), nil
+	case c == '<' && l.pos+1 < len(l.data) && l.data[l.pos+1] == '<':
+		l.pos += 2
+		return l.readDict(depth)
+	case c == '<':
+		return l.readHexString(), nil
+	case c == '[':
+		l.pos++
+		return l.readArray(depth)
+	case c == '>' && l.pos+1 < len(l.data) && l.data[l.pos+1] == '>':
+		l.pos += 2
+		return keyword(">>"), nil
+	case isDelimiter(c):
+		l.pos++
+		return keyword(c), nil
+	case c == '+' || c == '-' || c == '.' || (c >= '0' && c <= '9'):
+		return l.readNumber(), nil
+	}
+
+	start := l.pos
+	for l.pos < len(l.data) && isRegular(l.data[l.pos]) {
+		l.pos++
+	}
+	switch word := string(l.data[start:l.pos]); word {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	default:
+		return keyword(word), nil
+	}
+}
+
+// readName reads a name, decoding its #xx escapes
+func (l *lexer) readName() Name {
+	l.pos++
+	var name []byte
+	for l.pos < len(l.data) && isRegular(l.data[l.pos]) {
+		c := l.data[l.pos]
+		if c == '#' && l.pos+2 < len(l.data) {
+			if v, err := strconv.ParseUint(string(l.data[l.pos+1:l.pos+3]), 16, 8); err == nil {
+				name = append(name, byte(v))
+				l.pos += 3
+				continue
+			}
+		}
+		name = append(name, c)
+		l.pos++
+	}
+	return Name(name)
+}
+
+// readNumber reads an integer or a real, followed by "gen R" when it starts a reference
+func (l *lexer) readNumber() Object {
+	value := l.readPlainNumber()
+	num, ok := value.(int)
+	if !ok || num < 0 {
+		return value
+	}
+
+	// Look ahead for the generation number and R of a reference
+	save := l.pos
+	l.skipSpace()
+	if l.pos < len(l.data) && l.data[l.pos] >= '0' && l.data[l.pos] <= '9' {
+		if gen, ok := l.readPlainNumber().(int); ok {
+			l.skipSpace()
+			if l.pos < len(l.data) && l.data[l.pos] == 'R' && (l.pos+1 == len(l.data) || !isRegular(l.data[l.pos+1])) {
+				l.pos++
+				return Ref{Num: num, Gen: gen}
+			}
+		}
+	}
+	l.pos = save
+	return value
+}
+
+func (l *lexer) readPlainNumber() Object {
+	start := l.pos
+	real := false
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		if c == '.' {
+			real = true
+		} else if !(c >= '0' && c <= '9') && !((c == '+' || c == '-') && l.pos == start) {
+			break
+		}
+		l.pos++
+	}
+
+	text := string(l.data[start:l.pos])
+	// Some writers produce signs such as "--5", which readers take as a plain negative number
+	for len(text) > 1 && (text[0] == '-' || text[0] == '+') && (text[1] == '-' || text[1] == '+') {
+		text = text[1:]
+	}
+	if !real {
+		if v, err := strconv.Atoi(text); err == nil {
+			return v
+		}
+	}
+	v, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return 0
+	}
+	return v
+}
+
+// readLiteralString reads a string in parentheses, decoding its escapes
+func (l *lexer) readLiteralString() String {
+	l.pos++
+	var s []byte
+	depth := 1
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		l.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return String(s)
+			}
+		case '\r':
+			// An end of line in a string is read as a single line feed
+			if l.pos < len(l.data) && l.data[l.pos] == '\n' {
+				l.pos++
+			}
+			c = '\n'
+		case '\\':
+			if l.pos >= len(l.data) {
+				return String(s)
+			}
+			c = l.data[l.pos]
+			l.pos++
+			switch c {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r':
+				if l.pos < len(l.data) && l.data[l.pos] == '\n' {
+					l.pos++
+				}
+				continue
+			case '\n':
+				continue
+			default:
+				if c >= '0' && c <= '7' {
+					v := int(c - '0')
+					for i := 0; i < 2 && l.pos < len(l.data) && l.data[l.pos] >= '0' && l.data[l.pos] <= '7'; i++ {
+						v = v*8 + int(l.data[l.pos]-'0')
+						l.pos++
+					}
+					c = byte(v)
+				}
+			}
+		}
+		s = append(s, c)
+	}
+	return String(s)
+}
+
+// readHexString reads a string in angle brackets, a missing last digit is taken as 0
+func (l *lexer) readHexString() String {
+	l.pos++
+	var s []byte
+	var digit byte
+	half := false
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		l.pos++
+		if c == '>' {
+			break
+		}
+		v, ok := hexValue(c)
+		if !ok {
+			continue
+		}
+		if half {
+			s = append(s, digit<<4|v)
+		} else {
+			digit = v
+		}
+		half = !half
+	}
+	if half {
+		s = append(s, digit<<4)
+	}
+	return String(s)
+}
+
+func hexValue(c byte) (byte, bool) {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0', true
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10, true
+	case c >= 'A' && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
+
+func (l *lexer) readArray(depth int) (Array, error) {
+	arr := Array{}
+	for {
+		l.skipSpace()
+		if l.pos >= len(l.data) {
+			return arr, ErrMalformed
+		}
+		if l.data[l.pos] == ']' {
+			l.pos++
+			return arr, nil
+		}
+
+		obj, err := l.readNested(depth + 1)
+		if err != nil {
+			return arr, err
+		}
+		if kw, ok := obj.(keyword); ok && (kw == ">>" || kw == "endobj" || kw == "stream") {
+			// The array was never closed, stop at the end of the enclosing object
+			return arr, ErrMalformed
+		}
+		arr = append(arr, obj)
+	}
+}
+
+func (l *lexer) readDict(depth int) (Dict, error) {
+	dict := Dict{}
+	for {
+		l.skipSpace()
+		if l.pos >= len(l.data) {
+			return dict, ErrMalformed
+		}
+		if bytes.HasPrefix(l.data[l.pos:], []byte(">>")) {
+			l.pos += 2
+			return dict, nil
+		}
+
+		key, err := l.readNested(depth + 1)
+		if err != nil {
+			return dict, err
+		}
+		name, ok := key.(Name)
+		if !ok {
+			if kw, ok := key.(keyword); ok && (kw == "endobj" || kw == "stream") {
+				return dict, ErrMalformed
+			}
+			// Skip whatever is not a key, such as a stray value
+			continue
+		}
+
+		l.skipSpace()
+		if bytes.HasPrefix(l.data[l.pos:], []byte(">>")) {
+			// A key without a value is read as null
+			continue
+		}
+		value, err := l.readNested(depth + 1)
+		if err != nil {
+			return dict, err
+		}
+		if value != nil {
+			dict[name] = value
+		}
+	}
+}
+
+// skipInlineImage moves past the data of an inline image, which follows the ID operator up to EI
+func (l *lexer) skipInlineImage() {
+	if l.pos < len(l.data) && isSpace(l.data[l.pos]) {
+		l.pos++
+	}
+	for l.pos < len(l.data) {
+		i := bytes.Index(l.data[l.pos:], []byte("EI"))
+		if i < 0 {
+			l.pos = len(l.data)
+			return
+		}
+		at := l.pos + i
+		l.pos = at + 2
+		// EI only ends the image when it stands on its own, the binary data can contain the same bytes
+		if (at == 0 || isSpace(l.data[at-1])) && (l.pos == len(l.data) || isSpace(l.data[l.pos])) {
+			return
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Metadata describes a document as given by its Info dictionary and its XMP packet
+type Metadata struct {
+	Title    string
+	Author   string
+	Producer string
+	// Created and Modified are nil when the document does not give them
+	Created  *time.Time
+	Modified *time.Time
+}
+
+// XMP namespaces of the properties read from the metadata packet
+const (
+	dublinCoreNS = "http://purl.org/dc/elements/1.1/"
+	xmpBasicNS   = "http://ns.adobe.com/xap/1.0/"
+	adobePDFNS   = "http://ns.adobe.com/pdf/1.3/"
+	rdfNS        = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+)
+
+var (
+	// pdfDatePattern matches dates such as D:20230102150405+01'00', where everything after the year is optional
+	pdfDatePattern = regexp.MustCompile(`^(?:D:)?(\d{4})(\d{2})?(\d{2})?(\d{2})?(\d{2})?(\d{2})?(?:([Zz+\-])(\d{2})?'?(\d{2})?'?)?`)
+	// xmpDatePattern matches the ISO 8601 dates of XMP, such as 2023-01-02T15:04:05.123+01:00
+	xmpDatePattern = regexp.MustCompile(`^(\d{4})(?:-(\d{2}))?(?:-(\d{2}))?(?:T(\d{2}):(\d{2})(?::(\d{2})(?:\.\d+)?)?)?(?:([Zz+\-])(\d{2})?:?(\d{2})?)?$`)
+)
+
+// Metadata reads the metadata of the document. The Info dictionary is preferred, and the XMP packet of the
+// catalog fills in what it leaves out.
+func (d *Document) Metadata() Metadata {
+	info := d.dict(d.trailer["Info"])
+	m := Metadata{
+		Title:    d.textString(info["Title"]),
+		Author:   d.textString(info["Author"]),
+		Producer: d.textString(info["Producer"]),
+		Created:  parsePDFDate(d.textString(info["CreationDate"])),
+		Modified: parsePDFDate(d.textString(info["ModDate"])),
+	}
+
+	stream := d.stream(d.catalog()["Metadata"])
+	if stream == nil {
+		return m
+	}
+	data, err := d.decodeStream(stream)
+	if err != nil {
+		return m
+	}
+
+	x := parseXMP(data)
+	if m.Title == "" {
+		m.Title = x.Title
+	}
+	if m.Author == "" {
+		m.Author = x.Author
+	}
+	if m.Producer == "" {
+		m.Producer = x.Producer
+	}
+	if m.Created == nil {
+		m.Created = x.Created
+	}
+	if m.Modified == nil {
+		m.Modified = x.Modified
+	}
+	return m
+}
+
+// textString decodes a text string, which is UTF-16BE or UTF-8 when it starts with a byte order mark and
+// PDFDocEncoding otherwise
+func (d *Document) textString(obj Object) string {
+	s, ok := d.resolve(obj).(String)
+	if !ok {
+		return ""
+	}
+
+	var text string
+	switch {
+	case bytes.HasPrefix(s, []byte{0xFE, 0xFF}):
+		text = utf16Text(s[2:])
+	case bytes.HasPrefix(s, []byte{0xEF, 0xBB, 0xBF}):
+		text = strings.ToValidUTF8(string(s[3:]), "�")
+	default:
+		var b strings.Builder
+		for _, c := range s {
+			b.WriteString(pdfDocEncoding[c])
+		}
+		text = b.String()
+	}
+
+	// Language tags are escaped in UTF-16 text strings between two U+001B
+	for {
+		start := strings.IndexRune(text, 0x1B)
+		if start < 0 {
+			break
+		}
+		end := strings.IndexRune(text[start+1:], 0x1B)
+		if end < 0 {
+			text = text[:start]
+			break
+		}
+		text = text[:start] + text[start+1+end+1:]
+	}
+	return strings.TrimSpace(strings.ReplaceAll(text, "\x00", ""))
+}
+
+// parsePDFDate reads a date in the format of the Info dictionary, returning nil when it cannot be read
+func parsePDFDate(s string) *time.Time {
+	match := pdfDatePattern.FindStringSubmatch(strings.TrimSpace(s))
+	if match == nil {
+		return nil
+	}
+	return dateFromParts(match[1:])
+}
+
+// parseXMPDate reads a date of an XMP property, returning nil when it cannot be read
+func parseXMPDate(s string) *time.Time {
+	match := xmpDatePattern.FindStringSubmatch(strings.TrimSpace(s))
+	if match == nil {
+		return nil
+	}
+	return dateFromParts(match[1:])
+}
+
+// dateFromParts builds a date from its year, month, day, hour, minute, second, offset sign, offset hours and
+// offset minutes, with the missing parts taking their lowest value. Dates without an offset are taken as UTC.
+func dateFromParts(parts []string) *time.Time {
+	values := make([]int, 6)
+	for i, part := range parts[:6] {
+		values[i], _ = strconv.Atoi(part)
+	}
+	values[1], values[2] = max(values[1], 1), max(values[2], 1)
+	if values[1] > 12 || values[2] > 31 || values[3] > 23 || values[4] > 59 || values[5] > 60 {
+		return nil
+	}
+
+	location := time.UTC
+	if sign := parts[6]; sign == "+" || sign == "-" {
+		hours, _ := strconv.Atoi(parts[7])
+		minutes, _ := strconv.Atoi(parts[8])
+		offset := hours*3600 + minutes*60
+		if sign == "-" {
+			offset = -offset
+		}
+		location = time.FixedZone("", offset)
+	}
+
+	date := time.Date(values[0], time.Month(values[1]), values[2], values[3], values[4], values[5], 0, location).UTC()
+	return &date
+}
+
+// parseXMP reads the metadata properties of an XMP packet. A packet that is not well-formed XML yields the
+// properties read before the error.
+func parseXMP(data []byte) Metadata {
+	var m Metadata
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.Strict = false
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+
+	// property is the XMP property being read, and text the text read so far for it
+	var property xml.Name
+	var text []string
+	var value strings.Builder
+	// Properties are the elements directly inside a description
+	depth, descriptionDepth, propertyDepth := 0, 0, 0
+	// defaultItem is set while reading the item of a language alternative marked as the default language
+	defaultItem := false
+
+	set := func(name xml.Name, values []string) {
+		joined := strings.TrimSpace(strings.Join(values, "; "))
+		if joined == "" || !utf8.ValidString(joined) {
+			return
+		}
+		switch {
+		case name.Space == dublinCoreNS && name.Local == "title" && m.Title == "":
+			// Only the first alternative is kept, the default language one is moved first
+			m.Title = strings.TrimSpace(values[0])
+		case name.Space == dublinCoreNS && name.Local == "creator" && m.Author == "":
+			m.Author = joined
+		case name.Space == adobePDFNS && name.Local == "Producer" && m.Producer == "":
+			m.Producer = joined
+		case name.Space == xmpBasicNS && name.Local == "CreateDate" && m.Created == nil:
+			m.Created = parseXMPDate(joined)
+		case name.Space == xmpBasicNS && name.Local == "ModifyDate" && m.Modified == nil:
+			m.Modified = parseXMPDate(joined)
+		}
+	}
+
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return m
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			depth++
+			if t.Name.Space == rdfNS && t.Name.Local == "Description" {
+				// Simple properties can be given as attributes of the description
+				for _, attr := range t.Attr {
+					set(attr.Name, []string{attr.Value})
+				}
+				descriptionDepth = depth
+				continue
+			}
+			if propertyDepth == 0 && descriptionDepth > 0 && depth == descriptionDepth+1 {
+				property, propertyDepth, text = t.Name, depth, nil
+			}
+			if t.Name.Space == rdfNS && t.Name.Local == "li" {
+				defaultItem = false
+				for _, attr := range t.Attr {
+					if attr.Name.Local == "lang" && attr.Value == "x-default" {
+						defaultItem = true
+					}
+				}
+			}
+			value.Reset()
+		case xml.CharData:
+			if propertyDepth > 0 {
+				value.Write(t)
+			}
+		case xml.EndElement:
+			if propertyDepth > 0 {
+				if t.Name.Space == rdfNS && t.Name.Local == "li" {
+					if defaultItem {
+						text = append([]string{value.String()}, text...)
+					} else {
+						text = append(text, value.String())
+					}
+				}
+				if depth == propertyDepth {
+					if text == nil {
+						text = []string{value.String()}
+					}
+					set(property, text)
+					propertyDepth = 0
+				}
+				value.Reset()
+			}
+			if depth == descriptionDepth {
+				descriptionDepth = 0
+			}
+			depth--
+		}
+	}
+}
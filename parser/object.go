@@ -0,0 +1,80 @@
+package parser
+
+// Object is a PDF object: nil for null, bool, int, float64, Name, String, Array, Dict, Ref or *Stream
+type Object interface{}
+
+// Name is a PDF name such as /Type, without the leading slash
+type Name string
+
+// String holds the raw bytes of a literal or hexadecimal string, the encoding depends on where it is used
+type String []byte
+
+// Array is a PDF array
+type Array []Object
+
+// Dict is a PDF dictionary
+type Dict map[Name]Object
+
+// Ref refers to an indirect object by its object and generation number
+type Ref struct {
+	Num int
+	Gen int
+}
+
+// Stream is a stream object, its data is still encoded by the filters named in its dictionary
+type Stream struct {
+	Dict Dict
+	raw  []byte
+}
+
+// keyword is a bare word such as obj, stream or a content stream operator
+type keyword string
+
+// matrix is an affine transformation [a b c d e f], as used by the cm and Tm operators
+type matrix [6]float64
+
+var identity = matrix{1, 0, 0, 1, 0, 0}
+
+// multiply returns m applied first and then n
+func (m matrix) multiply(n matrix) matrix {
+	return matrix{
+		m[0]*n[0] + m[1]*n[2],
+		m[0]*n[1] + m[1]*n[3],
+		m[2]*n[0] + m[3]*n[2],
+		m[2]*n[1] + m[3]*n[3],
+		m[4]*n[0] + m[5]*n[2] + n[4],
+		m[4]*n[1] + m[5]*n[3] + n[5],
+	}
+}
+
+// apply transforms the point x, y
+func (m matrix) apply(x, y float64) (float64, float64) {
+	return x*m[0] + y*m[2] + m[4], x*m[1] + y*m[3] + m[5]
+}
+
+// number returns the value of an int or float64 object
+func number(obj Object) (float64, bool) {
+	switch v := obj.(type) {
+	case int:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// toMatrix reads a matrix from an array of six numbers, or returns the identity
+func toMatrix(arr Array) matrix {
+	if len(arr) != 6 {
+		return identity
+	}
+	var m matrix
+	for i, obj := range arr {
+		v, ok := number(obj)
+		if !ok {
+			return identity
+		}
+		m[i] = v
+	}
+	return m
+}
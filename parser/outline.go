@@ -0,0 +1,150 @@
+package parser
+
+const (
+	// maxOutlineItems bounds how many outline items are read, so a malformed outline cannot exhaust memory
+	maxOutlineItems = 10000
+	// maxOutlineDepth bounds how deep outline items can be nested in each other
+	maxOutlineDepth = 64
+	// maxNameTreeDepth bounds how deep the name tree of destinations is followed
+	maxNameTreeDepth = 32
+)
+
+// OutlineItem is a bookmark of the document outline
+type OutlineItem struct {
+	Title string
+	// Page is the number of the page the item leads to, starting from 1, or 0 when it leads to no page of the
+	// document
+	Page     int
+	Children []OutlineItem
+}
+
+// outlineReader follows the linked lists of an outline, resolving the destinations of its items
+type outlineReader struct {
+	d       *Document
+	visited map[int]bool
+	count   int
+	// pages maps the object numbers of the pages to their numbers
+	pages map[int]int
+	// names holds the named destinations, loaded when an item first uses one
+	names map[string]Object
+}
+
+// Outline reads the outline of the document, its bookmarks nested as they are shown, or nil when it has none
+func (d *Document) Outline() []OutlineItem {
+	root := d.dict(d.catalog()["Outlines"])
+	if root == nil {
+		return nil
+	}
+
+	r := &outlineReader{d: d, visited: make(map[int]bool), pages: make(map[int]int)}
+	for i, p := range d.pages {
+		if p.num != 0 {
+			r.pages[p.num] = i + 1
+		}
+	}
+	return r.items(root["First"], 0)
+}
+
+// items reads a list of sibling items starting from the given one
+func (r *outlineReader) items(first Object, depth int) []OutlineItem {
+	if depth >= maxOutlineDepth {
+		return nil
+	}
+
+	var items []OutlineItem
+	for obj := first; obj != nil && r.count < maxOutlineItems; {
+		// An item linked twice would make the list loop
+		ref, ok := obj.(Ref)
+		if !ok || r.visited[ref.Num] {
+			break
+		}
+		r.visited[ref.Num] = true
+
+		node := r.d.dict(ref)
+		if node == nil {
+			break
+		}
+		r.count++
+
+		item := OutlineItem{Title: r.d.textString(node["Title"])}
+		if dest, ok := node["Dest"]; ok {
+			item.Page = r.destinationPage(dest, 0)
+		} else if action := r.d.dict(node["A"]); action != nil && action["S"] == Name("GoTo") {
+			item.Page = r.destinationPage(action["D"], 0)
+		}
+		item.Children = r.items(node["First"], depth+1)
+		items = append(items, item)
+
+		obj = node["Next"]
+	}
+	return items
+}
+
+// destinationPage returns the number of the page a destination leads to, or 0 when it leads to no page
+func (r *outlineReader) destinationPage(dest Object, depth int) int {
+	if depth > maxRefDepth {
+		return 0
+	}
+
+	switch v := r.d.resolve(dest).(type) {
+	case Array:
+		if len(v) == 0 {
+			return 0
+		}
+		if ref, ok := v[0].(Ref); ok {
+			return r.pages[ref.Num]
+		}
+		// Some writers give the page by its index, as destinations in other files do
+		if index, ok := v[0].(int); ok && index >= 0 && index < len(r.d.pages) {
+			return index + 1
+		}
+	case Dict:
+		// A named destination can be a dictionary holding the destination under D
+		return r.destinationPage(v["D"], depth+1)
+	case Name:
+		return r.destinationPage(r.named(string(v)), depth+1)
+	case String:
+		return r.destinationPage(r.named(string(v)), depth+1)
+	}
+	return 0
+}
+
+// named looks up a named destination, in the Dests dictionary of the catalog for names and in the Dests name
+// tree for strings. Both are loaded together, the few names they could share are resolved the same way.
+func (r *outlineReader) named(name string) Object {
+	if r.names == nil {
+		r.names = make(map[string]Object)
+		catalog := r.d.catalog()
+		for key, value := range r.d.dict(catalog["Dests"]) {
+			r.names[string(key)] = value
+		}
+		r.nameTree(r.d.dict(r.d.dict(catalog["Names"])["Dests"]), make(map[int]bool), 0)
+	}
+	return r.names[name]
+}
+
+// nameTree loads the leaves of a name tree into the named destinations
+func (r *outlineReader) nameTree(node Dict, visited map[int]bool, depth int) {
+	if node == nil || depth > maxNameTreeDepth {
+		return
+	}
+
+	names := r.d.array(node["Names"])
+	for i := 0; i+1 < len(names); i += 2 {
+		if key, ok := r.d.resolve(names[i]).(String); ok {
+			if _, exists := r.names[string(key)]; !exists {
+				r.names[string(key)] = names[i+1]
+			}
+		}
+	}
+
+	for _, kid := range r.d.array(node["Kids"]) {
+		if ref, ok := kid.(Ref); ok {
+			if visited[ref.Num] {
+				continue
+			}
+			visited[ref.Num] = true
+		}
+		r.nameTree(r.d.dict(kid), visited, depth+1)
+	}
+}
@@ -0,0 +1,152 @@
+package parser
+
+import (
+	"math"
+)
+
+// maxPageTreeDepth bounds how deep the page tree is followed, so a malformed tree cannot loop forever
+const maxPageTreeDepth = 64
+
+// letterSize is the media box assumed for pages that have none
+var letterSize = [4]float64{0, 0, 612, 792}
+
+// page is a leaf of the page tree, with the attributes it inherits from its ancestors resolved
+type page struct {
+	// num is the object number of the page, 0 for a page that is not an indirect object
+	num       int
+	dict      Dict
+	resources Dict
+	mediaBox  Object
+	cropBox   Object
+	rotate    Object
+}
+
+// collectPages walks the page tree in document order
+func (d *Document) collectPages() []page {
+	var pages []page
+	visited := make(map[int]bool)
+
+	var walk func(obj Object, inherited page, depth int)
+	walk = func(obj Object, inherited page, depth int) {
+		if ref, ok := obj.(Ref); ok {
+			if visited[ref.Num] {
+				return
+			}
+			visited[ref.Num] = true
+		}
+		node := d.dict(obj)
+		if node == nil || depth > maxPageTreeDepth {
+			return
+		}
+
+		if r := d.dict(node["Resources"]); r != nil {
+			inherited.resources = r
+		}
+		if box, ok := node["MediaBox"]; ok {
+			inherited.mediaBox = box
+		}
+		if box, ok := node["CropBox"]; ok {
+			inherited.cropBox = box
+		}
+		if rotate, ok := node["Rotate"]; ok {
+			inherited.rotate = rotate
+		}
+
+		kids, isTree := d.resolve(node["Kids"]).(Array)
+		if !isTree || node["Type"] == Name("Page") {
+			inherited.dict = node
+			if ref, ok := obj.(Ref); ok {
+				inherited.num = ref.Num
+			}
+			pages = append(pages, inherited)
+			return
+		}
+		for _, kid := range kids {
+			walk(kid, inherited, depth+1)
+		}
+	}
+
+	walk(d.catalog()["Pages"], page{}, 0)
+	return pages
+}
+
+// page returns a page by its number, starting from 1
+func (d *Document) page(number int) (page, error) {
+	if number < 1 || number > len(d.pages) {
+		return page{}, ErrPageNotFound
+	}
+	return d.pages[number-1], nil
+}
+
+// PageSize returns the width and height in points of the visible area of a page by its number, starting from 1,
+// as drawn before the page is rotated, and the rotation in degrees clockwise it is displayed with
+func (d *Document) PageSize(number int) (width float64, height float64, rotation int, err error) {
+	p, err := d.page(number)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	box, ok := d.rectangle(p.mediaBox)
+	if !ok {
+		box = letterSize
+	}
+	// The crop box is clipped to the media box
+	if crop, ok := d.rectangle(p.cropBox); ok {
+		box = [4]float64{max(box[0], crop[0]), max(box[1], crop[1]), min(box[2], crop[2]), min(box[3], crop[3])}
+	}
+	width, height = max(box[2]-box[0], 0), max(box[3]-box[1], 0)
+
+	rotate, _ := d.resolve(p.rotate).(int)
+	rotation = (rotate%360 + 360) % 360
+	if rotation%90 != 0 {
+		rotation = 0
+	}
+
+	return width, height, rotation, nil
+}
+
+// rectangle reads a rectangle given by any two opposite corners as its lower left and upper right corners
+func (d *Document) rectangle(obj Object) ([4]float64, bool) {
+	arr := d.array(obj)
+	if len(arr) != 4 {
+		return [4]float64{}, false
+	}
+
+	var values [4]float64
+	for i, item := range arr {
+		v, ok := d.number(item)
+		if !ok || math.IsNaN(v) || math.IsInf(v, 0) {
+			return [4]float64{}, false
+		}
+		values[i] = v
+	}
+	return [4]float64{min(values[0], values[2]), min(values[1], values[3]), max(values[0], values[2]), max(values[1], values[3])}, true
+}
+
+// contents returns the content streams of a page decoded and joined, skipping the streams that cannot be
+// decoded
+func (d *Document) contents(p page) []byte {
+	var streams []*Stream
+	switch v := d.resolve(p.dict["Contents"]).(type) {
+	case *Stream:
+		streams = append(streams, v)
+	case Array:
+		for _, item := range v {
+			if stream := d.stream(item); stream != nil {
+				streams = append(streams, stream)
+			}
+		}
+	}
+
+	var data []byte
+	for _, stream := range streams {
+		decoded, err := d.decodeStream(stream)
+		if err != nil {
+			continue
+		}
+		// A content stream may be split anywhere between two tokens
+		data = append(data, decoded...)
+		data = append(data, '\n')
+	}
+	return data
+}
@@ -0,0 +1,104 @@
+// Package parser extracts the text of PDF documents. It reads the cross-reference tables and streams,
+// object streams and the common stream filters, and runs the text operators of the content streams, mapping
+// the glyphs to text through the ToUnicode maps and encodings of their fonts. It depends on nothing outside
+// of the standard library.
+package parser
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"strings"
+)
+
+// EngineName and Version identify the parser in the parse results it produces
+const (
+	EngineName = "pdfparsing"
+	Version    = "1.0.0"
+)
+
+// Options limit and observe the parsing of a document
+type Options struct {
+	// MaxPages is how many pages the document may have, 0 for no limit
+	MaxPages int
+	// Progress is called after every page with the number of pages parsed so far
+	Progress func(pagesProcessed int, totalPages int)
+}
+
+// Page is the text extracted from one page
+type Page struct {
+	Number int
+	// Width and Height are the size of the visible area of the page in points, before it is rotated
+	Width  float64
+	Height float64
+	// Rotation is how many degrees clockwise the page is turned when displayed, 0, 90, 180 or 270
+	Rotation int
+	Text     string
+}
+
+// Result is the text extracted from a document, page by page, with what the document says about itself
+type Result struct {
+	Version  string
+	Metadata Metadata
+	// Outline holds the bookmarks of the document, nil when it has none
+	Outline []OutlineItem
+	// Fields holds the fields of the interactive form of the document, nil when it has none
+	Fields []FormField
+	Pages  []Page
+}
+
+// Text returns the text of every page, with the pages separated by form feeds
+func (r *Result) Text() string {
+	texts := make([]string, len(r.Pages))
+	for i, p := range r.Pages {
+		texts[i] = p.Text
+	}
+	return strings.Join(texts, "\f")
+}
+
+// Parse extracts the text of every page of a PDF. It stops with the error of the context once it is done,
+// and with ErrTooManyPages before parsing a document with more pages than allowed.
+func Parse(ctx context.Context, data []byte, options Options) (result *Result, err error) {
+	// A bug of the parser must not take down the process, but it is logged with its stack so it gets fixed
+	// instead of passing for a malformed file
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Parser panicked: %v\n%s", r, debug.Stack())
+			result, err = nil, fmt.Errorf("%w: %v", ErrInternal, r)
+		}
+	}()
+
+	doc, err := Open(data)
+	if err != nil {
+		return nil, err
+	}
+
+	total := doc.NumPages()
+	if options.MaxPages > 0 && total > options.MaxPages {
+		return nil, ErrTooManyPages
+	}
+
+	result = &Result{Version: doc.Version(), Metadata: doc.Metadata(), Outline: doc.Outline(), Fields: doc.FormFields(), Pages: make([]Page, 0, total)}
+	for number := 1; number <= total; number++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		text, err := doc.PageText(ctx, number)
+		if err != nil {
+			return nil, err
+		}
+		width, height, rotation, err := doc.PageSize(number)
+		if err != nil {
+			return nil, err
+		}
+		result.Pages = append(result.Pages, Page{Number: number, Width: width, Height: height, Rotation: rotation, Text: text})
+
+		if options.Progress != nil {
+			options.Progress(number, total)
+		}
+	}
+
+	return result, nil
+}
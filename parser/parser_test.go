@@ -0,0 +1,388 @@
+package parser
+
+import (
+	"bytes"
+	"compress/lzw"
+	"compress/zlib"
+	"context"
+	"encoding/ascii85"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// The objects of a one-page document showing its content stream, object 5, with the Helvetica font
+const (
+	testCatalog = "<< /Type /Catalog /Pages 2 0 R >>"
+	testPages   = "<< /Type /Pages /Kids [3 0 R] /Count 1 >>"
+	testPage    = "<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Resources << /Font << /F1 4 0 R >> >> /Contents 5 0 R >>"
+	testFont    = "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>"
+	testContent = "BT /F1 12 Tf 72 712 Td (Hello world) Tj ET"
+)
+
+// pdfFile assembles a PDF whose objects are numbered from 1 in the order given. The objects are located by a
+// cross-reference table, or by a cross-reference stream when compressed is not nil. Compressed maps the
+// numbers of the objects kept in object streams to the number of their stream.
+func pdfFile(objects []string, compressed map[int]int) []byte {
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.5\n%\xe2\xe3\xcf\xd3\n")
+
+	offsets := make(map[int]int)
+	for i, object := range objects {
+		if object == "" {
+			continue
+		}
+		offsets[i+1] = b.Len()
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+
+	start := b.Len()
+	if compressed == nil {
+		fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+		for num := 1; num <= len(objects); num++ {
+			if offset, ok := offsets[num]; ok {
+				fmt.Fprintf(&b, "%010d 00000 n \n", offset)
+			} else {
+				b.WriteString("0000000000 65535 f \n")
+			}
+		}
+		fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R >>\n", len(objects)+1)
+	} else {
+		// The stream locates itself too, its rows hold a type, a 4-byte offset or stream number and a
+		// 2-byte generation or index, stored with the PNG Up predictor
+		num := len(objects) + 1
+		offsets[num] = start
+		var rows []byte
+		for i := 0; i <= num; i++ {
+			row := make([]byte, 7)
+			if offset, ok := offsets[i]; ok {
+				row[0] = 1
+				binary.BigEndian.PutUint32(row[1:], uint32(offset))
+			} else if stream, ok := compressed[i]; ok {
+				row[0] = 2
+				binary.BigEndian.PutUint32(row[1:], uint32(stream))
+			}
+			rows = append(rows, row...)
+		}
+		data := pngUp(rows, 7)
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", num, streamObject(fmt.Sprintf(
+			"/Type /XRef /Size %d /Root 1 0 R /W [1 4 2] /Filter /FlateDecode /DecodeParms << /Predictor 12 /Columns 7 >>",
+			num+1), deflate(data)))
+	}
+
+	fmt.Fprintf(&b, "startxref\n%d\n%%%%EOF\n", start)
+	return b.Bytes()
+}
+
+// streamObject writes a stream with the given dictionary entries and data
+func streamObject(dict string, data []byte) string {
+	return fmt.Sprintf("<< %s /Length %d >>\nstream\n%s\nendstream", dict, len(data), data)
+}
+
+// objStmObject writes an object stream holding the given objects, compressed with Flate
+func objStmObject(objects map[int]string) string {
+	nums := make([]int, 0, len(objects))
+	for num := range objects {
+		nums = append(nums, num)
+	}
+	sort.Ints(nums)
+
+	var header, body bytes.Buffer
+	for _, num := range nums {
+		fmt.Fprintf(&header, "%d %d ", num, body.Len())
+		body.WriteString(objects[num] + "\n")
+	}
+	data := append(header.Bytes(), body.Bytes()...)
+	return streamObject(fmt.Sprintf("/Type /ObjStm /N %d /First %d /Filter /FlateDecode", len(nums), header.Len()), deflate(data))
+}
+
+func deflate(data []byte) []byte {
+	var b bytes.Buffer
+	w := zlib.NewWriter(&b)
+	w.Write(data)
+	w.Close()
+	return b.Bytes()
+}
+
+// pngUp encodes rows of the given width with the PNG Up predictor, each row starting with its predictor byte
+func pngUp(data []byte, columns int) []byte {
+	var out []byte
+	prev := make([]byte, columns)
+	for i := 0; i+columns <= len(data); i += columns {
+		out = append(out, 2)
+		for j, c := range data[i : i+columns] {
+			out = append(out, c-prev[j])
+		}
+		prev = data[i : i+columns]
+	}
+	return out
+}
+
+// parseText parses a document and returns the text of its only page
+func parseText(t *testing.T, data []byte) string {
+	t.Helper()
+	result, err := Parse(context.Background(), data, Options{})
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if len(result.Pages) != 1 {
+		t.Fatalf("Parse returned %d pages, want 1", len(result.Pages))
+	}
+	return strings.TrimSpace(result.Pages[0].Text)
+}
+
+// openXref reads the cross-reference sections of a document without falling back to scanning it
+func openXref(t *testing.T, data []byte) *Document {
+	t.Helper()
+	d := &Document{
+		data:    data,
+		xref:    make(map[int]xrefEntry),
+		objects: make(map[int]Object),
+		loading: make(map[int]bool),
+		streams: make(map[int]*objectStream),
+		fonts:   make(map[int]*font),
+	}
+	if err := d.readXref(); err != nil {
+		t.Fatalf("readXref: %v", err)
+	}
+	if d.catalog() == nil {
+		t.Fatalf("cross-reference sections do not lead to the catalog")
+	}
+	return d
+}
+
+func TestXrefTable(t *testing.T) {
+	data := pdfFile([]string{testCatalog, testPages, testPage, testFont, streamObject("", []byte(testContent))}, nil)
+	openXref(t, data)
+	if text := parseText(t, data); text != "Hello world" {
+		t.Errorf("text = %q, want %q", text, "Hello world")
+	}
+}
+
+func TestFilters(t *testing.T) {
+	var a85 bytes.Buffer
+	encoder := ascii85.NewEncoder(&a85)
+	encoder.Write(deflate([]byte(testContent)))
+	encoder.Close()
+	a85.WriteString("~>")
+
+	var lzwData bytes.Buffer
+	writer := lzw.NewWriter(&lzwData, lzw.MSB, 8)
+	writer.Write([]byte(strings.Repeat(testContent+"\n", 50)))
+	writer.Close()
+
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"Flate", streamObject("/Filter /FlateDecode", deflate([]byte(testContent))), "Hello world"},
+		{"ASCII85Flate", streamObject("/Filter [/ASCII85Decode /FlateDecode]", a85.Bytes()), "Hello world"},
+		{"ASCIIHex", streamObject("/Filter /ASCIIHexDecode", []byte(fmt.Sprintf("%X>", testContent))), "Hello world"},
+		// Writers without the early change grow the code size one code late, as compress/lzw does
+		{"LZW", streamObject("/Filter /LZWDecode /DecodeParms << /EarlyChange 0 >>", lzwData.Bytes()),
+			strings.TrimSpace(strings.Repeat("Hello world", 50))},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := pdfFile([]string{testCatalog, testPages, testPage, testFont, test.content}, nil)
+			if text := strings.Join(strings.Fields(parseText(t, data)), ""); text != strings.ReplaceAll(test.want, " ", "") {
+				t.Errorf("text = %q, want %q", text, test.want)
+			}
+		})
+	}
+}
+
+func TestDecodeLZW(t *testing.T) {
+	// The example of the PDF specification, with the default early change
+	data := []byte{0x80, 0x0B, 0x60, 0x50, 0x22, 0x0C, 0x0C, 0x85, 0x01}
+	decoded, err := decodeLZW(data, true)
+	if err != nil {
+		t.Fatalf("decodeLZW: %v", err)
+	}
+	if string(decoded) != "-----A---B" {
+		t.Errorf("decodeLZW = %q, want %q", decoded, "-----A---B")
+	}
+}
+
+func TestDecodeASCII85(t *testing.T) {
+	tests := []struct {
+		data string
+		want string
+	}{
+		{"<~z~>", "\x00\x00\x00\x00"},
+		{"<~87cURD]i,\"Ebo80~>", "Hello World!"},
+		{" 87cURD]i,\n\"Ebo80 ~>", "Hello World!"},
+	}
+	for _, test := range tests {
+		decoded, err := decodeASCII85([]byte(test.data))
+		if err != nil {
+			t.Errorf("decodeASCII85(%q): %v", test.data, err)
+			continue
+		}
+		if string(decoded) != test.want {
+			t.Errorf("decodeASCII85(%q) = %q, want %q", test.data, decoded, test.want)
+		}
+	}
+
+	if _, err := decodeASCII85([]byte("<~\x7f~>")); !errors.Is(err, ErrMalformed) {
+		t.Errorf("decodeASCII85 of an invalid character = %v, want ErrMalformed", err)
+	}
+}
+
+func TestXrefStream(t *testing.T) {
+	data := pdfFile([]string{testCatalog, testPages, testPage, testFont, streamObject("", []byte(testContent))}, map[int]int{})
+
+	d := openXref(t, data)
+	if entry := d.xref[5]; entry.inStream || entry.offset <= 0 {
+		t.Errorf("xref entry of object 5 = %+v, want an offset", entry)
+	}
+	if entry := d.xref[0]; entry.offset != -1 {
+		t.Errorf("xref entry of object 0 = %+v, want a free entry", entry)
+	}
+	if text := parseText(t, data); text != "Hello world" {
+		t.Errorf("text = %q, want %q", text, "Hello world")
+	}
+}
+
+func TestObjectStream(t *testing.T) {
+	objects := []string{
+		testCatalog,
+		"",
+		"",
+		"",
+		streamObject("", []byte(testContent)),
+		objStmObject(map[int]string{2: testPages, 3: testPage, 4: testFont}),
+	}
+	data := pdfFile(objects, map[int]int{2: 6, 3: 6, 4: 6})
+
+	d := openXref(t, data)
+	if entry := d.xref[3]; !entry.inStream || entry.stream != 6 {
+		t.Errorf("xref entry of object 3 = %+v, want object stream 6", entry)
+	}
+	if text := parseText(t, data); text != "Hello world" {
+		t.Errorf("text = %q, want %q", text, "Hello world")
+	}
+}
+
+func TestObjectStreamNegativeOffset(t *testing.T) {
+	header := "2 -1000 "
+	objStm := streamObject(fmt.Sprintf("/Type /ObjStm /N 1 /First %d", len(header)), []byte(header+testPages))
+	data := pdfFile([]string{testCatalog, "", testPage, testFont, streamObject("", []byte(testContent)), objStm}, map[int]int{2: 6})
+
+	// Open does not recover like Parse, so reading before the start of the stream fails the test
+	if doc, err := Open(data); err == nil {
+		doc.NumPages()
+	}
+	if _, err := Parse(context.Background(), data, Options{}); errors.Is(err, ErrInternal) {
+		t.Errorf("Parse = %v, want the negative offset ignored", err)
+	}
+}
+
+func TestRebuildXref(t *testing.T) {
+	data := pdfFile([]string{testCatalog, testPages, testPage, testFont, streamObject("", []byte(testContent))}, nil)
+	// Every offset of the table is wrong once the file is shifted
+	data = bytes.Replace(data, []byte("%PDF-1.5\n"), []byte("%PDF-1.5\n%padding\n"), 1)
+	if text := parseText(t, data); text != "Hello world" {
+		t.Errorf("text = %q, want %q", text, "Hello world")
+	}
+}
+
+func TestToUnicode(t *testing.T) {
+	simpleMap := `/CIDInit /ProcSet findresource begin 12 dict begin begincmap
+1 begincodespacerange <00> <FF> endcodespacerange
+1 beginbfchar <01> <0048> endbfchar
+1 beginbfrange <02> <03> <0069> endbfrange
+endcmap CMapName currentdict /CMap defineresource pop end end`
+	compositeMap := `begincmap
+1 begincodespacerange <0000> <FFFF> endcodespacerange
+2 beginbfchar <0001> <00480065> <0002> <D83DDE00> endbfchar
+1 beginbfrange <0003> <0004> [<006C006C> <006F>] endbfrange
+endcmap`
+
+	tests := []struct {
+		name    string
+		font    string
+		content string
+		want    string
+	}{
+		{
+			name:    "Simple",
+			font:    "<< /Type /Font /Subtype /Type1 /BaseFont /Custom /ToUnicode 6 0 R >>",
+			content: `BT /F1 12 Tf 72 712 Td (\001\002\003) Tj ET`,
+			want:    "Hij",
+		},
+		{
+			name: "Composite",
+			font: "<< /Type /Font /Subtype /Type0 /BaseFont /Custom /Encoding /Identity-H /ToUnicode 6 0 R " +
+				"/DescendantFonts [<< /Type /Font /Subtype /CIDFontType2 /BaseFont /Custom /DW 500 >>] >>",
+			content: `BT /F1 12 Tf 72 712 Td <0001000300040002> Tj ET`,
+			want:    "Hello\U0001F600",
+		},
+	}
+
+	cmaps := map[string]string{"Simple": simpleMap, "Composite": compositeMap}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			objects := []string{
+				testCatalog, testPages, testPage, test.font,
+				streamObject("", []byte(test.content)),
+				streamObject("/Filter /FlateDecode", deflate([]byte(cmaps[test.name]))),
+			}
+			if text := parseText(t, pdfFile(objects, nil)); text != test.want {
+				t.Errorf("text = %q, want %q", text, test.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	if _, err := Parse(context.Background(), []byte("not a pdf"), Options{}); !errors.Is(err, ErrNotPDF) {
+		t.Errorf("Parse of a text file = %v, want ErrNotPDF", err)
+	}
+	if _, err := Parse(context.Background(), []byte("%PDF-1.4\n%%EOF\n"), Options{}); !errors.Is(err, ErrMalformed) {
+		t.Errorf("Parse of a PDF without objects = %v, want ErrMalformed", err)
+	}
+
+	data := pdfFile([]string{testCatalog, testPages, testPage, testFont, streamObject("", []byte(testContent))}, nil)
+	if _, err := Parse(context.Background(), data, Options{MaxPages: 1}); err != nil {
+		t.Errorf("Parse within the page limit: %v", err)
+	}
+	two := pdfFile([]string{testCatalog, "<< /Type /Pages /Kids [3 0 R 6 0 R] /Count 2 >>", testPage, testFont,
+		streamObject("", []byte(testContent)), testPage}, nil)
+	if _, err := Parse(context.Background(), two, Options{MaxPages: 1}); !errors.Is(err, ErrTooManyPages) {
+		t.Errorf("Parse over the page limit = %v, want ErrTooManyPages", err)
+	}
+}
+
+// FuzzParse runs the steps of Parse without its recover, so a panic on malformed input fails the fuzzer
+// instead of being turned into ErrInternal
+func FuzzParse(f *testing.F) {
+	content := streamObject("/Filter /FlateDecode", deflate([]byte(testContent)))
+	f.Add(pdfFile([]string{testCatalog, testPages, testPage, testFont, content}, nil))
+	f.Add(pdfFile([]string{testCatalog, testPages, testPage, testFont, content}, map[int]int{}))
+	f.Add(pdfFile([]string{testCatalog, "", "", "", content, objStmObject(map[int]string{2: testPages, 3: testPage, 4: testFont})},
+		map[int]int{2: 6, 3: 6, 4: 6}))
+	f.Add([]byte("%PDF-1.7\n1 0 obj << /Type /Catalog /Pages 2 0 R /Outlines 3 0 R /AcroForm << /Fields [4 0 R] >> >> endobj\n" +
+		"2 0 obj << /Type /Pages /Kids [] /Count 0 >> endobj\n" +
+		"3 0 obj << /First 5 0 R >> endobj\n4 0 obj << /T (a) /FT /Tx /V (b) >> endobj\n" +
+		"5 0 obj << /Title (c) /Dest [0 /Fit] >> endobj\ntrailer << /Root 1 0 R >>\n"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		doc, err := Open(data)
+		if err != nil {
+			return
+		}
+		doc.Metadata()
+		doc.Outline()
+		doc.FormFields()
+		for number := 1; number <= min(doc.NumPages(), 10); number++ {
+			doc.PageText(context.Background(), number)
+			doc.PageSize(number)
+		}
+	})
+}
@@ -0,0 +1,845 @@
+package queue
+
+import (
+	er "PDFStoring/error"
+	"PDFStoring/models"
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Memory is a Backend keeping the queue in memory. It suits tests and single-node setups: the queue is lost
+// when the process stops, and it cannot be shared between API instances. Only the queue is kept in memory, the
+// files, users and parse results around it still need Postgres.
+//
+// Changes made within a transaction are seen by everyone right away, and undone when the transaction rolls
+// back. Like the rows of the Postgres backend, the files and users a transaction changes or gets are locked until
+// it ends: changing them waits, and claims pass them over, so a rollback never undoes what others changed.
+type Memory struct {
+	config Config
+
+	mu          sync.Mutex
+	nextID      int
+	entries     map[int]*models.Queue
+	deadLetters map[int]*memoryDeadLetter
+	users       map[int]*memoryUser
+	// undo holds what the transactions still running locked, by transaction
+	undo map[*Tx]*memoryUndo
+	// locks holds the transaction each locked file is locked by, and userLocks the one each locked user is
+	locks     map[int]*Tx
+	userLocks map[int]*Tx
+	// released is closed whenever a transaction ends and lets go of its locks
+	released chan struct{}
+}
+
+// memoryUndo holds the files and users a transaction locked, with their state from before it locked them, a nil
+// entry, dead letter or user being one that did not exist
+type memoryUndo struct {
+	entries     map[int]*models.Queue
+	deadLetters map[int]*memoryDeadLetter
+	users       map[int]*memoryUser
+}
+
+// memoryDeadLetter keeps what is needed to requeue a dead-lettered file
+type memoryDeadLetter struct {
+	models.DeadLetter
+	entry models.Queue
+}
+
+// memoryUser holds what the scheduling rules need to know about a user
+type memoryUser struct {
+	maxInFlight   *int
+	lastClaimedAt time.Time
+}
+
+// NewMemory creates an empty in-memory queue with the given settings
+func NewMemory(config Config) *Memory {
+	return &Memory{
+		config:      config,
+		entries:     make(map[int]*models.Queue),
+		deadLetters: make(map[int]*memoryDeadLetter),
+		users:       make(map[int]*memoryUser),
+		undo:        make(map[*Tx]*memoryUndo),
+		locks:       make(map[int]*Tx),
+		userLocks:   make(map[int]*Tx),
+		released:    make(chan struct{}),
+	}
+}
+
+// Enqueue adds a file to the queue
+func (m *Memory) Enqueue(ctx context.Context, entry models.Queue) error {
+	if err := checkEntry(entry); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.lock(ctx, entry.FileID); err != nil {
+		return err
+	}
+	return m.enqueue(entry)
+}
+
+// enqueue adds a file to the queue with none of its attempts used, the lock and the file must be held
+func (m *Memory) enqueue(entry models.Queue) error {
+	if _, ok := m.entries[entry.FileID]; ok {
+		return er.ErrAlreadyQueued
+	}
+
+	m.nextID++
+	queued := models.Queue{
+		ID:                   m.nextID,
+		FileID:               entry.FileID,
+		BlobKey:              entry.BlobKey,
+		FileSize:             entry.FileSize,
+		UserID:               copyInt(entry.UserID),
+		Priority:             entry.Priority,
+		NotBefore:            copyTime(entry.NotBefore),
+		RequiredCapabilities: copyStrings(entry.RequiredCapabilities),
+		MaxPages:             entry.MaxPages,
+		MaxParseSeconds:      entry.MaxParseSeconds,
+		CreatedAt:            time.Now(),
+	}
+	m.entries[entry.FileID] = &queued
+	return nil
+}
+
+// Claim leases the next file the worker can handle
+func (m *Memory) Claim(ctx context.Context, request ClaimRequest) (*models.Queue, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	inFlight := m.inFlight()
+
+	var next *models.Queue
+	var nextRank int
+	for _, entry := range m.entries {
+		if !m.claimable(ctx, entry, request, inFlight, now) {
+			continue
+		}
+		rank := m.rank(entry, now)
+		if next == nil || m.before(entry, rank, next, nextRank, inFlight) {
+			next, nextRank = entry, rank
+		}
+	}
+	if next == nil {
+		return nil, er.ErrQueueEmpty
+	}
+
+	m.take(ctx, next.FileID)
+	if next.UserID != nil {
+		m.takeUser(ctx, *next.UserID)
+	}
+
+	// The time budget of a file starts when it is claimed, and every attempt gets a full budget
+	leasedUntil := now.Add(m.config.LeaseDuration)
+	next.LeaseToken = uuid.NewString()
+	next.LeasedUntil = &leasedUntil
+	next.ClaimedAt = copyTime(&now)
+	next.ParseDeadline = nil
+	if next.MaxParseSeconds > 0 {
+		deadline := now.Add(time.Duration(next.MaxParseSeconds * float64(time.Second)))
+		next.ParseDeadline = &deadline
+	}
+	next.Attempts++
+	next.WorkerID = nil
+	if request.WorkerID != 0 {
+		workerId := request.WorkerID
+		next.WorkerID = &workerId
+	}
+	clearProgress(next)
+
+	if next.UserID != nil {
+		m.user(*next.UserID).lastClaimedAt = now
+	}
+
+	return copyEntry(next), nil
+}
+
+// claimable reports whether the entry can be handed to the worker now, files and users locked by another
+// transaction being passed over
+func (m *Memory) claimable(ctx context.Context, entry *models.Queue, request ClaimRequest, inFlight map[int]int, now time.Time) bool {
+	if entry.LeaseToken != "" || m.lockedByOther(ctx, entry.FileID) {
+		return false
+	}
+	if entry.UserID != nil && m.userLockedByOther(ctx, *entry.UserID) {
+		return false
+	}
+	if entry.RetryAt != nil && entry.RetryAt.After(now) {
+		return false
+	}
+	if entry.NotBefore != nil && entry.NotBefore.After(now) {
+		return false
+	}
+	if entry.UserID != nil {
+		maxInFlight := m.config.UserMaxInFlight
+		if user, ok := m.users[*entry.UserID]; ok && user.maxInFlight != nil {
+			maxInFlight = *user.maxInFlight
+		}
+		if maxInFlight != 0 && inFlight[*entry.UserID] >= maxInFlight {
+			return false
+		}
+	}
+	return covers(request.Capabilities, entry.RequiredCapabilities)
+}
+
+// rank returns the lane the entry is served from, after moving it up one lane for every aging interval it
+// waited since it was queued or became due
+func (m *Memory) rank(entry *models.Queue, now time.Time) int {
+	rank := laneRanks[entry.Priority]
+	if m.config.PriorityAgingInterval <= 0 {
+		return rank
+	}
+
+	waitingSince := entry.CreatedAt
+	if entry.NotBefore != nil && entry.NotBefore.After(waitingSince) {
+		waitingSince = *entry.NotBefore
+	}
+	rank -= int(now.Sub(waitingSince) / m.config.PriorityAgingInterval)
+	if rank < 0 {
+		rank = 0
+	}
+	return rank
+}
+
+// before reports whether the entry a is served before the entry b
+func (m *Memory) before(a *models.Queue, aRank int, b *models.Queue, bRank int, inFlight map[int]int) bool {
+	if aRank != bRank {
+		return aRank < bRank
+	}
+
+	aJobs, bJobs := userJobs(a, inFlight), userJobs(b, inFlight)
+	if aJobs != bJobs {
+		return aJobs < bJobs
+	}
+
+	// Users never served go first, like files without a user
+	aClaimed, bClaimed := m.lastClaimedAt(a), m.lastClaimedAt(b)
+	if !aClaimed.Equal(bClaimed) {
+		return aClaimed.Before(bClaimed)
+	}
+
+	return a.ID < b.ID
+}
+
+// Get returns the entry of a queued file, locking it until the transaction of the context ends
+func (m *Memory) Get(ctx context.Context, fileId int) (*models.Queue, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.lock(ctx, fileId); err != nil {
+		return nil, err
+	}
+	entry, ok := m.entries[fileId]
+	if !ok {
+		return nil, er.ErrNotQueued
+	}
+	return copyEntry(entry), nil
+}
+
+// List returns the entries of the given files that are in the queue
+func (m *Memory) List(ctx context.Context, fileIds []int) ([]models.Queue, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entries := []models.Queue{}
+	for _, fileId := range fileIds {
+		if entry, ok := m.entries[fileId]; ok {
+			entries = append(entries, *copyEntry(entry))
+		}
+	}
+	sortByFileID(entries)
+	return entries, nil
+}
+
+// Leased returns every leased entry
+func (m *Memory) Leased(ctx context.Context) ([]models.Queue, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entries := []models.Queue{}
+	for _, entry := range m.entries {
+		if entry.LeaseToken != "" {
+			entries = append(entries, *copyEntry(entry))
+		}
+	}
+	sortByFileID(entries)
+	return entries, nil
+}
+
+// Ack removes a file whose attempt succeeded
+func (m *Memory) Ack(ctx context.Context, fileId int, leaseToken string) (*models.Queue, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.lock(ctx, fileId); err != nil {
+		return nil, err
+	}
+	entry, err := m.leased(fileId, leaseToken)
+	if err != nil {
+		return nil, err
+	}
+	delete(m.entries, fileId)
+	return entry, nil
+}
+
+// Nack fails the current attempt of a file
+func (m *Memory) Nack(ctx context.Context, fileId int, leaseToken string, reason string) (*Failure, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.lock(ctx, fileId); err != nil {
+		return nil, err
+	}
+	if _, err := m.leased(fileId, leaseToken); err != nil {
+		return nil, err
+	}
+	failure := m.fail(m.entries[fileId], reason, time.Now())
+	return &failure, nil
+}
+
+// ExtendLease moves the expiry of the lease to duration from now
+func (m *Memory) ExtendLease(ctx context.Context, fileId int, leaseToken string, duration time.Duration) (time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.lock(ctx, fileId); err != nil {
+		return time.Time{}, err
+	}
+	if _, err := m.leased(fileId, leaseToken); err != nil {
+		return time.Time{}, err
+	}
+	leasedUntil := time.Now().Add(duration)
+	m.entries[fileId].LeasedUntil = &leasedUntil
+	return leasedUntil, nil
+}
+
+// ReportProgress records how far the holder of the lease got with the file
+func (m *Memory) ReportProgress(ctx context.Context, fileId int, report models.ProgressReport) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.lock(ctx, fileId); err != nil {
+		return err
+	}
+	if _, err := m.leased(fileId, report.LeaseToken); err != nil {
+		return err
+	}
+	now := time.Now()
+	entry := m.entries[fileId]
+	entry.PagesProcessed = copyInt(&report.PagesProcessed)
+	entry.TotalPages = copyInt(&report.TotalPages)
+	entry.Stage = report.Stage
+	entry.ProgressAt = &now
+	return nil
+}
+
+// Remove takes a file out of the queue whether it is leased or not
+func (m *Memory) Remove(ctx context.Context, fileId int) (*models.Queue, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.lock(ctx, fileId); err != nil {
+		return nil, err
+	}
+	entry, ok := m.entries[fileId]
+	if !ok {
+		return nil, er.ErrNotQueued
+	}
+	delete(m.entries, fileId)
+	return copyEntry(entry), nil
+}
+
+// ExpireLeases fails the attempts whose lease expired
+func (m *Memory) ExpireLeases(ctx context.Context, reason string) ([]Failure, error) {
+	now := time.Now()
+	return m.failWhere(ctx, reason, now, func(entry *models.Queue) bool {
+		return entry.LeasedUntil.Before(now)
+	}), nil
+}
+
+// ReleaseWorkers fails the attempts leased to the given workers
+func (m *Memory) ReleaseWorkers(ctx context.Context, workerIds []int, reason string) ([]Failure, error) {
+	workers := make(map[int]bool)
+	for _, workerId := range workerIds {
+		workers[workerId] = true
+	}
+	return m.failWhere(ctx, reason, time.Now(), func(entry *models.Queue) bool {
+		return entry.WorkerID != nil && workers[*entry.WorkerID]
+	}), nil
+}
+
+// ExpireParseDeadlines removes the leased files past their parse deadline, except those locked by another
+// transaction
+func (m *Memory) ExpireParseDeadlines(ctx context.Context) ([]models.Queue, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	expired := []models.Queue{}
+	for fileId, entry := range m.entries {
+		if entry.LeaseToken != "" && entry.ParseDeadline != nil && entry.ParseDeadline.Before(now) && !m.lockedByOther(ctx, fileId) {
+			expired = append(expired, *copyEntry(entry))
+			m.take(ctx, fileId)
+			delete(m.entries, fileId)
+		}
+	}
+	sortByFileID(expired)
+	return expired, nil
+}
+
+// DeadLetters returns every dead-lettered file, most recently failed first
+func (m *Memory) DeadLetters(ctx context.Context) ([]models.DeadLetter, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	deadLetters := []models.DeadLetter{}
+	for _, deadLetter := range m.deadLetters {
+		deadLetters = append(deadLetters, deadLetter.DeadLetter)
+	}
+	sort.Slice(deadLetters, func(i, j int) bool {
+		if !deadLetters[i].FailedAt.Equal(deadLetters[j].FailedAt) {
+			return deadLetters[i].FailedAt.After(deadLetters[j].FailedAt)
+		}
+		return deadLetters[i].ID > deadLetters[j].ID
+	})
+	return deadLetters, nil
+}
+
+// RequeueDeadLetter puts a dead-lettered file back in the queue with a fresh set of attempts
+func (m *Memory) RequeueDeadLetter(ctx context.Context, fileId int) (*models.Queue, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.lock(ctx, fileId); err != nil {
+		return nil, err
+	}
+	deadLetter, ok := m.deadLetters[fileId]
+	if !ok {
+		return nil, er.ErrNotDeadLettered
+	}
+
+	// The file is due right away, even if it was scheduled when first queued
+	entry := deadLetter.entry
+	entry.NotBefore = nil
+	err := m.enqueue(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	delete(m.deadLetters, fileId)
+	return copyEntry(m.entries[fileId]), nil
+}
+
+// SetUserMaxInFlight overrides the concurrency cap of a user
+func (m *Memory) SetUserMaxInFlight(ctx context.Context, userId int, maxInFlight *int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.lockUser(ctx, userId); err != nil {
+		return err
+	}
+	m.user(userId).maxInFlight = copyInt(maxInFlight)
+	return nil
+}
+
+// Stats returns the depth of every lane holding files
+func (m *Memory) Stats(ctx context.Context) ([]models.LaneDepth, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	lanes := make(map[string]*models.LaneDepth)
+	for _, entry := range m.entries {
+		lane, ok := lanes[entry.Priority]
+		if !ok {
+			lane = &models.LaneDepth{Priority: entry.Priority}
+			lanes[entry.Priority] = lane
+		}
+		if entry.LeaseToken != "" {
+			lane.Leased++
+			continue
+		}
+		lane.Waiting++
+		if age := now.Sub(entry.CreatedAt).Seconds(); age > lane.OldestAgeSeconds {
+			lane.OldestAgeSeconds = age
+		}
+	}
+
+	depths := []models.LaneDepth{}
+	for _, lane := range lanes {
+		depths = append(depths, *lane)
+	}
+	sort.Slice(depths, func(i, j int) bool {
+		return laneRanks[depths[i].Priority] < laneRanks[depths[j].Priority]
+	})
+	return depths, nil
+}
+
+// Unroutable lists the oldest waiting files that require capabilities no set of the given capabilities covers
+func (m *Memory) Unroutable(ctx context.Context, capabilities [][]string, limit int) ([]models.UnroutableJob, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var unroutable []*models.Queue
+	for _, entry := range m.entries {
+		if entry.LeaseToken != "" || len(entry.RequiredCapabilities) == 0 {
+			continue
+		}
+		routable := false
+		for _, set := range capabilities {
+			if covers(set, entry.RequiredCapabilities) {
+				routable = true
+				break
+			}
+		}
+		if !routable {
+			unroutable = append(unroutable, entry)
+		}
+	}
+	sort.Slice(unroutable, func(i, j int) bool {
+		if !unroutable[i].CreatedAt.Equal(unroutable[j].CreatedAt) {
+			return unroutable[i].CreatedAt.Before(unroutable[j].CreatedAt)
+		}
+		return unroutable[i].ID < unroutable[j].ID
+	})
+
+	jobs := []models.UnroutableJob{}
+	for _, entry := range unroutable {
+		if len(jobs) == limit {
+			break
+		}
+		jobs = append(jobs, models.UnroutableJob{
+			FileID:               entry.FileID,
+			Priority:             entry.Priority,
+			RequiredCapabilities: copyStrings(entry.RequiredCapabilities),
+			QueuedAt:             entry.CreatedAt,
+		})
+	}
+	return jobs, len(unroutable), nil
+}
+
+// leased returns a copy of the entry of a file leased under the token, the lock must be held
+func (m *Memory) leased(fileId int, leaseToken string) (*models.Queue, error) {
+	entry, ok := m.entries[fileId]
+	if !ok || entry.LeaseToken == "" || entry.LeaseToken != leaseToken {
+		return nil, er.ErrNotLeased
+	}
+	return copyEntry(entry), nil
+}
+
+// failWhere fails the current attempt of every leased entry matching the condition, except those locked by
+// another transaction
+func (m *Memory) failWhere(ctx context.Context, reason string, now time.Time, match func(entry *models.Queue) bool) []Failure {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var leased []*models.Queue
+	for _, entry := range m.entries {
+		if entry.LeaseToken != "" && match(entry) && !m.lockedByOther(ctx, entry.FileID) {
+			leased = append(leased, entry)
+		}
+	}
+	sort.Slice(leased, func(i, j int) bool { return leased[i].FileID < leased[j].FileID })
+
+	failures := []Failure{}
+	for _, entry := range leased {
+		m.take(ctx, entry.FileID)
+		failures = append(failures, m.fail(entry, reason, now))
+	}
+	return failures
+}
+
+// fail releases the lease of an entry whose attempt failed, retrying it after an exponential backoff or
+// dead-lettering it once it used up its attempts. The lock and the file must be held.
+func (m *Memory) fail(entry *models.Queue, reason string, now time.Time) Failure {
+	entry.LeaseToken = ""
+	entry.LeasedUntil = nil
+	entry.ParseDeadline = nil
+	entry.WorkerID = nil
+	entry.LastError = reason
+	clearProgress(entry)
+
+	if entry.Attempts >= m.config.MaxAttempts {
+		delete(m.entries, entry.FileID)
+		m.nextID++
+		m.deadLetters[entry.FileID] = &memoryDeadLetter{
+			DeadLetter: models.DeadLetter{
+				ID:        m.nextID,
+				FileID:    entry.FileID,
+				Attempts:  entry.Attempts,
+				LastError: reason,
+				FailedAt:  now,
+			},
+			entry: *entry,
+		}
+		return Failure{Entry: *copyEntry(entry), DeadLettered: true}
+	}
+
+	retryAt := now.Add(m.config.RetryDelay(entry.Attempts))
+	entry.RetryAt = &retryAt
+	return Failure{Entry: *copyEntry(entry)}
+}
+
+// lock waits until no other transaction holds the file, then takes it for the transaction of the context.
+// Without a transaction it only waits. The lock must be held, it is let go of while waiting.
+func (m *Memory) lock(ctx context.Context, fileId int) error {
+	for m.lockedByOther(ctx, fileId) {
+		if err := m.waitForRelease(ctx); err != nil {
+			return err
+		}
+	}
+	m.take(ctx, fileId)
+	return nil
+}
+
+// lockUser waits until no other transaction holds the user, then takes it for the transaction of the context.
+// Without a transaction it only waits. The lock must be held, it is let go of while waiting.
+func (m *Memory) lockUser(ctx context.Context, userId int) error {
+	for m.userLockedByOther(ctx, userId) {
+		if err := m.waitForRelease(ctx); err != nil {
+			return err
+		}
+	}
+	m.takeUser(ctx, userId)
+	return nil
+}
+
+// waitForRelease waits for a transaction to end and let go of what it held. The lock must be held, it is let
+// go of while waiting.
+func (m *Memory) waitForRelease(ctx context.Context) error {
+	released := m.released
+	m.mu.Unlock()
+	defer m.mu.Lock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-released:
+		return nil
+	}
+}
+
+// lockedByOther reports whether the file is held by another transaction than the one of the context
+func (m *Memory) lockedByOther(ctx context.Context, fileId int) bool {
+	tx, ok := m.locks[fileId]
+	return ok && tx != queueTxFromContext(ctx)
+}
+
+// userLockedByOther reports whether the user is held by another transaction than the one of the context
+func (m *Memory) userLockedByOther(ctx context.Context, userId int) bool {
+	tx, ok := m.userLocks[userId]
+	return ok && tx != queueTxFromContext(ctx)
+}
+
+// take holds a file no other transaction holds for the transaction of the context, saving its entry and dead
+// letter the first time so they can be put back when it rolls back. The lock must be held.
+func (m *Memory) take(ctx context.Context, fileId int) {
+	tx, undo := m.undoOf(ctx)
+	if undo == nil {
+		return
+	}
+	if _, ok := undo.entries[fileId]; ok {
+		return
+	}
+
+	m.locks[fileId] = tx
+	undo.entries[fileId] = nil
+	if entry, ok := m.entries[fileId]; ok {
+		undo.entries[fileId] = copyEntry(entry)
+	}
+	undo.deadLetters[fileId] = nil
+	if deadLetter, ok := m.deadLetters[fileId]; ok {
+		copied := *deadLetter
+		copied.entry = *copyEntry(&deadLetter.entry)
+		undo.deadLetters[fileId] = &copied
+	}
+}
+
+// takeUser holds a user no other transaction holds for the transaction of the context, saving its scheduling
+// state the first time so it can be put back when it rolls back. The lock must be held.
+func (m *Memory) takeUser(ctx context.Context, userId int) {
+	tx, undo := m.undoOf(ctx)
+	if undo == nil {
+		return
+	}
+	if _, ok := undo.users[userId]; ok {
+		return
+	}
+
+	m.userLocks[userId] = tx
+	undo.users[userId] = nil
+	if user, ok := m.users[userId]; ok {
+		undo.users[userId] = &memoryUser{maxInFlight: copyInt(user.maxInFlight), lastClaimedAt: user.lastClaimedAt}
+	}
+}
+
+// undoOf returns the transaction of the context and what it holds so far, or nil when there is no transaction.
+// The lock must be held.
+func (m *Memory) undoOf(ctx context.Context) (*Tx, *memoryUndo) {
+	tx := queueTxFromContext(ctx)
+	if tx == nil {
+		return nil, nil
+	}
+	if undo, ok := m.undo[tx]; ok {
+		return tx, undo
+	}
+
+	undo := &memoryUndo{
+		entries:     make(map[int]*models.Queue),
+		deadLetters: make(map[int]*memoryDeadLetter),
+		users:       make(map[int]*memoryUser),
+	}
+	m.undo[tx] = undo
+	tx.onEnd(func(committed bool) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		delete(m.undo, tx)
+		if !committed {
+			m.restore(undo)
+		}
+		for fileId := range undo.entries {
+			delete(m.locks, fileId)
+		}
+		for userId := range undo.users {
+			delete(m.userLocks, userId)
+		}
+		close(m.released)
+		m.released = make(chan struct{})
+	})
+	return tx, undo
+}
+
+// restore puts back the files and users a transaction held as they were before it, the lock must be held
+func (m *Memory) restore(undo *memoryUndo) {
+	for fileId, entry := range undo.entries {
+		delete(m.entries, fileId)
+		if entry != nil {
+			m.entries[fileId] = entry
+		}
+	}
+	for fileId, deadLetter := range undo.deadLetters {
+		delete(m.deadLetters, fileId)
+		if deadLetter != nil {
+			m.deadLetters[fileId] = deadLetter
+		}
+	}
+	for userId, user := range undo.users {
+		delete(m.users, userId)
+		if user != nil {
+			m.users[userId] = user
+		}
+	}
+}
+
+// inFlight counts the leased files of every user, the lock must be held
+func (m *Memory) inFlight() map[int]int {
+	inFlight := make(map[int]int)
+	for _, entry := range m.entries {
+		if entry.LeaseToken != "" && entry.UserID != nil {
+			inFlight[*entry.UserID]++
+		}
+	}
+	return inFlight
+}
+
+// lastClaimedAt returns when the user of the entry was last handed a file, the zero time when never
+func (m *Memory) lastClaimedAt(entry *models.Queue) time.Time {
+	if entry.UserID == nil {
+		return time.Time{}
+	}
+	if user, ok := m.users[*entry.UserID]; ok {
+		return user.lastClaimedAt
+	}
+	return time.Time{}
+}
+
+// user returns the scheduling state of a user, creating it when needed. The lock must be held.
+func (m *Memory) user(userId int) *memoryUser {
+	user, ok := m.users[userId]
+	if !ok {
+		user = &memoryUser{}
+		m.users[userId] = user
+	}
+	return user
+}
+
+// userJobs returns how many files the user of the entry has leased
+func userJobs(entry *models.Queue, inFlight map[int]int) int {
+	if entry.UserID == nil {
+		return 0
+	}
+	return inFlight[*entry.UserID]
+}
+
+// covers reports whether the capabilities include every required capability
+func covers(capabilities []string, required []string) bool {
+	for _, capability := range required {
+		found := false
+		for _, available := range capabilities {
+			if available == capability {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// clearProgress forgets the progress reported during the previous attempt
+func clearProgress(entry *models.Queue) {
+	entry.PagesProcessed = nil
+	entry.TotalPages = nil
+	entry.Stage = ""
+	entry.ProgressAt = nil
+}
+
+func sortByFileID(entries []models.Queue) {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].FileID < entries[j].FileID })
+}
+
+// copyEntry returns a copy of the entry that shares nothing with it
+func copyEntry(entry *models.Queue) *models.Queue {
+	copied := *entry
+	copied.UserID = copyInt(entry.UserID)
+	copied.LeasedUntil = copyTime(entry.LeasedUntil)
+	copied.WorkerID = copyInt(entry.WorkerID)
+	copied.ClaimedAt = copyTime(entry.ClaimedAt)
+	copied.ParseDeadline = copyTime(entry.ParseDeadline)
+	copied.RetryAt = copyTime(entry.RetryAt)
+	copied.NotBefore = copyTime(entry.NotBefore)
+	copied.RequiredCapabilities = copyStrings(entry.RequiredCapabilities)
+	copied.PagesProcessed = copyInt(entry.PagesProcessed)
+	copied.TotalPages = copyInt(entry.TotalPages)
+	copied.ProgressAt = copyTime(entry.ProgressAt)
+	return &copied
+}
+
+func copyInt(value *int) *int {
+	if value == nil {
+		return nil
+	}
+	copied := *value
+	return &copied
+}
+
+func copyTime(value *time.Time) *time.Time {
+	if value == nil {
+		return nil
+	}
+	copied := *value
+	return &copied
+}
+
+func copyStrings(values []string) []string {
+	return append([]string{}, values...)
+}
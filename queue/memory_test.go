@@ -0,0 +1,10 @@
+package queue_test
+
+import (
+	"PDFStoring/queue/queuetest"
+	"testing"
+)
+
+func TestMemory(t *testing.T) {
+	queuetest.Run(t, queuetest.Memory())
+}
@@ -0,0 +1,736 @@
+package queue
+
+import (
+	er "PDFStoring/error"
+	"PDFStoring/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// uniqueViolation is the Postgres error code of a unique constraint violation
+const uniqueViolation = "23505"
+
+// entryColumns are the columns of the queue table scanned by scanEntry
+const entryColumns = `id, file_id, blob_key, file_size, user_id, priority, lease_token, leased_until, worker_id, claimed_at,
+	parse_deadline, attempts, retry_at, COALESCE(last_error, ''), not_before, required_capabilities, max_pages,
+	max_parse_seconds, pages_processed, total_pages, COALESCE(stage, ''), progress_at, created_at`
+
+// Postgres is a Backend keeping the queue in the queue and dead_letter tables, shared by every API instance.
+// Concurrent claims lock the rows they lease with FOR UPDATE SKIP LOCKED, so a file is never handed out twice.
+type Postgres struct {
+	pool   *pgxpool.Pool
+	config Config
+}
+
+// conn is satisfied by both the connection pool and a transaction
+type conn interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+	Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// NewPostgres creates a backend using the tables of the given pool with the given settings
+func NewPostgres(pool *pgxpool.Pool, config Config) *Postgres {
+	return &Postgres{
+		pool:   pool,
+		config: config,
+	}
+}
+
+// db returns the transaction of the context, or the pool when there is none
+func (p *Postgres) db(ctx context.Context) conn {
+	if tx := txFromContext(ctx); tx != nil {
+		return tx
+	}
+	return p.pool
+}
+
+// inTx runs fn in a transaction, or in a savepoint of the transaction of the context
+func (p *Postgres) inTx(ctx context.Context, fn func(tx pgx.Tx) error) error {
+	tx, err := p.db(ctx).Begin(ctx)
+	if err != nil {
+		if er.HandleDeadlineExceededError(err) != nil {
+			log.Println("Deadline exceeded while starting queue transaction")
+			return err
+		}
+		log.Printf("Error starting queue transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = fn(tx)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Printf("Error committing queue transaction: %v", err)
+		return err
+	}
+	return nil
+}
+
+// Enqueue adds a file to the queue
+func (p *Postgres) Enqueue(ctx context.Context, entry models.Queue) error {
+	if err := checkEntry(entry); err != nil {
+		return err
+	}
+
+	query := `INSERT INTO queue (file_id, blob_key, file_size, user_id, priority, not_before, required_capabilities,
+		max_pages, max_parse_seconds)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+	_, err := p.db(ctx).Exec(ctx, query, entry.FileID, entry.BlobKey, entry.FileSize, entry.UserID, entry.Priority,
+		entry.NotBefore, copyStrings(entry.RequiredCapabilities), entry.MaxPages, entry.MaxParseSeconds)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return er.ErrAlreadyQueued
+		}
+		if er.HandleDeadlineExceededError(err) != nil {
+			log.Println("Deadline exceeded while adding file to queue")
+			return err
+		}
+		log.Printf("Error adding file to queue: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// Claim leases the next file the worker can handle
+func (p *Postgres) Claim(ctx context.Context, request ClaimRequest) (*models.Queue, error) {
+	var entry *models.Queue
+	err := p.inTx(ctx, func(tx pgx.Tx) error {
+		query := `
+		WITH in_flight AS (
+			SELECT user_id, COUNT(*) AS jobs FROM queue
+			WHERE lease_token IS NOT NULL AND user_id IS NOT NULL
+			GROUP BY user_id
+		)
+		SELECT q.id, q.user_id FROM queue q
+		LEFT JOIN users u ON u.id = q.user_id
+		LEFT JOIN in_flight f ON f.user_id = q.user_id
+		WHERE q.lease_token IS NULL AND (q.retry_at IS NULL OR q.retry_at <= NOW())
+			AND (q.not_before IS NULL OR q.not_before <= NOW())
+			AND (COALESCE(u.max_in_flight, $2) = 0 OR COALESCE(f.jobs, 0) < COALESCE(u.max_in_flight, $2))
+			AND q.required_capabilities <@ $3::text[]
+			AND (q.user_id IS NULL OR q.user_id <> ALL($4::int[]))
+		ORDER BY GREATEST(
+			CASE q.priority WHEN 'high' THEN 0 WHEN 'normal' THEN 1 ELSE 2 END
+			- COALESCE(FLOOR(EXTRACT(EPOCH FROM NOW() - GREATEST(q.created_at, q.not_before)) / NULLIF($1::float8, 0)), 0), 0),
+			COALESCE(f.jobs, 0), u.last_claimed_at NULLS FIRST, q.id
+		LIMIT 1 FOR UPDATE OF q SKIP LOCKED`
+
+		// The leases counted above are not locked, so a user is only known to be under their cap once locked.
+		// Users found at their cap then are left out of the next pick.
+		capped := []int{}
+		var queueId int
+		for {
+			var userId *int
+			err := tx.QueryRow(ctx, query, p.config.PriorityAgingInterval.Seconds(), p.config.UserMaxInFlight,
+				copyStrings(request.Capabilities), capped).Scan(&queueId, &userId)
+			if err != nil {
+				if errors.Is(err, pgx.ErrNoRows) {
+					return er.ErrQueueEmpty
+				}
+				if er.HandleDeadlineExceededError(err) != nil {
+					log.Println("Deadline exceeded while getting next file from queue")
+					return err
+				}
+				log.Printf("Error getting next file from queue: %v", err)
+				return err
+			}
+			if userId == nil {
+				break
+			}
+
+			atCap, err := p.lockUser(ctx, tx, *userId)
+			if err != nil {
+				return err
+			}
+			if !atCap {
+				break
+			}
+			capped = append(capped, *userId)
+		}
+
+		// The time budget of a file starts when it is claimed, and every attempt gets a full budget
+		query = `UPDATE queue SET lease_token = $1, leased_until = NOW() + make_interval(secs => $2), claimed_at = NOW(),
+		parse_deadline = CASE WHEN max_parse_seconds > 0 THEN NOW() + make_interval(secs => max_parse_seconds) END,
+		attempts = attempts + 1, worker_id = NULLIF($3, 0),
+		pages_processed = NULL, total_pages = NULL, stage = NULL, progress_at = NULL
+		WHERE id = $4 RETURNING ` + entryColumns
+		var err error
+		entry, err = scanEntry(tx.QueryRow(ctx, query, uuid.NewString(), p.config.LeaseDuration.Seconds(),
+			request.WorkerID, queueId))
+		if err != nil {
+			if er.HandleDeadlineExceededError(err) != nil {
+				log.Println("Deadline exceeded while leasing file")
+				return err
+			}
+			log.Printf("Error leasing file: %v", err)
+			return err
+		}
+
+		if entry.UserID != nil {
+			// The clock time keeps the claims of one batch in order, NOW() is fixed for the whole transaction
+			query = `UPDATE users SET last_claimed_at = CLOCK_TIMESTAMP() WHERE id = $1`
+			_, err = tx.Exec(ctx, query, *entry.UserID)
+			if err != nil {
+				if er.HandleDeadlineExceededError(err) != nil {
+					log.Println("Deadline exceeded while updating user claim time")
+					return err
+				}
+				log.Printf("Error updating user claim time: %v", err)
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+// lockUser locks the user until the transaction ends, so the claims of one user are made one after the other,
+// and reports whether the user holds as many leases as they may
+func (p *Postgres) lockUser(ctx context.Context, tx pgx.Tx, userId int) (bool, error) {
+	var maxInFlight int
+	query := `SELECT COALESCE(max_in_flight, $2) FROM users WHERE id = $1 FOR UPDATE`
+	err := tx.QueryRow(ctx, query, userId, p.config.UserMaxInFlight).Scan(&maxInFlight)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		if er.HandleDeadlineExceededError(err) != nil {
+			log.Println("Deadline exceeded while locking user")
+			return false, err
+		}
+		log.Printf("Error locking user: %v", err)
+		return false, err
+	}
+	if maxInFlight == 0 {
+		return false, nil
+	}
+
+	// Counted once the lock is held, so the leases of the claims that held it before are seen
+	var inFlight int
+	query = `SELECT COUNT(*) FROM queue WHERE user_id = $1 AND lease_token IS NOT NULL`
+	err = tx.QueryRow(ctx, query, userId).Scan(&inFlight)
+	if err != nil {
+		if er.HandleDeadlineExceededError(err) != nil {
+			log.Println("Deadline exceeded while counting leased files of user")
+			return false, err
+		}
+		log.Printf("Error counting leased files of user: %v", err)
+		return false, err
+	}
+
+	return inFlight >= maxInFlight, nil
+}
+
+// Get returns the entry of a queued file, locking it until the transaction of the context ends
+func (p *Postgres) Get(ctx context.Context, fileId int) (*models.Queue, error) {
+	query := `SELECT ` + entryColumns + ` FROM queue WHERE file_id = $1 FOR UPDATE`
+
+	entry, err := scanEntry(p.db(ctx).QueryRow(ctx, query, fileId))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, er.ErrNotQueued
+		}
+		if er.HandleDeadlineExceededError(err) != nil {
+			log.Println("Deadline exceeded while fetching queued file")
+			return nil, err
+		}
+		log.Printf("Error fetching queued file: %v", err)
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+// List returns the entries of the given files that are in the queue
+func (p *Postgres) List(ctx context.Context, fileIds []int) ([]models.Queue, error) {
+	query := `SELECT ` + entryColumns + ` FROM queue WHERE file_id = ANY($1) ORDER BY file_id`
+
+	return p.queryEntries(ctx, "queued files", query, fileIds)
+}
+
+// Leased returns every leased entry
+func (p *Postgres) Leased(ctx context.Context) ([]models.Queue, error) {
+	query := `SELECT ` + entryColumns + ` FROM queue WHERE lease_token IS NOT NULL ORDER BY file_id`
+
+	return p.queryEntries(ctx, "leased files", query)
+}
+
+// Ack removes a file whose attempt succeeded
+func (p *Postgres) Ack(ctx context.Context, fileId int, leaseToken string) (*models.Queue, error) {
+	query := `DELETE FROM queue WHERE file_id = $1 AND lease_token = $2 RETURNING ` + entryColumns
+
+	entry, err := scanEntry(p.db(ctx).QueryRow(ctx, query, fileId, leaseToken))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, er.ErrNotLeased
+		}
+		if er.HandleDeadlineExceededError(err) != nil {
+			log.Println("Deadline exceeded while deleting file from queue")
+			return nil, err
+		}
+		log.Printf("Error deleting file from queue: %v", err)
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+// Nack fails the current attempt of a file
+func (p *Postgres) Nack(ctx context.Context, fileId int, leaseToken string, reason string) (*Failure, error) {
+	var failure *Failure
+	err := p.inTx(ctx, func(tx pgx.Tx) error {
+		query := `SELECT ` + entryColumns + ` FROM queue WHERE file_id = $1 AND lease_token = $2 FOR UPDATE`
+
+		entry, err := scanEntry(tx.QueryRow(ctx, query, fileId, leaseToken))
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return er.ErrNotLeased
+			}
+			if er.HandleDeadlineExceededError(err) != nil {
+				log.Println("Deadline exceeded while locking queued file")
+				return err
+			}
+			log.Printf("Error locking queued file: %v", err)
+			return err
+		}
+
+		failure, err = p.fail(ctx, tx, *entry, reason)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return failure, nil
+}
+
+// ExtendLease moves the expiry of the lease to duration from now
+func (p *Postgres) ExtendLease(ctx context.Context, fileId int, leaseToken string, duration time.Duration) (time.Time, error) {
+	query := `UPDATE queue SET leased_until = NOW() + make_interval(secs => $3)
+	WHERE file_id = $1 AND lease_token = $2 RETURNING leased_until`
+
+	var leasedUntil time.Time
+	err := p.db(ctx).QueryRow(ctx, query, fileId, leaseToken, duration.Seconds()).Scan(&leasedUntil)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return time.Time{}, er.ErrNotLeased
+		}
+		if er.HandleDeadlineExceededError(err) != nil {
+			log.Println("Deadline exceeded while extending lease")
+			return time.Time{}, err
+		}
+		log.Printf("Error extending lease: %v", err)
+		return time.Time{}, err
+	}
+
+	return leasedUntil, nil
+}
+
+// ReportProgress records how far the holder of the lease got with the file
+func (p *Postgres) ReportProgress(ctx context.Context, fileId int, report models.ProgressReport) error {
+	query := `UPDATE queue SET pages_processed = $1, total_pages = $2, stage = NULLIF($3, ''), progress_at = NOW()
+	WHERE file_id = $4 AND lease_token = $5`
+
+	tag, err := p.db(ctx).Exec(ctx, query, report.PagesProcessed, report.TotalPages, report.Stage, fileId, report.LeaseToken)
+	if err != nil {
+		if er.HandleDeadlineExceededError(err) != nil {
+			log.Println("Deadline exceeded while recording progress")
+			return err
+		}
+		log.Printf("Error recording progress: %v", err)
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return er.ErrNotLeased
+	}
+
+	return nil
+}
+
+// Remove takes a file out of the queue whether it is leased or not
+func (p *Postgres) Remove(ctx context.Context, fileId int) (*models.Queue, error) {
+	query := `DELETE FROM queue WHERE file_id = $1 RETURNING ` + entryColumns
+
+	entry, err := scanEntry(p.db(ctx).QueryRow(ctx, query, fileId))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, er.ErrNotQueued
+		}
+		if er.HandleDeadlineExceededError(err) != nil {
+			log.Println("Deadline exceeded while deleting file from queue")
+			return nil, err
+		}
+		log.Printf("Error deleting file from queue: %v", err)
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+// ExpireLeases fails the attempts whose lease expired
+func (p *Postgres) ExpireLeases(ctx context.Context, reason string) ([]Failure, error) {
+	query := `SELECT ` + entryColumns + ` FROM queue
+	WHERE lease_token IS NOT NULL AND leased_until < NOW()
+	ORDER BY file_id
+	FOR UPDATE SKIP LOCKED`
+
+	return p.failWhere(ctx, reason, query)
+}
+
+// ReleaseWorkers fails the attempts leased to the given workers
+func (p *Postgres) ReleaseWorkers(ctx context.Context, workerIds []int, reason string) ([]Failure, error) {
+	query := `SELECT ` + entryColumns + ` FROM queue
+	WHERE lease_token IS NOT NULL AND worker_id = ANY($1)
+	ORDER BY file_id
+	FOR UPDATE SKIP LOCKED`
+
+	return p.failWhere(ctx, reason, query, workerIds)
+}
+
+// ExpireParseDeadlines removes the leased files past their parse deadline
+func (p *Postgres) ExpireParseDeadlines(ctx context.Context) ([]models.Queue, error) {
+	query := `
+	DELETE FROM queue WHERE id IN (
+		SELECT id FROM queue WHERE lease_token IS NOT NULL AND parse_deadline < NOW()
+		FOR UPDATE SKIP LOCKED
+	)
+	RETURNING ` + entryColumns
+
+	expired, err := p.queryEntries(ctx, "files over their parse deadline", query)
+	if err != nil {
+		return nil, err
+	}
+	sortByFileID(expired)
+	return expired, nil
+}
+
+// DeadLetters returns every dead-lettered file, most recently failed first
+func (p *Postgres) DeadLetters(ctx context.Context) ([]models.DeadLetter, error) {
+	query := `SELECT id, file_id, attempts, COALESCE(last_error, ''), failed_at FROM dead_letter ORDER BY failed_at DESC, id DESC`
+
+	rows, err := p.db(ctx).Query(ctx, query)
+	if err != nil {
+		if er.HandleDeadlineExceededError(err) != nil {
+			log.Println("Deadline exceeded while fetching dead letters")
+			return nil, err
+		}
+		log.Printf("Error fetching dead letters: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	deadLetters := []models.DeadLetter{}
+	for rows.Next() {
+		var deadLetter models.DeadLetter
+		err := rows.Scan(&deadLetter.ID, &deadLetter.FileID, &deadLetter.Attempts, &deadLetter.LastError, &deadLetter.FailedAt)
+		if err != nil {
+			log.Printf("Error scanning dead letters: %v", err)
+			return nil, err
+		}
+		deadLetters = append(deadLetters, deadLetter)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Printf("Error iterating over rows: %v", err)
+		return nil, err
+	}
+
+	return deadLetters, nil
+}
+
+// RequeueDeadLetter puts a dead-lettered file back in the queue with a fresh set of attempts
+func (p *Postgres) RequeueDeadLetter(ctx context.Context, fileId int) (*models.Queue, error) {
+	query := `
+	WITH requeued AS (
+		DELETE FROM dead_letter WHERE file_id = $1
+		RETURNING file_id, blob_key, file_size, user_id, priority, required_capabilities, max_pages, max_parse_seconds
+	)
+	INSERT INTO queue (file_id, blob_key, file_size, user_id, priority, required_capabilities, max_pages, max_parse_seconds)
+	SELECT file_id, blob_key, file_size, user_id, priority, required_capabilities, max_pages, max_parse_seconds FROM requeued
+	RETURNING ` + entryColumns
+
+	entry, err := scanEntry(p.db(ctx).QueryRow(ctx, query, fileId))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, er.ErrNotDeadLettered
+		}
+		if isUniqueViolation(err) {
+			return nil, er.ErrAlreadyQueued
+		}
+		if er.HandleDeadlineExceededError(err) != nil {
+			log.Println("Deadline exceeded while requeueing dead letter")
+			return nil, err
+		}
+		log.Printf("Error requeueing dead letter: %v", err)
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+// SetUserMaxInFlight overrides the concurrency cap of a user, failing with ErrUserNotFound
+func (p *Postgres) SetUserMaxInFlight(ctx context.Context, userId int, maxInFlight *int) error {
+	query := `UPDATE users SET max_in_flight = $1 WHERE id = $2`
+
+	tag, err := p.db(ctx).Exec(ctx, query, maxInFlight, userId)
+	if err != nil {
+		if er.HandleDeadlineExceededError(err) != nil {
+			log.Println("Deadline exceeded while updating user concurrency cap")
+			return err
+		}
+		log.Printf("Error updating user concurrency cap: %v", err)
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return er.ErrUserNotFound
+	}
+
+	return nil
+}
+
+// Stats returns the depth of every lane holding files
+func (p *Postgres) Stats(ctx context.Context) ([]models.LaneDepth, error) {
+	query := `
+	SELECT priority, COUNT(*) FILTER (WHERE lease_token IS NULL), COUNT(*) FILTER (WHERE lease_token IS NOT NULL),
+		COALESCE(EXTRACT(EPOCH FROM NOW() - MIN(created_at) FILTER (WHERE lease_token IS NULL)), 0)::float8
+	FROM queue
+	GROUP BY priority
+	ORDER BY CASE priority WHEN 'high' THEN 0 WHEN 'normal' THEN 1 ELSE 2 END
+	`
+
+	rows, err := p.db(ctx).Query(ctx, query)
+	if err != nil {
+		if er.HandleDeadlineExceededError(err) != nil {
+			log.Println("Deadline exceeded while fetching lane depths")
+			return nil, err
+		}
+		log.Printf("Error fetching lane depths: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	depths := []models.LaneDepth{}
+	for rows.Next() {
+		var depth models.LaneDepth
+		err := rows.Scan(&depth.Priority, &depth.Waiting, &depth.Leased, &depth.OldestAgeSeconds)
+		if err != nil {
+			log.Printf("Error scanning lane depths: %v", err)
+			return nil, err
+		}
+		depths = append(depths, depth)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Printf("Error iterating over rows: %v", err)
+		return nil, err
+	}
+
+	return depths, nil
+}
+
+// Unroutable lists the oldest waiting files that require capabilities no set of the given capabilities covers
+func (p *Postgres) Unroutable(ctx context.Context, capabilities [][]string, limit int) ([]models.UnroutableJob, int, error) {
+	// The sets can differ in length, so they are passed as a JSON array of arrays rather than a text[][]
+	if capabilities == nil {
+		capabilities = [][]string{}
+	}
+	sets, err := json.Marshal(capabilities)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query := `
+	SELECT q.file_id, q.priority, q.required_capabilities, q.created_at, COUNT(*) OVER ()
+	FROM queue q
+	WHERE q.lease_token IS NULL AND q.required_capabilities <> '{}'
+		AND NOT EXISTS (
+			SELECT 1 FROM jsonb_array_elements($1::jsonb) s
+			WHERE ARRAY(SELECT jsonb_array_elements_text(s.value)) @> q.required_capabilities
+		)
+	ORDER BY q.created_at, q.id
+	LIMIT $2
+	`
+
+	rows, err := p.db(ctx).Query(ctx, query, string(sets), limit)
+	if err != nil {
+		if er.HandleDeadlineExceededError(err) != nil {
+			log.Println("Deadline exceeded while fetching unroutable files")
+			return nil, 0, err
+		}
+		log.Printf("Error fetching unroutable files: %v", err)
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	jobs := []models.UnroutableJob{}
+	var count int
+	for rows.Next() {
+		var job models.UnroutableJob
+		err := rows.Scan(&job.FileID, &job.Priority, &job.RequiredCapabilities, &job.QueuedAt, &count)
+		if err != nil {
+			log.Printf("Error scanning unroutable files: %v", err)
+			return nil, 0, err
+		}
+		jobs = append(jobs, job)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Printf("Error iterating over rows: %v", err)
+		return nil, 0, err
+	}
+
+	return jobs, count, nil
+}
+
+// failWhere locks the leased entries selected by the query and fails their current attempt
+func (p *Postgres) failWhere(ctx context.Context, reason string, query string, args ...interface{}) ([]Failure, error) {
+	failures := []Failure{}
+	err := p.inTx(ctx, func(tx pgx.Tx) error {
+		entries, err := p.queryEntries(withPgxTx(ctx, tx), "leases to release", query, args...)
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range entries {
+			failure, err := p.fail(ctx, tx, entry, reason)
+			if err != nil {
+				return err
+			}
+			failures = append(failures, *failure)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return failures, nil
+}
+
+// fail releases the lease of a locked entry whose attempt failed, retrying it after an exponential backoff or
+// moving it to the dead-letter queue once it used up its attempts
+func (p *Postgres) fail(ctx context.Context, tx pgx.Tx, entry models.Queue, reason string) (*Failure, error) {
+	if entry.Attempts >= p.config.MaxAttempts {
+		query := `
+		WITH failed AS (
+			DELETE FROM queue WHERE file_id = $1
+			RETURNING file_id, blob_key, file_size, user_id, priority, required_capabilities, max_pages, max_parse_seconds, attempts
+		)
+		INSERT INTO dead_letter (file_id, blob_key, file_size, user_id, priority, required_capabilities, max_pages,
+			max_parse_seconds, attempts, last_error)
+		SELECT file_id, blob_key, file_size, user_id, priority, required_capabilities, max_pages, max_parse_seconds, attempts, $2
+		FROM failed
+		ON CONFLICT (file_id) DO UPDATE SET blob_key = EXCLUDED.blob_key, file_size = EXCLUDED.file_size,
+		user_id = EXCLUDED.user_id, priority = EXCLUDED.priority, required_capabilities = EXCLUDED.required_capabilities,
+		max_pages = EXCLUDED.max_pages, max_parse_seconds = EXCLUDED.max_parse_seconds, attempts = EXCLUDED.attempts,
+		last_error = EXCLUDED.last_error, failed_at = CURRENT_TIMESTAMP`
+		_, err := tx.Exec(ctx, query, entry.FileID, reason)
+		if err != nil {
+			if er.HandleDeadlineExceededError(err) != nil {
+				log.Println("Deadline exceeded while moving file to dead-letter queue")
+				return nil, err
+			}
+			log.Printf("Error moving file to dead-letter queue: %v", err)
+			return nil, err
+		}
+
+		entry.LeaseToken = ""
+		entry.LeasedUntil = nil
+		entry.ParseDeadline = nil
+		entry.WorkerID = nil
+		entry.LastError = reason
+		clearProgress(&entry)
+		return &Failure{Entry: entry, DeadLettered: true}, nil
+	}
+
+	query := `UPDATE queue SET lease_token = NULL, leased_until = NULL, parse_deadline = NULL, worker_id = NULL,
+	pages_processed = NULL, total_pages = NULL, stage = NULL, progress_at = NULL,
+	retry_at = NOW() + make_interval(secs => $1), last_error = $2
+	WHERE file_id = $3 RETURNING ` + entryColumns
+	retried, err := scanEntry(tx.QueryRow(ctx, query, p.config.RetryDelay(entry.Attempts).Seconds(), reason, entry.FileID))
+	if err != nil {
+		if er.HandleDeadlineExceededError(err) != nil {
+			log.Println("Deadline exceeded while scheduling retry")
+			return nil, err
+		}
+		log.Printf("Error scheduling retry: %v", err)
+		return nil, err
+	}
+
+	return &Failure{Entry: *retried}, nil
+}
+
+// queryEntries runs a query returning entryColumns and scans every entry, what names the entries in the logs
+func (p *Postgres) queryEntries(ctx context.Context, what string, query string, args ...interface{}) ([]models.Queue, error) {
+	rows, err := p.db(ctx).Query(ctx, query, args...)
+	if err != nil {
+		if er.HandleDeadlineExceededError(err) != nil {
+			log.Printf("Deadline exceeded while fetching %s", what)
+			return nil, err
+		}
+		log.Printf("Error fetching %s: %v", what, err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []models.Queue{}
+	for rows.Next() {
+		entry, err := scanEntry(rows)
+		if err != nil {
+			log.Printf("Error scanning %s: %v", what, err)
+			return nil, err
+		}
+		entries = append(entries, *entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Printf("Error iterating over rows: %v", err)
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// scanEntry scans a row of entryColumns
+func scanEntry(row pgx.Row) (*models.Queue, error) {
+	var entry models.Queue
+	var leaseToken *string
+	err := row.Scan(&entry.ID, &entry.FileID, &entry.BlobKey, &entry.FileSize, &entry.UserID, &entry.Priority,
+		&leaseToken, &entry.LeasedUntil, &entry.WorkerID, &entry.ClaimedAt, &entry.ParseDeadline, &entry.Attempts,
+		&entry.RetryAt, &entry.LastError, &entry.NotBefore, &entry.RequiredCapabilities, &entry.MaxPages,
+		&entry.MaxParseSeconds, &entry.PagesProcessed, &entry.TotalPages, &entry.Stage, &entry.ProgressAt,
+		&entry.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if leaseToken != nil {
+		entry.LeaseToken = *leaseToken
+	}
+	return &entry, nil
+}
+
+// isUniqueViolation reports whether the error is a unique constraint violation
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == uniqueViolation
+}
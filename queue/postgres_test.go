@@ -0,0 +1,33 @@
+package queue_test
+
+import (
+	"PDFStoring/database"
+	"PDFStoring/queue/queuetest"
+	"context"
+	"os"
+	"testing"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// TestPostgres runs against the database QUEUE_TEST_DATABASE_URL connects to, it is skipped when it is not set.
+// The queue and dead_letter tables of that database are emptied, so it must be used for nothing else.
+func TestPostgres(t *testing.T) {
+	dsn := os.Getenv("QUEUE_TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("QUEUE_TEST_DATABASE_URL is not set")
+	}
+
+	pool, err := pgxpool.Connect(context.Background(), dsn)
+	if err != nil {
+		t.Fatalf("Connecting to the database: %v", err)
+	}
+	defer pool.Close()
+
+	db := &database.PostgreSQLConnection{Pool: pool}
+	if err := db.CreateTablesIfNotExist(); err != nil {
+		t.Fatalf("Creating the tables: %v", err)
+	}
+
+	queuetest.Run(t, queuetest.Postgres(pool))
+}
@@ -0,0 +1,204 @@
+// Package queue holds the parse queue itself: the files waiting to be parsed, their leases, retries and
+// dead letters. The scheduling rules are implemented by every Backend, the service layer keeps the files,
+// their statuses and everything else around the queue.
+package queue
+
+import (
+	er "PDFStoring/error"
+	"PDFStoring/models"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Config holds the settings of a backend that decide how files are handed out and retried
+type Config struct {
+	// LeaseDuration is how long a claimed file stays leased to a worker, its visibility timeout
+	LeaseDuration time.Duration
+	// MaxAttempts is how many times a file is tried before it is moved to the dead-letter queue
+	MaxAttempts int
+	// RetryBaseDelay is the backoff after the first failed attempt, doubled for every further attempt
+	RetryBaseDelay time.Duration
+	// RetryMaxDelay caps the backoff between two attempts
+	RetryMaxDelay time.Duration
+	// UserMaxInFlight is how many files of one user can be leased at the same time, 0 for no limit. Users
+	// with their own limit set through SetUserMaxInFlight override it.
+	UserMaxInFlight int
+	// PriorityAgingInterval is how long a file waits before it is served as if it were one lane higher,
+	// so the lower lanes never starve
+	PriorityAgingInterval time.Duration
+}
+
+// RetryDelay returns the exponential backoff to wait after the given number of failed attempts
+func (c Config) RetryDelay(attempts int) time.Duration {
+	delay := c.RetryBaseDelay
+	for i := 1; i < attempts && delay < c.RetryMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > c.RetryMaxDelay {
+		delay = c.RetryMaxDelay
+	}
+	return delay
+}
+
+// ClaimRequest describes the worker claiming a file
+type ClaimRequest struct {
+	// WorkerID is the registered worker the lease is held by, or 0 for an anonymous worker
+	WorkerID int
+	// Capabilities are the capabilities of the worker, it is only handed files requiring a subset of them
+	Capabilities []string
+}
+
+// Failure is the outcome of a failed attempt, the entry is either waiting for its retry or dead-lettered
+type Failure struct {
+	Entry        models.Queue
+	DeadLettered bool
+}
+
+// Backend stores the queue and applies its scheduling rules. Higher priority lanes are served first, and a
+// file moves up one lane for every aging interval it waits. Within a lane the user with the fewest leased
+// files goes first, then the one served longest ago, and users at their concurrency cap are skipped. Files
+// scheduled for later or waiting for a retry are not handed out before they are due.
+//
+// Methods returning entries return copies, changing them does not change the queue.
+type Backend interface {
+	// Enqueue adds a file to the queue, failing with ErrAlreadyQueued when it is already in it
+	Enqueue(ctx context.Context, entry models.Queue) error
+	// Claim leases the next file the worker can handle, failing with ErrQueueEmpty when there is none
+	Claim(ctx context.Context, request ClaimRequest) (*models.Queue, error)
+	// Get returns the entry of a queued file, failing with ErrNotQueued. Within a transaction the entry is
+	// locked until the transaction ends.
+	Get(ctx context.Context, fileId int) (*models.Queue, error)
+	// List returns the entries of the given files that are in the queue
+	List(ctx context.Context, fileIds []int) ([]models.Queue, error)
+	// Leased returns every leased entry
+	Leased(ctx context.Context) ([]models.Queue, error)
+	// Ack removes a file whose attempt succeeded, failing with ErrNotLeased unless the lease token is current
+	Ack(ctx context.Context, fileId int, leaseToken string) (*models.Queue, error)
+	// Nack fails the current attempt of a file, it is retried after a backoff or dead-lettered once it used
+	// up its attempts. It fails with ErrNotLeased unless the lease token is current.
+	Nack(ctx context.Context, fileId int, leaseToken string, reason string) (*Failure, error)
+	// ExtendLease moves the expiry of the lease to duration from now and returns it
+	ExtendLease(ctx context.Context, fileId int, leaseToken string, duration time.Duration) (time.Time, error)
+	// ReportProgress records how far the holder of the lease got with the file
+	ReportProgress(ctx context.Context, fileId int, report models.ProgressReport) error
+	// Remove takes a file out of the queue whether it is leased or not, failing with ErrNotQueued
+	Remove(ctx context.Context, fileId int) (*models.Queue, error)
+	// ExpireLeases fails the attempts whose lease expired
+	ExpireLeases(ctx context.Context, reason string) ([]Failure, error)
+	// ReleaseWorkers fails the attempts leased to the given workers
+	ReleaseWorkers(ctx context.Context, workerIds []int, reason string) ([]Failure, error)
+	// ExpireParseDeadlines removes the leased files past their parse deadline, without retrying them
+	ExpireParseDeadlines(ctx context.Context) ([]models.Queue, error)
+	// DeadLetters returns every dead-lettered file, most recently failed first
+	DeadLetters(ctx context.Context) ([]models.DeadLetter, error)
+	// RequeueDeadLetter puts a dead-lettered file back in the queue with a fresh set of attempts, failing
+	// with ErrNotDeadLettered
+	RequeueDeadLetter(ctx context.Context, fileId int) (*models.Queue, error)
+	// SetUserMaxInFlight overrides the concurrency cap of a user, nil for the configured one
+	SetUserMaxInFlight(ctx context.Context, userId int, maxInFlight *int) error
+	// Stats returns the depth of every lane holding files
+	Stats(ctx context.Context) ([]models.LaneDepth, error)
+	// Unroutable lists the oldest waiting files that require capabilities no set of the given capabilities
+	// covers, up to limit, along with how many such files there are
+	Unroutable(ctx context.Context, capabilities [][]string, limit int) ([]models.UnroutableJob, int, error)
+}
+
+// laneRanks orders the priority lanes from the most to the least urgent one
+var laneRanks = map[string]int{"high": 0, "normal": 1, "bulk": 2}
+
+// checkEntry rejects entries that cannot be queued
+func checkEntry(entry models.Queue) error {
+	if _, ok := laneRanks[entry.Priority]; !ok {
+		return er.ErrInvalidPriority
+	}
+	return nil
+}
+
+// Tx is a Postgres transaction the backend calls made with WithTx take part in. The in-memory backend applies
+// its changes right away, and puts back what the transaction changed when it rolls back or fails to commit.
+type Tx struct {
+	pgx.Tx
+
+	mu    sync.Mutex
+	ended bool
+	// hooks are run once the transaction ends, told whether it committed
+	hooks []func(committed bool)
+}
+
+// Begin starts a transaction on the pool that the backend calls can take part in
+func Begin(ctx context.Context, pool *pgxpool.Pool) (*Tx, error) {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{Tx: tx}, nil
+}
+
+// Commit commits the transaction, the backend changes made in it are undone when it fails
+func (tx *Tx) Commit(ctx context.Context) error {
+	err := tx.Tx.Commit(ctx)
+	tx.end(err == nil)
+	return err
+}
+
+// Rollback rolls the transaction back along with the backend changes made in it, it does nothing once the
+// transaction ended
+func (tx *Tx) Rollback(ctx context.Context) error {
+	err := tx.Tx.Rollback(ctx)
+	tx.end(false)
+	return err
+}
+
+// onEnd registers a function to run once the transaction ends
+func (tx *Tx) onEnd(hook func(committed bool)) {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+
+	tx.hooks = append(tx.hooks, hook)
+}
+
+// end runs the hooks the first time the transaction ends
+func (tx *Tx) end(committed bool) {
+	tx.mu.Lock()
+	if tx.ended {
+		tx.mu.Unlock()
+		return
+	}
+	tx.ended = true
+	hooks := tx.hooks
+	tx.hooks = nil
+	tx.mu.Unlock()
+
+	for _, hook := range hooks {
+		hook(committed)
+	}
+}
+
+type txKey struct{}
+
+// WithTx returns a context running the backend calls made with it in the given transaction, so they commit or
+// roll back together with the caller's own changes
+func WithTx(ctx context.Context, tx *Tx) context.Context {
+	return context.WithValue(ctx, txKey{}, tx)
+}
+
+// withPgxTx returns a context running the Postgres backend calls made with it in a transaction of its own
+func withPgxTx(ctx context.Context, tx pgx.Tx) context.Context {
+	return context.WithValue(ctx, txKey{}, tx)
+}
+
+// txFromContext returns the transaction the Postgres backend calls run in, or nil
+func txFromContext(ctx context.Context) pgx.Tx {
+	tx, _ := ctx.Value(txKey{}).(pgx.Tx)
+	return tx
+}
+
+// queueTxFromContext returns the transaction set with WithTx, or nil
+func queueTxFromContext(ctx context.Context) *Tx {
+	tx, _ := ctx.Value(txKey{}).(*Tx)
+	return tx
+}
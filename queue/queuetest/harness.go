@@ -0,0 +1,96 @@
+package queuetest
+
+import (
+	"PDFStoring/queue"
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Memory returns a harness checking the in-memory backend, which needs no files, users or workers to exist
+func Memory() Harness {
+	var mu sync.Mutex
+	var lastId int
+	nextId := func(t *testing.T) int {
+		mu.Lock()
+		defer mu.Unlock()
+		lastId++
+		return lastId
+	}
+
+	return Harness{
+		NewBackend: func(t *testing.T, config queue.Config) queue.Backend {
+			return queue.NewMemory(config)
+		},
+		NewFile:   nextId,
+		NewUser:   nextId,
+		NewWorker: nextId,
+		Begin: func(t *testing.T) *queue.Tx {
+			return &queue.Tx{Tx: memoryTx{}}
+		},
+	}
+}
+
+// memoryTx is a transaction with nothing in it, the in-memory backend only needs to know how it ends
+type memoryTx struct {
+	pgx.Tx
+}
+
+func (memoryTx) Commit(ctx context.Context) error {
+	return nil
+}
+
+func (memoryTx) Rollback(ctx context.Context) error {
+	return nil
+}
+
+// Postgres returns a harness checking the Postgres backend on the tables of the pool, created beforehand with
+// database.CreateTablesIfNotExist. Every backend starts by emptying the queue and dead_letter tables, so the
+// pool must connect to a database used for nothing else. The files, users and workers it creates are deleted
+// when the check ends.
+func Postgres(pool *pgxpool.Pool) Harness {
+	insert := func(t *testing.T, table string, query string, args ...interface{}) int {
+		t.Helper()
+		var id int
+		if err := pool.QueryRow(context.Background(), query, args...).Scan(&id); err != nil {
+			t.Fatalf("Creating a row in %s: %v", table, err)
+		}
+		t.Cleanup(func() {
+			pool.Exec(context.Background(), "DELETE FROM "+table+" WHERE id = $1", id)
+		})
+		return id
+	}
+
+	return Harness{
+		NewBackend: func(t *testing.T, config queue.Config) queue.Backend {
+			if _, err := pool.Exec(context.Background(), "TRUNCATE queue, dead_letter"); err != nil {
+				t.Fatalf("Emptying the queue: %v", err)
+			}
+			return queue.NewPostgres(pool, config)
+		},
+		NewFile: func(t *testing.T) int {
+			hash := uuid.NewString()
+			return insert(t, "files", `INSERT INTO files (filename, file_hash, blob_key) VALUES ('queuetest.pdf', $1, $1) RETURNING id`, hash)
+		},
+		NewUser: func(t *testing.T) int {
+			return insert(t, "users", `INSERT INTO users DEFAULT VALUES RETURNING id`)
+		},
+		NewWorker: func(t *testing.T) int {
+			return insert(t, "workers", `INSERT INTO workers (name) VALUES ('queuetest') RETURNING id`)
+		},
+		Begin: func(t *testing.T) *queue.Tx {
+			tx, err := queue.Begin(context.Background(), pool)
+			if err != nil {
+				t.Fatalf("Starting a transaction: %v", err)
+			}
+			t.Cleanup(func() {
+				tx.Rollback(context.Background())
+			})
+			return tx
+		},
+	}
+}
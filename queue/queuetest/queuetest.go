@@ -0,0 +1,636 @@
+// Package queuetest checks implementations of queue.Backend, in the way testing/fstest checks file systems
+package queuetest
+
+import (
+	er "PDFStoring/error"
+	"PDFStoring/models"
+	"PDFStoring/queue"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Harness provides the backends Run checks, and the files, users and workers their entries refer to
+type Harness struct {
+	// NewBackend returns an empty backend with the given settings
+	NewBackend func(t *testing.T, config queue.Config) queue.Backend
+	// NewFile returns the id of a file that can be queued
+	NewFile func(t *testing.T) int
+	// NewUser returns the id of a user files can be queued for
+	NewUser func(t *testing.T) int
+	// NewWorker returns the id of a worker files can be leased to
+	NewWorker func(t *testing.T) int
+	// Begin starts a transaction the backend calls can take part in
+	Begin func(t *testing.T) *queue.Tx
+}
+
+// baseConfig returns settings under which the checks only wait for what they set shorter themselves
+func baseConfig() queue.Config {
+	return queue.Config{
+		LeaseDuration:         time.Minute,
+		MaxAttempts:           3,
+		RetryBaseDelay:        time.Minute,
+		RetryMaxDelay:         time.Hour,
+		PriorityAgingInterval: time.Hour,
+	}
+}
+
+// Run checks that the backends of the harness behave like a queue.Backend. The checks sleep for fractions
+// of a second to let leases, backoffs and deadlines run out.
+func Run(t *testing.T, h Harness) {
+	t.Run("ClaimAck", func(t *testing.T) {
+		b := h.NewBackend(t, baseConfig())
+		fileId := h.NewFile(t)
+		enqueue(t, b, models.Queue{FileID: fileId, BlobKey: "blob", FileSize: 42, Priority: "normal"})
+
+		entry := get(t, b, fileId)
+		if entry.LeaseToken != "" || entry.Attempts != 0 {
+			t.Fatalf("queued file has lease %q and %d attempts, want none", entry.LeaseToken, entry.Attempts)
+		}
+
+		workerId := h.NewWorker(t)
+		leased := claim(t, b, queue.ClaimRequest{WorkerID: workerId})
+		if leased.FileID != fileId || leased.BlobKey != "blob" || leased.FileSize != 42 {
+			t.Fatalf("Claim returned file %d with blob %q of %d bytes, want file %d with blob %q of 42 bytes",
+				leased.FileID, leased.BlobKey, leased.FileSize, fileId, "blob")
+		}
+		if leased.LeaseToken == "" || leased.LeasedUntil == nil || leased.ClaimedAt == nil {
+			t.Fatalf("Claim returned no lease")
+		}
+		if leased.Attempts != 1 || leased.WorkerID == nil || *leased.WorkerID != workerId {
+			t.Fatalf("Claim returned attempt %d leased to %v, want attempt 1 leased to worker %d",
+				leased.Attempts, leased.WorkerID, workerId)
+		}
+		expectEmpty(t, b, queue.ClaimRequest{})
+
+		if _, err := b.Ack(context.Background(), fileId, "stale"); !errors.Is(err, er.ErrNotLeased) {
+			t.Errorf("Ack with a stale token = %v, want ErrNotLeased", err)
+		}
+		acked, err := b.Ack(context.Background(), fileId, leased.LeaseToken)
+		if err != nil {
+			t.Fatalf("Ack: %v", err)
+		}
+		if acked.FileID != fileId {
+			t.Errorf("Ack returned file %d, want %d", acked.FileID, fileId)
+		}
+		if _, err := b.Get(context.Background(), fileId); !errors.Is(err, er.ErrNotQueued) {
+			t.Errorf("Get after Ack = %v, want ErrNotQueued", err)
+		}
+	})
+
+	t.Run("Enqueue", func(t *testing.T) {
+		b := h.NewBackend(t, baseConfig())
+		fileId := h.NewFile(t)
+		enqueue(t, b, models.Queue{FileID: fileId, BlobKey: "blob", Priority: "normal"})
+
+		err := b.Enqueue(context.Background(), models.Queue{FileID: fileId, BlobKey: "blob", Priority: "normal"})
+		if !errors.Is(err, er.ErrAlreadyQueued) {
+			t.Errorf("Enqueue of a queued file = %v, want ErrAlreadyQueued", err)
+		}
+		err = b.Enqueue(context.Background(), models.Queue{FileID: h.NewFile(t), BlobKey: "blob", Priority: "urgent"})
+		if !errors.Is(err, er.ErrInvalidPriority) {
+			t.Errorf("Enqueue with an unknown priority = %v, want ErrInvalidPriority", err)
+		}
+	})
+
+	t.Run("Priority", func(t *testing.T) {
+		b := h.NewBackend(t, baseConfig())
+		var want []int
+		for _, priority := range []string{"bulk", "normal", "high"} {
+			fileId := h.NewFile(t)
+			enqueue(t, b, models.Queue{FileID: fileId, BlobKey: "blob", Priority: priority})
+			want = append([]int{fileId}, want...)
+		}
+
+		for _, fileId := range want {
+			if got := claim(t, b, queue.ClaimRequest{}); got.FileID != fileId {
+				t.Errorf("Claim returned file %d of lane %s, want file %d", got.FileID, got.Priority, fileId)
+			}
+		}
+	})
+
+	t.Run("Aging", func(t *testing.T) {
+		config := baseConfig()
+		config.PriorityAgingInterval = 100 * time.Millisecond
+		b := h.NewBackend(t, config)
+
+		bulk := h.NewFile(t)
+		enqueue(t, b, models.Queue{FileID: bulk, BlobKey: "blob", Priority: "bulk"})
+		time.Sleep(250 * time.Millisecond)
+		high := h.NewFile(t)
+		enqueue(t, b, models.Queue{FileID: high, BlobKey: "blob", Priority: "high"})
+
+		if got := claim(t, b, queue.ClaimRequest{}); got.FileID != bulk {
+			t.Errorf("Claim returned file %d, want the bulk file %d aged up to the high lane", got.FileID, bulk)
+		}
+	})
+
+	t.Run("NotBefore", func(t *testing.T) {
+		b := h.NewBackend(t, baseConfig())
+		// A day apart, so a database clock in another time zone does not matter
+		later := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+		earlier := time.Now().Add(-24 * time.Hour).UTC().Truncate(time.Second)
+
+		scheduled := h.NewFile(t)
+		enqueue(t, b, models.Queue{FileID: scheduled, BlobKey: "blob", Priority: "high", NotBefore: &later})
+		if entry := get(t, b, scheduled); entry.NotBefore == nil {
+			t.Errorf("Get returned no planned time for a scheduled file")
+		}
+		expectEmpty(t, b, queue.ClaimRequest{})
+
+		due := h.NewFile(t)
+		enqueue(t, b, models.Queue{FileID: due, BlobKey: "blob", Priority: "bulk", NotBefore: &earlier})
+		if got := claim(t, b, queue.ClaimRequest{}); got.FileID != due {
+			t.Errorf("Claim returned file %d, want the due file %d", got.FileID, due)
+		}
+	})
+
+	t.Run("Fairness", func(t *testing.T) {
+		b := h.NewBackend(t, baseConfig())
+		first, second := h.NewUser(t), h.NewUser(t)
+		firstFiles := []int{h.NewFile(t), h.NewFile(t)}
+		for _, fileId := range firstFiles {
+			enqueue(t, b, models.Queue{FileID: fileId, BlobKey: "blob", Priority: "normal", UserID: &first})
+		}
+		secondFile := h.NewFile(t)
+		enqueue(t, b, models.Queue{FileID: secondFile, BlobKey: "blob", Priority: "normal", UserID: &second})
+
+		for _, fileId := range []int{firstFiles[0], secondFile, firstFiles[1]} {
+			if got := claim(t, b, queue.ClaimRequest{}); got.FileID != fileId {
+				t.Errorf("Claim returned file %d, want file %d", got.FileID, fileId)
+			}
+		}
+	})
+
+	t.Run("UserMaxInFlight", func(t *testing.T) {
+		b := h.NewBackend(t, baseConfig())
+		userId := h.NewUser(t)
+		one := 1
+		if err := b.SetUserMaxInFlight(context.Background(), userId, &one); err != nil {
+			t.Fatalf("SetUserMaxInFlight: %v", err)
+		}
+		for i := 0; i < 2; i++ {
+			enqueue(t, b, models.Queue{FileID: h.NewFile(t), BlobKey: "blob", Priority: "normal", UserID: &userId})
+		}
+
+		claim(t, b, queue.ClaimRequest{})
+		expectEmpty(t, b, queue.ClaimRequest{})
+
+		if err := b.SetUserMaxInFlight(context.Background(), userId, nil); err != nil {
+			t.Fatalf("SetUserMaxInFlight: %v", err)
+		}
+		claim(t, b, queue.ClaimRequest{})
+	})
+
+	t.Run("ConcurrentClaims", func(t *testing.T) {
+		config := baseConfig()
+		config.UserMaxInFlight = 1
+		b := h.NewBackend(t, config)
+		userId := h.NewUser(t)
+		for i := 0; i < 3; i++ {
+			enqueue(t, b, models.Queue{FileID: h.NewFile(t), BlobKey: "blob", Priority: "normal", UserID: &userId})
+		}
+
+		// Claims racing each other still respect the cap of the user
+		var wg sync.WaitGroup
+		errs := make(chan error, 8)
+		for i := 0; i < cap(errs); i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := b.Claim(context.Background(), queue.ClaimRequest{})
+				errs <- err
+			}()
+		}
+		wg.Wait()
+		close(errs)
+
+		claimed := 0
+		for err := range errs {
+			switch {
+			case err == nil:
+				claimed++
+			case !errors.Is(err, er.ErrQueueEmpty):
+				t.Errorf("Claim: %v", err)
+			}
+		}
+		if claimed != 1 {
+			t.Errorf("%d concurrent claims succeeded for a user limited to 1 leased file, want 1", claimed)
+		}
+	})
+
+	t.Run("Capabilities", func(t *testing.T) {
+		b := h.NewBackend(t, baseConfig())
+		fileId := h.NewFile(t)
+		enqueue(t, b, models.Queue{FileID: fileId, BlobKey: "blob", Priority: "normal", RequiredCapabilities: []string{"ocr"}})
+
+		expectEmpty(t, b, queue.ClaimRequest{})
+		expectEmpty(t, b, queue.ClaimRequest{Capabilities: []string{"tables"}})
+
+		got := claim(t, b, queue.ClaimRequest{Capabilities: []string{"ocr", "tables"}})
+		if len(got.RequiredCapabilities) != 1 || got.RequiredCapabilities[0] != "ocr" {
+			t.Errorf("Claim returned required capabilities %v, want [ocr]", got.RequiredCapabilities)
+		}
+	})
+
+	t.Run("RetryAndDeadLetter", func(t *testing.T) {
+		config := baseConfig()
+		config.MaxAttempts = 2
+		config.RetryBaseDelay = 200 * time.Millisecond
+		b := h.NewBackend(t, config)
+		fileId := h.NewFile(t)
+		enqueue(t, b, models.Queue{FileID: fileId, BlobKey: "blob", Priority: "normal"})
+
+		leased := claim(t, b, queue.ClaimRequest{})
+		failure, err := b.Nack(context.Background(), fileId, leased.LeaseToken, "first failure")
+		if err != nil {
+			t.Fatalf("Nack: %v", err)
+		}
+		if failure.DeadLettered || failure.Entry.RetryAt == nil || failure.Entry.LastError != "first failure" {
+			t.Fatalf("Nack of the first attempt returned %+v, want a retry after a backoff", failure)
+		}
+		if failure.Entry.LeaseToken != "" {
+			t.Errorf("Nack left the lease in place")
+		}
+		expectEmpty(t, b, queue.ClaimRequest{})
+
+		time.Sleep(300 * time.Millisecond)
+		leased = claim(t, b, queue.ClaimRequest{})
+		if leased.Attempts != 2 {
+			t.Errorf("Claim after the backoff returned attempt %d, want 2", leased.Attempts)
+		}
+
+		failure, err = b.Nack(context.Background(), fileId, leased.LeaseToken, "second failure")
+		if err != nil {
+			t.Fatalf("Nack: %v", err)
+		}
+		if !failure.DeadLettered {
+			t.Fatalf("Nack of the last attempt did not dead-letter the file")
+		}
+		if _, err := b.Get(context.Background(), fileId); !errors.Is(err, er.ErrNotQueued) {
+			t.Errorf("Get of a dead-lettered file = %v, want ErrNotQueued", err)
+		}
+
+		deadLetters, err := b.DeadLetters(context.Background())
+		if err != nil {
+			t.Fatalf("DeadLetters: %v", err)
+		}
+		if len(deadLetters) != 1 || deadLetters[0].FileID != fileId || deadLetters[0].Attempts != 2 ||
+			deadLetters[0].LastError != "second failure" {
+			t.Fatalf("DeadLetters = %+v, want file %d after 2 attempts with the last error", deadLetters, fileId)
+		}
+
+		requeued, err := b.RequeueDeadLetter(context.Background(), fileId)
+		if err != nil {
+			t.Fatalf("RequeueDeadLetter: %v", err)
+		}
+		if requeued.Attempts != 0 || requeued.RetryAt != nil {
+			t.Errorf("RequeueDeadLetter returned %d attempts and retry time %v, want a fresh entry",
+				requeued.Attempts, requeued.RetryAt)
+		}
+		if _, err := b.RequeueDeadLetter(context.Background(), fileId); !errors.Is(err, er.ErrNotDeadLettered) {
+			t.Errorf("RequeueDeadLetter of a queued file = %v, want ErrNotDeadLettered", err)
+		}
+		claim(t, b, queue.ClaimRequest{})
+	})
+
+	t.Run("StaleLease", func(t *testing.T) {
+		b := h.NewBackend(t, baseConfig())
+		fileId := h.NewFile(t)
+		enqueue(t, b, models.Queue{FileID: fileId, BlobKey: "blob", Priority: "normal"})
+		claim(t, b, queue.ClaimRequest{})
+
+		ctx := context.Background()
+		if _, err := b.Nack(ctx, fileId, "stale", "failure"); !errors.Is(err, er.ErrNotLeased) {
+			t.Errorf("Nack with a stale token = %v, want ErrNotLeased", err)
+		}
+		if _, err := b.ExtendLease(ctx, fileId, "stale", time.Minute); !errors.Is(err, er.ErrNotLeased) {
+			t.Errorf("ExtendLease with a stale token = %v, want ErrNotLeased", err)
+		}
+		report := models.ProgressReport{LeaseToken: "stale", PagesProcessed: 1, TotalPages: 2}
+		if err := b.ReportProgress(ctx, fileId, report); !errors.Is(err, er.ErrNotLeased) {
+			t.Errorf("ReportProgress with a stale token = %v, want ErrNotLeased", err)
+		}
+	})
+
+	t.Run("ExpireLeases", func(t *testing.T) {
+		config := baseConfig()
+		config.LeaseDuration = 100 * time.Millisecond
+		b := h.NewBackend(t, config)
+		expiring, extended := h.NewFile(t), h.NewFile(t)
+		enqueue(t, b, models.Queue{FileID: expiring, BlobKey: "blob", Priority: "high"})
+		enqueue(t, b, models.Queue{FileID: extended, BlobKey: "blob", Priority: "normal"})
+		claim(t, b, queue.ClaimRequest{})
+		leased := claim(t, b, queue.ClaimRequest{})
+
+		leasedUntil, err := b.ExtendLease(context.Background(), extended, leased.LeaseToken, time.Minute)
+		if err != nil {
+			t.Fatalf("ExtendLease: %v", err)
+		}
+		if !leasedUntil.After(*leased.LeasedUntil) {
+			t.Errorf("ExtendLease moved the expiry from %v to %v", *leased.LeasedUntil, leasedUntil)
+		}
+
+		time.Sleep(200 * time.Millisecond)
+		failures, err := b.ExpireLeases(context.Background(), "Lease expired")
+		if err != nil {
+			t.Fatalf("ExpireLeases: %v", err)
+		}
+		if len(failures) != 1 || failures[0].Entry.FileID != expiring || failures[0].Entry.LastError != "Lease expired" {
+			t.Fatalf("ExpireLeases = %+v, want the failure of file %d", failures, expiring)
+		}
+		if entry := get(t, b, expiring); entry.LeaseToken != "" {
+			t.Errorf("file %d is still leased after its lease expired", expiring)
+		}
+		if entry := get(t, b, extended); entry.LeaseToken != leased.LeaseToken {
+			t.Errorf("file %d lost its extended lease", extended)
+		}
+	})
+
+	t.Run("ReleaseWorkers", func(t *testing.T) {
+		b := h.NewBackend(t, baseConfig())
+		dead, alive := h.NewWorker(t), h.NewWorker(t)
+		for i := 0; i < 2; i++ {
+			enqueue(t, b, models.Queue{FileID: h.NewFile(t), BlobKey: "blob", Priority: "normal"})
+		}
+		released := claim(t, b, queue.ClaimRequest{WorkerID: dead})
+		kept := claim(t, b, queue.ClaimRequest{WorkerID: alive})
+
+		failures, err := b.ReleaseWorkers(context.Background(), []int{dead}, "Worker stopped sending heartbeats")
+		if err != nil {
+			t.Fatalf("ReleaseWorkers: %v", err)
+		}
+		if len(failures) != 1 || failures[0].Entry.FileID != released.FileID {
+			t.Fatalf("ReleaseWorkers = %+v, want the failure of file %d", failures, released.FileID)
+		}
+
+		leased, err := b.Leased(context.Background())
+		if err != nil {
+			t.Fatalf("Leased: %v", err)
+		}
+		if len(leased) != 1 || leased[0].FileID != kept.FileID {
+			t.Errorf("Leased = %+v, want only file %d", leased, kept.FileID)
+		}
+	})
+
+	t.Run("ParseDeadline", func(t *testing.T) {
+		b := h.NewBackend(t, baseConfig())
+		limited, unlimited := h.NewFile(t), h.NewFile(t)
+		enqueue(t, b, models.Queue{FileID: limited, BlobKey: "blob", Priority: "high", MaxParseSeconds: 0.1, MaxPages: 5})
+		enqueue(t, b, models.Queue{FileID: unlimited, BlobKey: "blob", Priority: "normal"})
+
+		leased := claim(t, b, queue.ClaimRequest{})
+		if leased.ParseDeadline == nil || leased.MaxPages != 5 {
+			t.Fatalf("Claim returned deadline %v and %d pages, want a deadline and 5 pages", leased.ParseDeadline, leased.MaxPages)
+		}
+		if other := claim(t, b, queue.ClaimRequest{}); other.ParseDeadline != nil {
+			t.Errorf("Claim of a file without a time limit returned deadline %v", *other.ParseDeadline)
+		}
+
+		time.Sleep(200 * time.Millisecond)
+		expired, err := b.ExpireParseDeadlines(context.Background())
+		if err != nil {
+			t.Fatalf("ExpireParseDeadlines: %v", err)
+		}
+		if len(expired) != 1 || expired[0].FileID != limited || expired[0].LeaseToken != leased.LeaseToken {
+			t.Fatalf("ExpireParseDeadlines = %+v, want file %d with its lease", expired, limited)
+		}
+		if _, err := b.Get(context.Background(), limited); !errors.Is(err, er.ErrNotQueued) {
+			t.Errorf("Get of an expired file = %v, want ErrNotQueued", err)
+		}
+	})
+
+	t.Run("Progress", func(t *testing.T) {
+		b := h.NewBackend(t, baseConfig())
+		fileId := h.NewFile(t)
+		enqueue(t, b, models.Queue{FileID: fileId, BlobKey: "blob", Priority: "normal"})
+		leased := claim(t, b, queue.ClaimRequest{})
+
+		report := models.ProgressReport{LeaseToken: leased.LeaseToken, PagesProcessed: 3, TotalPages: 10, Stage: "text"}
+		if err := b.ReportProgress(context.Background(), fileId, report); err != nil {
+			t.Fatalf("ReportProgress: %v", err)
+		}
+		entry := get(t, b, fileId)
+		if entry.PagesProcessed == nil || *entry.PagesProcessed != 3 || entry.TotalPages == nil || *entry.TotalPages != 10 ||
+			entry.Stage != "text" || entry.ProgressAt == nil {
+			t.Fatalf("Get after ReportProgress returned %+v, want 3 of 10 pages in stage text", entry)
+		}
+
+		if _, err := b.Nack(context.Background(), fileId, leased.LeaseToken, "failure"); err != nil {
+			t.Fatalf("Nack: %v", err)
+		}
+		if entry := get(t, b, fileId); entry.PagesProcessed != nil || entry.Stage != "" {
+			t.Errorf("the progress of the failed attempt was kept")
+		}
+	})
+
+	t.Run("RemoveAndList", func(t *testing.T) {
+		b := h.NewBackend(t, baseConfig())
+		waiting, leased, missing := h.NewFile(t), h.NewFile(t), h.NewFile(t)
+		enqueue(t, b, models.Queue{FileID: leased, BlobKey: "blob", Priority: "high"})
+		enqueue(t, b, models.Queue{FileID: waiting, BlobKey: "blob", Priority: "normal"})
+		claim(t, b, queue.ClaimRequest{})
+
+		entries, err := b.List(context.Background(), []int{missing, leased, waiting})
+		if err != nil {
+			t.Fatalf("List: %v", err)
+		}
+		if len(entries) != 2 || entries[0].FileID != waiting || entries[1].FileID != leased {
+			t.Fatalf("List = %+v, want files %d and %d", entries, waiting, leased)
+		}
+
+		removed, err := b.Remove(context.Background(), leased)
+		if err != nil {
+			t.Fatalf("Remove: %v", err)
+		}
+		if removed.LeaseToken == "" {
+			t.Errorf("Remove of a leased file returned no lease")
+		}
+		if _, err := b.Remove(context.Background(), leased); !errors.Is(err, er.ErrNotQueued) {
+			t.Errorf("Remove of a removed file = %v, want ErrNotQueued", err)
+		}
+	})
+
+	t.Run("Stats", func(t *testing.T) {
+		b := h.NewBackend(t, baseConfig())
+		for _, priority := range []string{"high", "high", "bulk"} {
+			enqueue(t, b, models.Queue{FileID: h.NewFile(t), BlobKey: "blob", Priority: priority})
+		}
+		claim(t, b, queue.ClaimRequest{})
+
+		depths, err := b.Stats(context.Background())
+		if err != nil {
+			t.Fatalf("Stats: %v", err)
+		}
+		want := []models.LaneDepth{{Priority: "high", Waiting: 1, Leased: 1}, {Priority: "bulk", Waiting: 1}}
+		if len(depths) != len(want) {
+			t.Fatalf("Stats = %+v, want %+v", depths, want)
+		}
+		for i := range want {
+			got := depths[i]
+			if got.Priority != want[i].Priority || got.Waiting != want[i].Waiting || got.Leased != want[i].Leased {
+				t.Errorf("Stats lane %d = %+v, want %+v", i, got, want[i])
+			}
+			if got.OldestAgeSeconds < 0 {
+				t.Errorf("Stats lane %s has oldest age %v", got.Priority, got.OldestAgeSeconds)
+			}
+		}
+	})
+
+	t.Run("Unroutable", func(t *testing.T) {
+		b := h.NewBackend(t, baseConfig())
+		ocr, ocrTables := h.NewFile(t), h.NewFile(t)
+		enqueue(t, b, models.Queue{FileID: ocr, BlobKey: "blob", Priority: "normal", RequiredCapabilities: []string{"ocr"}})
+		enqueue(t, b, models.Queue{FileID: ocrTables, BlobKey: "blob", Priority: "normal",
+			RequiredCapabilities: []string{"ocr", "tables"}})
+		enqueue(t, b, models.Queue{FileID: h.NewFile(t), BlobKey: "blob", Priority: "normal"})
+
+		jobs, count, err := b.Unroutable(context.Background(), [][]string{{"ocr"}, {"tables"}}, 10)
+		if err != nil {
+			t.Fatalf("Unroutable: %v", err)
+		}
+		if count != 1 || len(jobs) != 1 || jobs[0].FileID != ocrTables {
+			t.Errorf("Unroutable = %+v and %d, want file %d", jobs, count, ocrTables)
+		}
+
+		jobs, count, err = b.Unroutable(context.Background(), nil, 1)
+		if err != nil {
+			t.Fatalf("Unroutable: %v", err)
+		}
+		if count != 2 || len(jobs) != 1 || jobs[0].FileID != ocr {
+			t.Errorf("Unroutable without workers = %+v and %d, want the oldest file %d of 2", jobs, count, ocr)
+		}
+	})
+
+	t.Run("Transactions", func(t *testing.T) {
+		b := h.NewBackend(t, baseConfig())
+		fileId := h.NewFile(t)
+		enqueue(t, b, models.Queue{FileID: fileId, Priority: "normal"})
+
+		tx := h.Begin(t)
+		if _, err := b.Claim(queue.WithTx(context.Background(), tx), queue.ClaimRequest{}); err != nil {
+			t.Fatalf("Claim in a transaction: %v", err)
+		}
+		if err := tx.Rollback(context.Background()); err != nil {
+			t.Fatalf("Rollback: %v", err)
+		}
+		if entry := get(t, b, fileId); entry.LeaseToken != "" || entry.Attempts != 0 {
+			t.Errorf("file claimed in a rolled back transaction has lease %q and %d attempts, want none",
+				entry.LeaseToken, entry.Attempts)
+		}
+
+		tx = h.Begin(t)
+		if _, err := b.Remove(queue.WithTx(context.Background(), tx), fileId); err != nil {
+			t.Fatalf("Remove in a transaction: %v", err)
+		}
+		if err := tx.Rollback(context.Background()); err != nil {
+			t.Fatalf("Rollback: %v", err)
+		}
+		get(t, b, fileId)
+
+		rolledBack, committed := h.NewFile(t), h.NewFile(t)
+		tx = h.Begin(t)
+		if err := b.Enqueue(queue.WithTx(context.Background(), tx), models.Queue{FileID: rolledBack, Priority: "normal"}); err != nil {
+			t.Fatalf("Enqueue in a transaction: %v", err)
+		}
+		if err := tx.Rollback(context.Background()); err != nil {
+			t.Fatalf("Rollback: %v", err)
+		}
+		if _, err := b.Get(context.Background(), rolledBack); !errors.Is(err, er.ErrNotQueued) {
+			t.Errorf("Get of a file queued in a rolled back transaction = %v, want ErrNotQueued", err)
+		}
+
+		tx = h.Begin(t)
+		if err := b.Enqueue(queue.WithTx(context.Background(), tx), models.Queue{FileID: committed, Priority: "normal"}); err != nil {
+			t.Fatalf("Enqueue in a transaction: %v", err)
+		}
+		if err := tx.Commit(context.Background()); err != nil {
+			t.Fatalf("Commit: %v", err)
+		}
+		// Rolling back once committed changes nothing
+		tx.Rollback(context.Background())
+		get(t, b, committed)
+	})
+
+	t.Run("Locks", func(t *testing.T) {
+		b := h.NewBackend(t, baseConfig())
+		fileId := h.NewFile(t)
+		enqueue(t, b, models.Queue{FileID: fileId, Priority: "normal"})
+
+		tx := h.Begin(t)
+		if _, err := b.Get(queue.WithTx(context.Background(), tx), fileId); err != nil {
+			t.Fatalf("Get in a transaction: %v", err)
+		}
+		other := h.Begin(t)
+		ctx, cancel := context.WithTimeout(queue.WithTx(context.Background(), other), 100*time.Millisecond)
+		defer cancel()
+		if _, err := b.Get(ctx, fileId); err == nil {
+			t.Errorf("Get of a file locked by another transaction returned without waiting for it")
+		}
+		other.Rollback(context.Background())
+		if err := tx.Commit(context.Background()); err != nil {
+			t.Fatalf("Commit: %v", err)
+		}
+
+		// A change waits for the transaction holding the file, and sees what its rollback put back
+		tx = h.Begin(t)
+		leased, err := b.Claim(queue.WithTx(context.Background(), tx), queue.ClaimRequest{})
+		if err != nil {
+			t.Fatalf("Claim in a transaction: %v", err)
+		}
+		done := make(chan error, 1)
+		go func() {
+			_, err := b.ExtendLease(context.Background(), fileId, leased.LeaseToken, time.Minute)
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			t.Fatalf("ExtendLease of a file locked by another transaction = %v without waiting for it", err)
+		case <-time.After(100 * time.Millisecond):
+		}
+		if err := tx.Rollback(context.Background()); err != nil {
+			t.Fatalf("Rollback: %v", err)
+		}
+		if err := <-done; !errors.Is(err, er.ErrNotLeased) {
+			t.Errorf("ExtendLease of a lease rolled back = %v, want ErrNotLeased", err)
+		}
+		if entry := get(t, b, fileId); entry.LeaseToken != "" || entry.Attempts != 0 {
+			t.Errorf("file claimed in a rolled back transaction has lease %q and %d attempts, want none",
+				entry.LeaseToken, entry.Attempts)
+		}
+	})
+}
+
+func enqueue(t *testing.T, b queue.Backend, entry models.Queue) {
+	t.Helper()
+	if err := b.Enqueue(context.Background(), entry); err != nil {
+		t.Fatalf("Enqueue of file %d: %v", entry.FileID, err)
+	}
+}
+
+func claim(t *testing.T, b queue.Backend, request queue.ClaimRequest) *models.Queue {
+	t.Helper()
+	entry, err := b.Claim(context.Background(), request)
+	if err != nil {
+		t.Fatalf("Claim: %v", err)
+	}
+	return entry
+}
+
+func expectEmpty(t *testing.T, b queue.Backend, request queue.ClaimRequest) {
+	t.Helper()
+	if entry, err := b.Claim(context.Background(), request); !errors.Is(err, er.ErrQueueEmpty) {
+		t.Fatalf("Claim = %+v, %v, want ErrQueueEmpty", entry, err)
+	}
+}
+
+func get(t *testing.T, b queue.Backend, fileId int) *models.Queue {
+	t.Helper()
+	entry, err := b.Get(context.Background(), fileId)
+	if err != nil {
+		t.Fatalf("Get of file %d: %v", fileId, err)
+	}
+	return entry
+}
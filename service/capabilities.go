@@ -0,0 +1,28 @@
+package service
+
+import (
+	"sort"
+	"strings"
+)
+
+// ParseCapabilities converts a comma separated list of capabilities, as given in requests, to a normalized set
+func ParseCapabilities(value string) []string {
+	return NormalizeCapabilities(strings.Split(value, ","))
+}
+
+// NormalizeCapabilities lowercases, sorts and removes blank and repeated capabilities, it never returns nil
+func NormalizeCapabilities(capabilities []string) []string {
+	normalized := []string{}
+	seen := make(map[string]bool)
+	for _, capability := range capabilities {
+		capability = strings.ToLower(strings.TrimSpace(capability))
+		if capability == "" || seen[capability] {
+			continue
+		}
+		seen[capability] = true
+		normalized = append(normalized, capability)
+	}
+
+	sort.Strings(normalized)
+	return normalized
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	er "PDFStoring/error"
+	"PDFStoring/models"
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// GetQueueControl reports the administrative state of the queue and how many files are still leased to workers
+func (s *QueueServiceStruct) GetQueueControl(ctx context.Context) (*models.QueueControl, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	query := `SELECT state, changed_at FROM queue_control`
+
+	var control models.QueueControl
+	err := s.dbService.GetPool().QueryRow(ctx, query).Scan(&control.State, &control.ChangedAt)
+	if err != nil {
+		if er.HandleDeadlineExceededError(err) != nil {
+			log.Println("Deadline exceeded while fetching queue state")
+			return nil, err
+		}
+		log.Printf("Error fetching queue state: %v", err)
+		return nil, err
+	}
+
+	depths, err := s.backend.Stats(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, depth := range depths {
+		control.InFlight += depth.Leased
+	}
+	control.Drained = control.State == string(QueueDraining) && control.InFlight == 0
+
+	return &control, nil
+}
+
+// SetQueueState pauses, resumes or drains the queue. The state is stored in the database, so it is shared by
+// every API instance and survives restarts. Once it returns no new file is handed out unless the queue runs.
+func (s *QueueServiceStruct) SetQueueState(ctx context.Context, state QueueState) (*models.QueueControl, error) {
+	timeoutCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	tx, err := s.dbService.GetPool().Begin(timeoutCtx)
+	if err != nil {
+		if er.HandleDeadlineExceededError(err) != nil {
+			log.Println("Deadline exceeded while starting queue transaction")
+			return nil, err
+		}
+		log.Printf("Error starting queue transaction: %v", err)
+		return nil, err
+	}
+	defer tx.Rollback(timeoutCtx)
+
+	// Claims hold a share lock on the state, so this waits for the claims that are already running
+	query := `UPDATE queue_control SET state = $1, changed_at = NOW() WHERE state <> $1`
+
+	_, err = tx.Exec(timeoutCtx, query, string(state))
+	if err != nil {
+		if er.HandleDeadlineExceededError(err) != nil {
+			log.Println("Deadline exceeded while updating queue state")
+			return nil, err
+		}
+		log.Printf("Error updating queue state: %v", err)
+		return nil, err
+	}
+
+	// Wake up the workers waiting for files, they were not notified of what was queued while paused
+	if state == QueueRunning {
+		err = notifyQueue(timeoutCtx, tx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit(timeoutCtx)
+	if err != nil {
+		log.Printf("Error committing queue transaction: %v", err)
+		return nil, err
+	}
+
+	log.Println("Queue state set to", state)
+	return s.GetQueueControl(ctx)
+}
+
+// queueState reads the administrative state of the queue and holds it until the transaction ends
+func queueState(ctx context.Context, tx pgx.Tx) (QueueState, error) {
+	query := `SELECT state FROM queue_control FOR SHARE`
+
+	var state string
+	err := tx.QueryRow(ctx, query).Scan(&state)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return QueueRunning, nil
+	}
+	if err != nil {
+		if er.HandleDeadlineExceededError(err) != nil {
+			log.Println("Deadline exceeded while fetching queue state")
+			return "", err
+		}
+		log.Printf("Error fetching queue state: %v", err)
+		return "", err
+	}
+
+	return QueueState(state), nil
+}
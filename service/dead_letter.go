@@ -0,0 +1,64 @@
+package service
+
+import (
+	er "PDFStoring/error"
+	"PDFStoring/models"
+	"PDFStoring/queue"
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+// GetDeadLetters returns every file in the dead-letter queue, most recently failed first
+func (s *QueueServiceStruct) GetDeadLetters(ctx context.Context) ([]models.DeadLetter, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	return s.backend.DeadLetters(ctx)
+}
+
+// RequeueDeadLetter takes a file out of the dead-letter queue and puts it back in the queue with a fresh
+// set of attempts
+func (s *QueueServiceStruct) RequeueDeadLetter(ctx context.Context, fileId int) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	tx, err := queue.Begin(ctx, s.dbService.GetPool())
+	if err != nil {
+		if er.HandleDeadlineExceededError(err) != nil {
+			log.Println("Deadline exceeded while starting queue transaction")
+			return err
+		}
+		log.Printf("Error starting queue transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	entry, err := s.backend.RequeueDeadLetter(queue.WithTx(ctx, tx), fileId)
+	if err != nil {
+		if errors.Is(err, er.ErrNotDeadLettered) {
+			log.Println("File is not in the dead-letter queue:", fileId)
+		}
+		return err
+	}
+
+	err = setFileStatus(ctx, tx, fileId, InQueue, Priority(entry.Priority))
+	if err != nil {
+		return err
+	}
+
+	err = notifyQueue(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Printf("Error committing queue transaction: %v", err)
+		return err
+	}
+
+	log.Println("File requeued from dead-letter queue:", fileId)
+	return nil
+}
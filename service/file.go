@@ -3,11 +3,15 @@ package service
 import (
 	"PDFStoring/database"
 	er "PDFStoring/error"
+	"PDFStoring/models"
+	"PDFStoring/queue"
+	"PDFStoring/storage"
 	"context"
 	"crypto/sha256"
 	"database/sql"
 	"encoding/hex"
 	"errors"
+	"github.com/jackc/pgx/v4"
 	"io"
 	"log"
 	"mime/multipart"
@@ -16,27 +20,57 @@ import (
 
 type FileServiceStruct struct {
 	dbService    database.DatabaseService
-	QueueService QueueServiceStruct
+	blobStore    storage.BlobStore
+	queueBackend queue.Backend
+	limits       Limits
 }
 
 // FileService interface defines methods for user-related operations
 type FileService interface {
-	UploadFile(ctx context.Context, userId int, file *multipart.FileHeader) (int, error)
+	UploadFile(ctx context.Context, userId int, file *multipart.FileHeader, options EnqueueOptions) (int, error)
 	DeleteFile(ctx context.Context, userId int, fileId int) error
 	ImportFile(ctx context.Context, userId int, fileId int) error
+	GetFile(ctx context.Context, userId int, fileId int) (*models.UserFile, error)
+	CancelFile(ctx context.Context, userId int, fileId int) error
+	ReparseFile(ctx context.Context, userId int, fileId int, options EnqueueOptions) error
+	GetParseResults(ctx context.Context, userId int, fileId int) ([]models.ParseResult, error)
+	GetParseResult(ctx context.Context, userId int, fileId int, resultId int) (*models.ParseResult, error)
+	SetCurrentParseResult(ctx context.Context, userId int, fileId int, resultId int) error
+	GetPage(ctx context.Context, userId int, fileId int, number int) (*models.ParsedPage, error)
+	GetPages(ctx context.Context, userId int, fileId int, first int, last int) ([]models.ParsedPage, error)
+	GetOutline(ctx context.Context, userId int, fileId int) ([]models.OutlineItem, error)
+	GetFormFields(ctx context.Context, userId int, fileId int) ([]models.FormField, error)
 }
 
-// NewFileService creates a new instance of FileServiceStruct, implementing FileService
-func NewFileService(dbService database.DatabaseService) FileService {
+// NewFileService creates a new instance of FileServiceStruct, implementing FileService. Uploads are checked
+// against the given limits unless the user has limits of their own. The queue state of the files is looked up
+// in the queue backend.
+func NewFileService(dbService database.DatabaseService, blobStore storage.BlobStore, queueBackend queue.Backend, limits Limits) FileService {
 	return &FileServiceStruct{
-		dbService: dbService,
+		dbService:    dbService,
+		blobStore:    blobStore,
+		queueBackend: queueBackend,
+		limits:       limits,
 	}
 }
 
-func (s *FileServiceStruct) UploadFile(ctx context.Context, userId int, file *multipart.FileHeader) (int, error) {
+// UploadFile stores an uploaded PDF for the user and queues it for parsing with the given options. A file that
+// was already uploaded before is only linked to the user.
+func (s *FileServiceStruct) UploadFile(ctx context.Context, userId int, file *multipart.FileHeader, options EnqueueOptions) (int, error) {
+	// Storing a large file can take longer than the timeout of the database queries
+	blobCtx := ctx
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
+	limits, err := userLimits(ctx, s.dbService.GetPool(), userId, s.limits)
+	if err != nil {
+		return 0, err
+	}
+	if limits.MaxFileBytes > 0 && file.Size > limits.MaxFileBytes {
+		log.Printf("File of %d bytes is over the limit of %d bytes", file.Size, limits.MaxFileBytes)
+		return 0, er.ErrFileTooLarge
+	}
+
 	uploadedFile, err := file.Open()
 	if err != nil {
 		if err == er.HandleDeadlineExceededError(err) {
@@ -48,8 +82,10 @@ func (s *FileServiceStruct) UploadFile(ctx context.Context, userId int, file *mu
 	}
 	defer uploadedFile.Close()
 
+	// The file is hashed and pre-scanned in a single pass, without holding it in memory
 	hasher := sha256.New()
-	if _, err := io.Copy(hasher, uploadedFile); err != nil {
+	scan := &prescan{}
+	if _, err := io.Copy(io.MultiWriter(hasher, scan), uploadedFile); err != nil {
 		if err == er.HandleDeadlineExceededError(err) {
 			log.Println("Deadline exceeded while hashing file")
 			return 0, err
@@ -57,13 +93,21 @@ func (s *FileServiceStruct) UploadFile(ctx context.Context, userId int, file *mu
 		log.Printf("Error while hashing file: %v", err)
 		return 0, err
 	}
+	scan.Close()
+
+	// Files whose pages cannot be counted here are held to the page limit by the worker
+	pages := scan.pageCount()
+	if limits.MaxPages > 0 && pages > limits.MaxPages {
+		log.Printf("File of %d pages is over the limit of %d pages", pages, limits.MaxPages)
+		return 0, er.ErrTooManyPages
+	}
 
 	fileHash := hex.EncodeToString(hasher.Sum(nil))
 
 	var fileId int
-	query := `SELECT id FROM files WHERE hash = $1`
+	query := `SELECT id FROM files WHERE file_hash = $1`
 	err = s.dbService.GetPool().QueryRow(ctx, query, fileHash).Scan(&fileId)
-	if err != nil {
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
 		if err == er.HandleDeadlineExceededError(err) {
 			log.Println("Deadline exceeded while checking if file exists")
 			return 0, err
@@ -84,37 +128,64 @@ func (s *FileServiceStruct) UploadFile(ctx context.Context, userId int, file *mu
 		return fileId, nil
 	}
 
-	query = `INSERT INTO files (hash, filename, status, upload_date) VALUES ($1, $2, $3 $4) RETURNING id`
-	err = s.dbService.GetPool().QueryRow(ctx, query, fileHash, file.Filename, InQueue, time.Now().Format("2006-01-02 15:04:05")).Scan(&fileId)
+	// The file was read to the end while hashing, so rewind it before it is stored
+	_, err = uploadedFile.Seek(0, io.SeekStart)
 	if err != nil {
-		if err == er.HandleDeadlineExceededError(err) {
-			log.Println("Deadline exceeded while inserting file")
-			return 0, err
-		}
-		log.Printf("Error while inserting file: %v", err)
+		log.Printf("Error while rewinding file: %v", err)
 		return 0, err
 	}
 
-	err = s.insertUserFile(ctx, userId, fileId, file.Filename)
+	// The blob is stored before the file row, so no file ever refers to a missing blob
+	err = s.blobStore.Put(blobCtx, fileHash, uploadedFile, file.Size)
 	if err != nil {
-		if err == er.HandleDeadlineExceededError(err) {
-			log.Println("Deadline exceeded while inserting user file")
+		log.Printf("Error while storing file in the blob store: %v", err)
+		return 0, err
+	}
+
+	// The file, its user file and its queue entry are created together, so no file is left in the queue
+	// status without being queued
+	tx, err := queue.Begin(ctx, s.dbService.GetPool())
+	if err != nil {
+		if er.HandleDeadlineExceededError(err) != nil {
+			log.Println("Deadline exceeded while starting file transaction")
 			return 0, err
 		}
-		log.Printf("Error while inserting user file: %v", err)
+		log.Printf("Error while starting file transaction: %v", err)
 		return 0, err
 	}
+	defer tx.Rollback(ctx)
 
-	err = s.QueueService.AddFileToQueue(ctx, fileId, uploadedFile)
+	query = `INSERT INTO files (file_hash, filename, status, blob_key, file_size) VALUES ($1, $2, $3, $1, $4) RETURNING id`
+	err = tx.QueryRow(ctx, query, fileHash, file.Filename, InQueue, file.Size).Scan(&fileId)
 	if err != nil {
 		if err == er.HandleDeadlineExceededError(err) {
-			log.Println("Deadline exceeded while adding file to queue")
+			log.Println("Deadline exceeded while inserting file")
 			return 0, err
 		}
+		log.Printf("Error while inserting file: %v", err)
+		return 0, err
+	}
+
+	err = addUserFile(ctx, tx, userId, fileId, file.Filename)
+	if err != nil {
+		return 0, err
+	}
+
+	options.UserID = userId
+	options.RequiredCapabilities = append(scan.capabilities(), options.RequiredCapabilities...)
+	err = enqueueFile(ctx, tx, s.queueBackend, fileId, fileHash, options, s.limits)
+	if err != nil {
 		log.Printf("Error while adding file to queue: %v", err)
 		return 0, err
 	}
 
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Printf("Error while committing file transaction: %v", err)
+		return 0, err
+	}
+
+	log.Println("File added to queue:", fileId)
 	return fileId, nil
 }
 
@@ -162,8 +233,14 @@ func (s *FileServiceStruct) DeleteFile(ctx context.Context, userId int, fileId i
 	}
 
 	if storedFiles == 0 {
-		query = `DELETE FROM files WHERE id = $1`
-		_, err = s.dbService.GetPool().Query(ctx, query, fileId)
+		_, err = s.queueBackend.Remove(ctx, fileId)
+		if err != nil && !errors.Is(err, er.ErrNotQueued) {
+			return err
+		}
+
+		var blobKey *string
+		query = `DELETE FROM files WHERE id = $1 RETURNING blob_key`
+		err = s.dbService.GetPool().QueryRow(ctx, query, fileId).Scan(&blobKey)
 		if err != nil {
 			if err == er.HandleDeadlineExceededError(err) {
 				log.Println("Deadline exceeded while deleting file")
@@ -172,6 +249,14 @@ func (s *FileServiceStruct) DeleteFile(ctx context.Context, userId int, fileId i
 			log.Printf("Error while deleting file: %v", err)
 			return err
 		}
+
+		// The file is gone even if its blob could not be deleted, an upload of the same file replaces it
+		if blobKey != nil {
+			err = s.blobStore.Delete(ctx, *blobKey)
+			if err != nil {
+				log.Printf("Error while deleting file from the blob store: %v", err)
+			}
+		}
 	}
 
 	return nil
@@ -223,17 +308,112 @@ func (s *FileServiceStruct) ImportFile(ctx context.Context, userId int, fileId i
 		return errors.New("File is not parsed")
 	}
 
-	query = `UPDATE files SET status = $1 WHERE id = $2`
-	_, err = s.dbService.GetPool().Exec(ctx, query, Imported, fileId)
+	err = setFileStatus(ctx, s.dbService.GetPool(), fileId, Imported, "")
 	if err != nil {
-		if err == er.HandleDeadlineExceededError(err) {
-			log.Println("Deadline exceeded while updating file")
+		return err
+	}
+
+	return nil
+}
+
+// GetFile returns the details of one file of the user, including the parse progress while it is being parsed
+func (s *FileServiceStruct) GetFile(ctx context.Context, userId int, fileId int) (*models.UserFile, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	query := userFilesQuery + `WHERE uf.user_id = $1 AND uf.file_id = $2`
+
+	userFile, err := scanUserFile(s.dbService.GetPool().QueryRow(ctx, query, userId, fileId))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, er.ErrFileNotFound
+		}
+		if er.HandleDeadlineExceededError(err) != nil {
+			log.Println("Deadline exceeded while fetching file")
+			return nil, err
+		}
+		log.Printf("Error while fetching file: %v", err)
+		return nil, err
+	}
+
+	userFiles := []models.UserFile{userFile}
+	err = addQueueState(ctx, s.queueBackend, userFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	return &userFiles[0], nil
+}
+
+// CancelFile stops the parsing of a file that is queued or being parsed. The file is removed from the queue,
+// which invalidates the lease of its worker, and the worker is told about it on its next heartbeat.
+func (s *FileServiceStruct) CancelFile(ctx context.Context, userId int, fileId int) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	exists, err := s.userFileAlreadyExists(ctx, userId, fileId)
+	if err != nil {
+		log.Printf("Error while checking if user file exists: %v", err)
+		return err
+	}
+	if !exists {
+		return er.ErrFileNotFound
+	}
+
+	tx, err := queue.Begin(ctx, s.dbService.GetPool())
+	if err != nil {
+		if er.HandleDeadlineExceededError(err) != nil {
+			log.Println("Deadline exceeded while starting file transaction")
 			return err
 		}
-		log.Printf("Error while updating file: %v", err)
+		log.Printf("Error while starting file transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var status FileStatus
+	query := `SELECT status FROM files WHERE id = $1 FOR UPDATE`
+	err = tx.QueryRow(ctx, query, fileId).Scan(&status)
+	if err != nil {
+		if er.HandleDeadlineExceededError(err) != nil {
+			log.Println("Deadline exceeded while checking file status")
+			return err
+		}
+		log.Printf("Error while checking file status: %v", err)
 		return err
 	}
 
+	if status != InQueue && status != Parsing {
+		log.Printf("File %d cannot be cancelled in status %s", fileId, status)
+		return er.ErrFileFinal
+	}
+
+	entry, err := s.queueBackend.Remove(queue.WithTx(ctx, tx), fileId)
+	if err != nil && !errors.Is(err, er.ErrNotQueued) {
+		return err
+	}
+
+	var priority Priority
+	if entry != nil {
+		priority = Priority(entry.Priority)
+		err = recordCancellation(ctx, tx, *entry)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = setFileStatus(ctx, tx, fileId, Cancelled, priority)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Printf("Error while committing file transaction: %v", err)
+		return err
+	}
+
+	log.Println("File cancelled:", fileId)
 	return nil
 }
 
@@ -251,7 +431,12 @@ func (s *FileServiceStruct) insertUserFile(ctx context.Context, userId int, file
 		return nil
 	}
 
-	_, err = s.dbService.GetPool().Exec(ctx, "INSERT INTO user_files (user_id, file_id, filename, upload_date ) VALUES ($1, $2, $3, $4)", userId, fileId, filename, time.Now().Format("2006-01-02 15:04:05"))
+	return addUserFile(ctx, s.dbService.GetPool(), userId, fileId, filename)
+}
+
+// addUserFile adds the file to the files of the user under the given name
+func addUserFile(ctx context.Context, db executor, userId int, fileId int, filename string) error {
+	_, err := db.Exec(ctx, "INSERT INTO user_files (user_id, file_id, filename, upload_date ) VALUES ($1, $2, $3, $4)", userId, fileId, filename, time.Now().Format("2006-01-02 15:04:05"))
 	if err != nil {
 		if err == er.HandleDeadlineExceededError(err) {
 			log.Println("Deadline exceeded while inserting user file")
@@ -268,19 +453,19 @@ func (s *FileServiceStruct) userFileAlreadyExists(ctx context.Context, userId in
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	var exists int
+	var exists bool
 	err := s.dbService.GetPool().QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM user_files WHERE user_id = $1 AND file_id = $2)", userId, fileId).Scan(&exists)
 	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
 		if err == er.HandleDeadlineExceededError(err) {
 			log.Println("Deadline exceeded while checking if user file exists")
 			return false, err
 		}
 		log.Printf("Error while checking if user file exists: %v", err)
 		return false, err
-		if err == sql.ErrNoRows {
-			return false, nil
-		}
 	}
 
-	return exists > 0, nil
+	return exists, nil
 }
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"PDFStoring/models"
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+)
+
+// formFieldTypes are the types a form field can be reported with
+var formFieldTypes = map[string]bool{
+	"text":      true,
+	"checkbox":  true,
+	"radio":     true,
+	"button":    true,
+	"choice":    true,
+	"signature": true,
+}
+
+// GetFormFields returns the form fields of the current parse result of a file of the user, with the values they
+// were filled in with. A file parsed without a form has no fields.
+func (s *FileServiceStruct) GetFormFields(ctx context.Context, userId int, fileId int) ([]models.FormField, error) {
+	data, err := s.currentResultJSON(ctx, userId, fileId, "form_fields")
+	if err != nil {
+		return nil, err
+	}
+
+	fields := []models.FormField{}
+	if data != nil {
+		err = json.Unmarshal(data, &fields)
+		if err != nil {
+			log.Printf("Error while decoding form fields: %v", err)
+			return nil, err
+		}
+	}
+
+	return fields, nil
+}
+
+// validFormFields reports whether every form field of a result has a known type and a page number that can exist
+func validFormFields(fields []models.FormField) bool {
+	for _, field := range fields {
+		if !formFieldTypes[field.Type] || field.Page < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// formFieldsJSON encodes the form fields of a result for storing, nil when the result has none. The NUL
+// characters Postgres JSON cannot hold are removed.
+func formFieldsJSON(fields []models.FormField) ([]byte, error) {
+	if fields == nil {
+		return nil, nil
+	}
+
+	clean := func(value string) string {
+		return strings.ReplaceAll(value, "\x00", "")
+	}
+	cleanAll := func(values []string) []string {
+		if values == nil {
+			return nil
+		}
+		cleaned := make([]string, len(values))
+		for i, value := range values {
+			cleaned[i] = clean(value)
+		}
+		return cleaned
+	}
+
+	cleaned := make([]models.FormField, len(fields))
+	for i, field := range fields {
+		cleaned[i] = models.FormField{
+			Name:     clean(field.Name),
+			Type:     field.Type,
+			Value:    clean(field.Value),
+			Selected: cleanAll(field.Selected),
+			Options:  cleanAll(field.Options),
+			Page:     field.Page,
+		}
+	}
+	return json.Marshal(cleaned)
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	er "PDFStoring/error"
+	"PDFStoring/models"
+	"PDFStoring/queue"
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// Limits bounds the files users upload and the work spent parsing them, a zero value disables a limit
+type Limits struct {
+	// MaxFileBytes is the size of the largest PDF accepted at upload
+	MaxFileBytes int64
+	// MaxPages is checked at upload when the pages can be counted, and passed to the workers with the job
+	MaxPages int
+	// MaxParseTime is how long a worker can spend on one attempt before the file ends in limit_exceeded
+	MaxParseTime time.Duration
+}
+
+// DefaultLimits returns the limits used when none are configured
+func DefaultLimits() Limits {
+	return Limits{
+		MaxFileBytes: 10 << 20,
+	}
+}
+
+// userLimits returns the limits of a user, the defaults overridden by the limits set for the user
+func userLimits(ctx context.Context, db querier, userId int, defaults Limits) (Limits, error) {
+	query := `SELECT COALESCE(max_file_bytes, $2), COALESCE(max_pages, $3), COALESCE(max_parse_seconds::float8, $4)
+	FROM users WHERE id = $1`
+
+	var limits Limits
+	var maxParseSeconds float64
+	err := db.QueryRow(ctx, query, userId, defaults.MaxFileBytes, defaults.MaxPages, defaults.MaxParseTime.Seconds()).Scan(
+		&limits.MaxFileBytes, &limits.MaxPages, &maxParseSeconds)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return defaults, nil
+		}
+		if er.HandleDeadlineExceededError(err) != nil {
+			log.Println("Deadline exceeded while fetching user limits")
+			return Limits{}, err
+		}
+		log.Printf("Error fetching user limits: %v", err)
+		return Limits{}, err
+	}
+	limits.MaxParseTime = time.Duration(maxParseSeconds * float64(time.Second))
+
+	return limits, nil
+}
+
+// ExpireParseBudgets ends the jobs that went over their parse time budget in limit_exceeded, telling their
+// workers to stop on their next heartbeat, and returns how many jobs were ended
+func (s *QueueServiceStruct) ExpireParseBudgets(ctx context.Context) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	tx, err := queue.Begin(ctx, s.dbService.GetPool())
+	if err != nil {
+		if er.HandleDeadlineExceededError(err) != nil {
+			log.Println("Deadline exceeded while starting queue transaction")
+			return 0, err
+		}
+		log.Printf("Error starting queue transaction: %v", err)
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	expired, err := s.backend.ExpireParseDeadlines(queue.WithTx(ctx, tx))
+	if err != nil {
+		return 0, err
+	}
+
+	for _, entry := range expired {
+		err = recordCancellation(ctx, tx, entry)
+		if err != nil {
+			return 0, err
+		}
+
+		err = exceedLimit(ctx, tx, entry, "Parse time limit exceeded")
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Printf("Error committing queue transaction: %v", err)
+		return 0, err
+	}
+
+	return len(expired), nil
+}
+
+// exceedLimit ends a file that went over its limits in limit_exceeded without retrying it, as another attempt
+// would go over them again. The caller takes the file out of the queue. The file can be reparsed once the
+// limits are raised.
+func exceedLimit(ctx context.Context, tx pgx.Tx, entry models.Queue, reason string) error {
+	err := setFileStatus(ctx, tx, entry.FileID, LimitExceeded, Priority(entry.Priority))
+	if err != nil {
+		return err
+	}
+
+	log.Printf("File %d went over its limits: %s", entry.FileID, reason)
+	return nil
+}
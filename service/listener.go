@@ -0,0 +1,87 @@
+package service
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// queueListenRetryDelay is how long the queue listener waits before listening again after losing its connection
+const queueListenRetryDelay = 5 * time.Second
+
+// queueListener wakes up the callers of WaitForFiles when a file is queued. It holds the only connection
+// listening for notifications, so the waiting workers do not each take a connection from the pool.
+type queueListener struct {
+	mu sync.Mutex
+	// notified is closed when a notification arrives, and replaced by a new channel for the next one
+	notified chan struct{}
+}
+
+func newQueueListener() *queueListener {
+	return &queueListener{notified: make(chan struct{})}
+}
+
+// wait returns a channel closed at the next notification
+func (l *queueListener) wait() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.notified
+}
+
+// notify wakes up every caller waiting for a notification
+func (l *queueListener) notify() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	close(l.notified)
+	l.notified = make(chan struct{})
+}
+
+// listen passes the notifications of queued files to the waiters until the given context is cancelled,
+// listening again whenever its connection is lost
+func (l *queueListener) listen(ctx context.Context, pool *pgxpool.Pool) {
+	for {
+		err := l.listenOnce(ctx, pool)
+		if ctx.Err() != nil {
+			log.Println("Queue listener stopped")
+			return
+		}
+		log.Printf("Error listening for queued files: %v", err)
+
+		// A notification may have been missed, so the waiters look at the queue again
+		l.notify()
+		select {
+		case <-ctx.Done():
+			log.Println("Queue listener stopped")
+			return
+		case <-time.After(queueListenRetryDelay):
+		}
+	}
+}
+
+// listenOnce listens for queued files on one connection until it fails or the given context is cancelled
+func (l *queueListener) listenOnce(ctx context.Context, pool *pgxpool.Pool) error {
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	_, err = conn.Exec(ctx, "LISTEN "+queueChannel)
+	if err != nil {
+		return err
+	}
+	defer conn.Exec(context.Background(), "UNLISTEN "+queueChannel)
+
+	// Files queued before the listener was ready are picked up by the waiters looking at the queue again
+	l.notify()
+	for {
+		_, err = conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		l.notify()
+	}
+}
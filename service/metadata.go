@@ -0,0 +1,161 @@
+package service
+
+import (
+	er "PDFStoring/error"
+	"PDFStoring/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FileSort is a column the files of a user can be listed by
+type FileSort string
+
+const (
+	SortUploadDate FileSort = "upload_date"
+	SortTitle      FileSort = "title"
+	SortAuthor     FileSort = "author"
+	SortProducer   FileSort = "producer"
+	SortCreatedAt  FileSort = "created_at"
+	SortModifiedAt FileSort = "modified_at"
+	SortPDFVersion FileSort = "pdf_version"
+	SortPageCount  FileSort = "page_count"
+)
+
+// sortColumns maps the orders files can be listed in to the columns of userFilesQuery
+var sortColumns = map[FileSort]string{
+	SortUploadDate: "uf.upload_date",
+	SortTitle:      "r.title",
+	SortAuthor:     "r.author",
+	SortProducer:   "r.producer",
+	SortCreatedAt:  "r.document_created_at",
+	SortModifiedAt: "r.document_modified_at",
+	SortPDFVersion: "r.pdf_version",
+	SortPageCount:  "r.page_count",
+}
+
+// ParseFileSort converts a requested order to the column files are listed by, defaulting to the upload date
+func ParseFileSort(value string) (FileSort, error) {
+	if value == "" {
+		return SortUploadDate, nil
+	}
+	if _, ok := sortColumns[FileSort(value)]; !ok {
+		return "", er.ErrInvalidSort
+	}
+	return FileSort(value), nil
+}
+
+// FileFilter narrows down the files of a user by the metadata of their current parse result and orders them.
+// Zero values do not filter, and files without metadata only pass filters that are not set.
+type FileFilter struct {
+	// Title, Author and Producer match when they are contained in the metadata, ignoring case
+	Title    string
+	Author   string
+	Producer string
+	// PDFVersion matches the version exactly, such as 1.7
+	PDFVersion     string
+	CreatedAfter   *time.Time
+	CreatedBefore  *time.Time
+	ModifiedAfter  *time.Time
+	ModifiedBefore *time.Time
+	MinPages       int
+	MaxPages       int
+
+	Sort FileSort
+	// Descending reverses the order, files without a value for the sort column are listed last either way
+	Descending bool
+}
+
+// where builds the conditions of the filter on userFilesQuery, numbering its arguments after args
+func (f FileFilter) where(args []interface{}) (string, []interface{}) {
+	var conditions []string
+	add := func(condition string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, strings.ReplaceAll(condition, "?", "$"+strconv.Itoa(len(args))))
+	}
+
+	if f.Title != "" {
+		add("r.title ILIKE '%' || ? || '%'", escapeLike(f.Title))
+	}
+	if f.Author != "" {
+		add("r.author ILIKE '%' || ? || '%'", escapeLike(f.Author))
+	}
+	if f.Producer != "" {
+		add("r.producer ILIKE '%' || ? || '%'", escapeLike(f.Producer))
+	}
+	if f.PDFVersion != "" {
+		add("r.pdf_version = ?", f.PDFVersion)
+	}
+	if f.CreatedAfter != nil {
+		add("r.document_created_at >= ?", f.CreatedAfter.UTC())
+	}
+	if f.CreatedBefore != nil {
+		add("r.document_created_at < ?", f.CreatedBefore.UTC())
+	}
+	if f.ModifiedAfter != nil {
+		add("r.document_modified_at >= ?", f.ModifiedAfter.UTC())
+	}
+	if f.ModifiedBefore != nil {
+		add("r.document_modified_at < ?", f.ModifiedBefore.UTC())
+	}
+	if f.MinPages > 0 {
+		add("r.page_count >= ?", f.MinPages)
+	}
+	if f.MaxPages > 0 {
+		add("r.page_count <= ?", f.MaxPages)
+	}
+
+	if len(conditions) == 0 {
+		return "", args
+	}
+	return " AND " + strings.Join(conditions, " AND "), args
+}
+
+// orderBy builds the ORDER BY clause of the filter on userFilesQuery
+func (f FileFilter) orderBy() string {
+	column, ok := sortColumns[f.Sort]
+	if !ok {
+		column = sortColumns[SortUploadDate]
+	}
+	direction := "ASC"
+	if f.Descending {
+		direction = "DESC"
+	}
+	return " ORDER BY " + column + " " + direction + " NULLS LAST, uf.upload_date DESC, uf.file_id DESC"
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern, so they are matched literally
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
+
+// validMetadata reports whether the metadata of a result can be stored
+func validMetadata(metadata *models.DocumentMetadata) bool {
+	return metadata == nil || (metadata.PageCount >= 0 && len(metadata.PDFVersion) <= 10)
+}
+
+// resultMetadata returns the metadata of a result as it is stored, with the page count defaulting to the
+// number of pages reported and the dates in UTC
+func resultMetadata(parsedData models.Parser) models.DocumentMetadata {
+	var metadata models.DocumentMetadata
+	if parsedData.Metadata != nil {
+		metadata = *parsedData.Metadata
+	}
+	if metadata.PageCount == 0 {
+		metadata.PageCount = len(parsedData.Pages)
+	}
+
+	// Postgres text cannot hold NUL characters
+	for _, field := range []*string{&metadata.Title, &metadata.Author, &metadata.Producer, &metadata.PDFVersion} {
+		*field = strings.TrimSpace(strings.ReplaceAll(*field, "\x00", ""))
+	}
+	if metadata.CreatedAt != nil {
+		createdAt := metadata.CreatedAt.UTC()
+		metadata.CreatedAt = &createdAt
+	}
+	if metadata.ModifiedAt != nil {
+		modifiedAt := metadata.ModifiedAt.UTC()
+		metadata.ModifiedAt = &modifiedAt
+	}
+	return metadata
+}
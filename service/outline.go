@@ -0,0 +1,60 @@
+package service
+
+import (
+	"PDFStoring/models"
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+)
+
+// GetOutline returns the bookmarks of the current parse result of a file of the user, nested as they are shown.
+// A file parsed without bookmarks has an empty outline.
+func (s *FileServiceStruct) GetOutline(ctx context.Context, userId int, fileId int) ([]models.OutlineItem, error) {
+	data, err := s.currentResultJSON(ctx, userId, fileId, "outline")
+	if err != nil {
+		return nil, err
+	}
+
+	outline := []models.OutlineItem{}
+	if data != nil {
+		err = json.Unmarshal(data, &outline)
+		if err != nil {
+			log.Printf("Error while decoding outline: %v", err)
+			return nil, err
+		}
+	}
+
+	return outline, nil
+}
+
+// validOutline reports whether every item of an outline leads to a page number that can exist
+func validOutline(items []models.OutlineItem) bool {
+	for _, item := range items {
+		if item.Page < 0 || !validOutline(item.Children) {
+			return false
+		}
+	}
+	return true
+}
+
+// outlineJSON encodes an outline for storing, nil when the result has none
+func outlineJSON(items []models.OutlineItem) ([]byte, error) {
+	if items == nil {
+		return nil, nil
+	}
+	return json.Marshal(cleanOutline(items))
+}
+
+// cleanOutline removes the NUL characters from the titles of an outline, which Postgres JSON cannot hold
+func cleanOutline(items []models.OutlineItem) []models.OutlineItem {
+	cleaned := make([]models.OutlineItem, len(items))
+	for i, item := range items {
+		cleaned[i] = models.OutlineItem{
+			Title:    strings.ReplaceAll(item.Title, "\x00", ""),
+			Page:     item.Page,
+			Children: cleanOutline(item.Children),
+		}
+	}
+	return cleaned
+}
@@ -0,0 +1,153 @@
+package service
+
+import (
+	er "PDFStoring/error"
+	"PDFStoring/models"
+	"context"
+	"log"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// GetPage returns one page of the current parse result of a file of the user, by its number starting from 1
+func (s *FileServiceStruct) GetPage(ctx context.Context, userId int, fileId int, number int) (*models.ParsedPage, error) {
+	pages, err := s.GetPages(ctx, userId, fileId, number, number)
+	if err != nil {
+		return nil, err
+	}
+	if len(pages) == 0 {
+		return nil, er.ErrPageNotFound
+	}
+
+	return &pages[0], nil
+}
+
+// GetPages returns the pages numbered first to last of the current parse result of a file of the user, or the
+// pages from first to the end when last is 0. It fails with ErrNotParsed when the file has no parse result yet.
+func (s *FileServiceStruct) GetPages(ctx context.Context, userId int, fileId int, first int, last int) ([]models.ParsedPage, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	exists, err := s.userFileAlreadyExists(ctx, userId, fileId)
+	if err != nil {
+		log.Printf("Error while checking if user file exists: %v", err)
+		return nil, err
+	}
+	if !exists {
+		return nil, er.ErrFileNotFound
+	}
+
+	var parsed bool
+	query := `SELECT EXISTS(SELECT 1 FROM parse_results WHERE file_id = $1 AND is_current)`
+	err = s.dbService.GetPool().QueryRow(ctx, query, fileId).Scan(&parsed)
+	if err != nil {
+		if er.HandleDeadlineExceededError(err) != nil {
+			log.Println("Deadline exceeded while checking parse result")
+			return nil, err
+		}
+		log.Printf("Error while checking parse result: %v", err)
+		return nil, err
+	}
+	if !parsed {
+		return nil, er.ErrNotParsed
+	}
+
+	query = `SELECT p.page_number, p.width, p.height, p.rotation, p.text, p.char_count
+	FROM parsed_pages p JOIN parse_results r ON r.id = p.result_id
+	WHERE r.file_id = $1 AND r.is_current AND p.page_number >= $2 AND ($3 = 0 OR p.page_number <= $3)
+	ORDER BY p.page_number`
+
+	rows, err := s.dbService.GetPool().Query(ctx, query, fileId, first, last)
+	if err != nil {
+		if er.HandleDeadlineExceededError(err) != nil {
+			log.Println("Deadline exceeded while fetching pages")
+			return nil, err
+		}
+		log.Printf("Error while fetching pages: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	pages := []models.ParsedPage{}
+	for rows.Next() {
+		var page models.ParsedPage
+		err := rows.Scan(&page.Number, &page.Width, &page.Height, &page.Rotation, &page.Text, &page.CharCount)
+		if err != nil {
+			log.Printf("Error while scanning pages: %v", err)
+			return nil, err
+		}
+		pages = append(pages, page)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Printf("Error iterating over rows: %v", err)
+		return nil, err
+	}
+
+	return pages, nil
+}
+
+// validPages reports whether the pages of a result are numbered from 1 without repeats and have a rotation a
+// page can be displayed with
+func validPages(pages []models.ParsedPage) bool {
+	seen := make(map[int]bool, len(pages))
+	for _, page := range pages {
+		if page.Number < 1 || seen[page.Number] || page.Width < 0 || page.Height < 0 {
+			return false
+		}
+		if page.Rotation != 0 && page.Rotation != 90 && page.Rotation != 180 && page.Rotation != 270 {
+			return false
+		}
+		seen[page.Number] = true
+	}
+	return true
+}
+
+// pagesText joins the texts of the pages of a result in page order, separated by form feeds
+func pagesText(pages []models.ParsedPage) string {
+	texts := make([]string, 0, len(pages))
+	for _, page := range sortedPages(pages) {
+		texts = append(texts, page.Text)
+	}
+	return strings.Join(texts, "\f")
+}
+
+// sortedPages returns the pages ordered by their number
+func sortedPages(pages []models.ParsedPage) []models.ParsedPage {
+	sorted := append([]models.ParsedPage(nil), pages...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Number < sorted[j].Number })
+	return sorted
+}
+
+// recordPages stores the pages of a parse result, counting the characters of their text
+func recordPages(ctx context.Context, tx pgx.Tx, resultId int, pages []models.ParsedPage) error {
+	if len(pages) == 0 {
+		return nil
+	}
+
+	rows := make([][]interface{}, len(pages))
+	for i, page := range pages {
+		// Postgres text cannot hold NUL characters
+		text := strings.ReplaceAll(page.Text, "\x00", "")
+		rows[i] = []interface{}{resultId, page.Number, page.Width, page.Height, page.Rotation, text, utf8.RuneCountInString(text)}
+	}
+
+	columns := []string{"result_id", "page_number", "width", "height", "rotation", "text", "char_count"}
+	_, err := tx.CopyFrom(ctx, pgx.Identifier{"parsed_pages"}, columns, pgx.CopyFromSlice(len(rows), func(i int) ([]interface{}, error) {
+		return rows[i], nil
+	}))
+	if err != nil {
+		if er.HandleDeadlineExceededError(err) != nil {
+			log.Println("Deadline exceeded while storing pages")
+			return err
+		}
+		log.Printf("Error storing pages: %v", err)
+		return err
+	}
+
+	return nil
+}
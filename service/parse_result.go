@@ -0,0 +1,415 @@
+package service
+
+import (
+	er "PDFStoring/error"
+	"PDFStoring/models"
+	"PDFStoring/queue"
+	"PDFStoring/storage"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// ReparseFile puts a parsed or imported file back in the queue, so it is parsed again by the current parsers,
+// or a file that went over its limits once they are raised.
+// The earlier parse results are kept, and the new one becomes current once it is reported.
+func (s *FileServiceStruct) ReparseFile(ctx context.Context, userId int, fileId int, options EnqueueOptions) error {
+	// Pre-scanning a large file can take longer than the timeout of the database queries
+	blobCtx := ctx
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	exists, err := s.userFileAlreadyExists(ctx, userId, fileId)
+	if err != nil {
+		log.Printf("Error while checking if user file exists: %v", err)
+		return err
+	}
+	if !exists {
+		return er.ErrFileNotFound
+	}
+
+	var status FileStatus
+	var blobKey *string
+	query := `SELECT status, blob_key FROM files WHERE id = $1`
+	err = s.dbService.GetPool().QueryRow(ctx, query, fileId).Scan(&status, &blobKey)
+	if err != nil {
+		if er.HandleDeadlineExceededError(err) != nil {
+			log.Println("Deadline exceeded while checking file status")
+			return err
+		}
+		log.Printf("Error while checking file status: %v", err)
+		return err
+	}
+	err = checkReparsable(fileId, status, blobKey)
+	if err != nil {
+		return err
+	}
+
+	// Pre-scan the file again, the capabilities it is detected to need may have changed since it was uploaded. It
+	// is read before the file is locked, so the lock is not held while the PDF is read.
+	scannedKey := *blobKey
+	scan, err := s.prescanBlob(blobCtx, scannedKey)
+	if err != nil {
+		return err
+	}
+
+	tx, err := queue.Begin(ctx, s.dbService.GetPool())
+	if err != nil {
+		if er.HandleDeadlineExceededError(err) != nil {
+			log.Println("Deadline exceeded while starting file transaction")
+			return err
+		}
+		log.Printf("Error while starting file transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// The file may have been queued or changed while it was pre-scanned
+	query = `SELECT status, blob_key FROM files WHERE id = $1 FOR UPDATE`
+	err = tx.QueryRow(ctx, query, fileId).Scan(&status, &blobKey)
+	if err != nil {
+		if er.HandleDeadlineExceededError(err) != nil {
+			log.Println("Deadline exceeded while checking file status")
+			return err
+		}
+		log.Printf("Error while checking file status: %v", err)
+		return err
+	}
+	err = checkReparsable(fileId, status, blobKey)
+	if err != nil {
+		return err
+	}
+	if *blobKey != scannedKey {
+		log.Printf("File %d cannot be reparsed, its PDF changed while it was pre-scanned", fileId)
+		return er.ErrNotReparsable
+	}
+
+	options.UserID = userId
+	options.RequiredCapabilities = append(scan.capabilities(), options.RequiredCapabilities...)
+
+	err = enqueueFile(ctx, tx, s.queueBackend, fileId, *blobKey, options, s.limits)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Printf("Error while committing file transaction: %v", err)
+		return err
+	}
+
+	log.Println("File queued for reparsing:", fileId)
+	return nil
+}
+
+// checkReparsable checks that a file in the given status, with its PDF stored under the given key, can be reparsed
+func checkReparsable(fileId int, status FileStatus, blobKey *string) error {
+	if status != Success && status != Imported && status != LimitExceeded {
+		log.Printf("File %d cannot be reparsed in status %s", fileId, status)
+		return er.ErrNotReparsable
+	}
+	if blobKey == nil {
+		log.Printf("File %d cannot be reparsed, its PDF is not stored", fileId)
+		return er.ErrPDFNotStored
+	}
+	return nil
+}
+
+// GetParseResults lists every parse result of a file of the user, from the newest to the oldest one
+func (s *FileServiceStruct) GetParseResults(ctx context.Context, userId int, fileId int) ([]models.ParseResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	exists, err := s.userFileAlreadyExists(ctx, userId, fileId)
+	if err != nil {
+		log.Printf("Error while checking if user file exists: %v", err)
+		return nil, err
+	}
+	if !exists {
+		return nil, er.ErrFileNotFound
+	}
+
+	query := `SELECT id, file_id, parser_name, parser_version, parsed_at, is_current
+	FROM parse_results WHERE file_id = $1 ORDER BY parsed_at DESC, id DESC`
+
+	rows, err := s.dbService.GetPool().Query(ctx, query, fileId)
+	if err != nil {
+		if er.HandleDeadlineExceededError(err) != nil {
+			log.Println("Deadline exceeded while fetching parse results")
+			return nil, err
+		}
+		log.Printf("Error while fetching parse results: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []models.ParseResult{}
+	for rows.Next() {
+		var result models.ParseResult
+		err := rows.Scan(&result.ID, &result.FileID, &result.ParserName, &result.ParserVersion, &result.ParsedAt, &result.Current)
+		if err != nil {
+			log.Printf("Error while scanning parse results: %v", err)
+			return nil, err
+		}
+		results = append(results, result)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Printf("Error iterating over rows: %v", err)
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// GetParseResult returns one parse result of a file of the user, including the parsed file with its metadata,
+// outline and form fields
+func (s *FileServiceStruct) GetParseResult(ctx context.Context, userId int, fileId int, resultId int) (*models.ParseResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	exists, err := s.userFileAlreadyExists(ctx, userId, fileId)
+	if err != nil {
+		log.Printf("Error while checking if user file exists: %v", err)
+		return nil, err
+	}
+	if !exists {
+		return nil, er.ErrFileNotFound
+	}
+
+	query := `SELECT id, file_id, parser_name, parser_version, parsed_at, is_current, COALESCE(parsed_file, ''), title,
+		author, producer, document_created_at, document_modified_at, pdf_version, page_count, outline, form_fields
+	FROM parse_results WHERE id = $1 AND file_id = $2`
+
+	var result models.ParseResult
+	var metadata models.DocumentMetadata
+	var title, author, producer, pdfVersion *string
+	var pageCount *int
+	var parsedFile, outline, fields []byte
+	err = s.dbService.GetPool().QueryRow(ctx, query, resultId, fileId).Scan(&result.ID, &result.FileID, &result.ParserName,
+		&result.ParserVersion, &result.ParsedAt, &result.Current, &parsedFile, &title, &author, &producer,
+		&metadata.CreatedAt, &metadata.ModifiedAt, &pdfVersion, &pageCount, &outline, &fields)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, er.ErrParseResultNotFound
+		}
+		if er.HandleDeadlineExceededError(err) != nil {
+			log.Println("Deadline exceeded while fetching parse result")
+			return nil, err
+		}
+		log.Printf("Error while fetching parse result: %v", err)
+		return nil, err
+	}
+	result.ParsedFile = string(parsedFile)
+
+	metadata.Title = stringValue(title)
+	metadata.Author = stringValue(author)
+	metadata.Producer = stringValue(producer)
+	metadata.PDFVersion = stringValue(pdfVersion)
+	if pageCount != nil {
+		metadata.PageCount = *pageCount
+	}
+	result.Metadata = &metadata
+
+	if outline != nil {
+		err = json.Unmarshal(outline, &result.Outline)
+		if err != nil {
+			log.Printf("Error while decoding outline: %v", err)
+			return nil, err
+		}
+	}
+	if fields != nil {
+		err = json.Unmarshal(fields, &result.Fields)
+		if err != nil {
+			log.Printf("Error while decoding form fields: %v", err)
+			return nil, err
+		}
+	}
+
+	return &result, nil
+}
+
+// currentResultJSON returns a JSON column of the current parse result of a file of the user, nil when the result
+// has no value for it
+func (s *FileServiceStruct) currentResultJSON(ctx context.Context, userId int, fileId int, column string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	exists, err := s.userFileAlreadyExists(ctx, userId, fileId)
+	if err != nil {
+		log.Printf("Error while checking if user file exists: %v", err)
+		return nil, err
+	}
+	if !exists {
+		return nil, er.ErrFileNotFound
+	}
+
+	// The column is one of the constant names given by the callers, never user input
+	query := `SELECT ` + column + ` FROM parse_results WHERE file_id = $1 AND is_current`
+
+	var data []byte
+	err = s.dbService.GetPool().QueryRow(ctx, query, fileId).Scan(&data)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, er.ErrNotParsed
+		}
+		if er.HandleDeadlineExceededError(err) != nil {
+			log.Printf("Deadline exceeded while fetching %s", column)
+			return nil, err
+		}
+		log.Printf("Error while fetching %s: %v", column, err)
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// SetCurrentParseResult makes an earlier parse result of a file of the user the one served as its parsed file
+func (s *FileServiceStruct) SetCurrentParseResult(ctx context.Context, userId int, fileId int, resultId int) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	exists, err := s.userFileAlreadyExists(ctx, userId, fileId)
+	if err != nil {
+		log.Printf("Error while checking if user file exists: %v", err)
+		return err
+	}
+	if !exists {
+		return er.ErrFileNotFound
+	}
+
+	tx, err := s.dbService.GetPool().Begin(ctx)
+	if err != nil {
+		if er.HandleDeadlineExceededError(err) != nil {
+			log.Println("Deadline exceeded while starting file transaction")
+			return err
+		}
+		log.Printf("Error while starting file transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = setCurrentParseResult(ctx, tx, fileId, resultId)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Printf("Error while committing file transaction: %v", err)
+		return err
+	}
+
+	log.Printf("Parse result %d is now current for file %d", resultId, fileId)
+	return nil
+}
+
+// prescanBlob pre-scans a file kept in the blob store
+func (s *FileServiceStruct) prescanBlob(ctx context.Context, blobKey string) (*prescan, error) {
+	blob, err := s.blobStore.Get(ctx, blobKey, 0, -1)
+	if err != nil {
+		if errors.Is(err, storage.ErrBlobNotFound) {
+			return nil, er.ErrPDFNotStored
+		}
+		log.Printf("Error while reading file from the blob store: %v", err)
+		return nil, err
+	}
+	defer blob.Close()
+
+	scan := &prescan{}
+	_, err = io.Copy(scan, blob)
+	if err != nil {
+		log.Printf("Error while reading file from the blob store: %v", err)
+		return nil, err
+	}
+	scan.Close()
+
+	return scan, nil
+}
+
+// recordParseResult stores the result reported for a leased file and makes it current. The parser defaults to
+// the one the worker holding the lease registered with, so it must be called before the queue row is deleted.
+func recordParseResult(ctx context.Context, tx pgx.Tx, fileId int, workerId *int, parsedData models.Parser) error {
+	metadata := resultMetadata(parsedData)
+
+	query := `
+	INSERT INTO parse_results (file_id, parser_name, parser_version, parsed_file, title, author, producer,
+		document_created_at, document_modified_at, pdf_version, page_count, outline, form_fields)
+	VALUES ($1, COALESCE(NULLIF($2, ''), (SELECT name FROM workers WHERE id = $5), ''),
+		COALESCE(NULLIF($3, ''), (SELECT version FROM workers WHERE id = $5), ''), $4,
+		NULLIF($6, ''), NULLIF($7, ''), NULLIF($8, ''), $9, $10, NULLIF($11, ''), NULLIF($12, 0), $13, $14)
+	RETURNING id
+	`
+
+	outline, err := outlineJSON(parsedData.Outline)
+	if err != nil {
+		log.Printf("Error encoding outline: %v", err)
+		return err
+	}
+	fields, err := formFieldsJSON(parsedData.Fields)
+	if err != nil {
+		log.Printf("Error encoding form fields: %v", err)
+		return err
+	}
+
+	var resultId int
+	err = tx.QueryRow(ctx, query, fileId, parsedData.ParserName, parsedData.ParserVersion, []byte(parsedData.ParsedFile), workerId,
+		metadata.Title, metadata.Author, metadata.Producer, metadata.CreatedAt, metadata.ModifiedAt, metadata.PDFVersion,
+		metadata.PageCount, outline, fields).Scan(&resultId)
+	if err != nil {
+		if er.HandleDeadlineExceededError(err) != nil {
+			log.Println("Deadline exceeded while storing parse result")
+			return err
+		}
+		log.Printf("Error storing parse result: %v", err)
+		return err
+	}
+
+	err = recordPages(ctx, tx, resultId, parsedData.Pages)
+	if err != nil {
+		return err
+	}
+
+	return setCurrentParseResult(ctx, tx, fileId, resultId)
+}
+
+// setCurrentParseResult marks a parse result as the current one of its file and copies it to the parsed file
+func setCurrentParseResult(ctx context.Context, tx pgx.Tx, fileId int, resultId int) error {
+	// Clear the previous current result first, the unique index allows a single current result per file
+	query := `UPDATE parse_results SET is_current = FALSE WHERE file_id = $1 AND is_current AND id <> $2`
+	_, err := tx.Exec(ctx, query, fileId, resultId)
+	if err != nil {
+		if er.HandleDeadlineExceededError(err) != nil {
+			log.Println("Deadline exceeded while updating parse results")
+			return err
+		}
+		log.Printf("Error updating parse results: %v", err)
+		return err
+	}
+
+	query = `
+	WITH selected AS (
+		UPDATE parse_results SET is_current = TRUE WHERE id = $1 AND file_id = $2 RETURNING parsed_file
+	)
+	UPDATE files SET parsed_file = selected.parsed_file FROM selected WHERE files.id = $2
+	`
+	tag, err := tx.Exec(ctx, query, resultId, fileId)
+	if err != nil {
+		if er.HandleDeadlineExceededError(err) != nil {
+			log.Println("Deadline exceeded while storing parsed file")
+			return err
+		}
+		log.Printf("Error storing parsed file: %v", err)
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return er.ErrParseResultNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,304 @@
+package service
+
+import (
+	er "PDFStoring/error"
+	"PDFStoring/models"
+	"PDFStoring/parser"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// parseWorkerRetryDelay is how long a parse worker waits before claiming again after the queue refused it
+	parseWorkerRetryDelay = 5 * time.Second
+	// progressReportInterval is how often at most a parse worker reports how far it got with a file
+	progressReportInterval = time.Second
+)
+
+// ParseWorkerConfig holds the settings of the parse workers running inside the API process
+type ParseWorkerConfig struct {
+	// Workers is how many files are parsed at the same time, 0 disables the in-process workers
+	Workers int
+	// PollWait is how long a worker waits for a file to be queued before claiming again
+	PollWait time.Duration
+	// HeartbeatInterval is how often the workers report that they are alive, it must be shorter than the
+	// worker heartbeat timeout of the queue
+	HeartbeatInterval time.Duration
+}
+
+// DefaultParseWorkerConfig returns the parse worker settings used when nothing else is configured
+func DefaultParseWorkerConfig() ParseWorkerConfig {
+	return ParseWorkerConfig{
+		Workers:           0,
+		PollWait:          30 * time.Second,
+		HeartbeatInterval: 15 * time.Second,
+	}
+}
+
+// parseWorkers claims files from the queue and parses them with the built-in parser. They share one
+// registered worker, so the files they hold can be listed and cancelled like those of an external worker.
+type parseWorkers struct {
+	queueService  QueueService
+	workerService WorkerService
+	config        ParseWorkerConfig
+	workerId      int
+
+	mu sync.Mutex
+	// running holds the cancel function of every file being parsed, by file id
+	running map[int]context.CancelFunc
+}
+
+// StartParseWorkers registers the built-in parser as a worker and runs the configured number of parse workers
+// in the background until the given context is cancelled. Nothing is started when no workers are configured.
+func StartParseWorkers(ctx context.Context, queueService QueueService, workerService WorkerService, config ParseWorkerConfig) error {
+	if config.Workers <= 0 {
+		return nil
+	}
+
+	workerId, err := workerService.RegisterWorker(ctx, models.Worker{
+		Name:         parser.EngineName,
+		Version:      parser.Version,
+		Capabilities: []string{},
+	})
+	if err != nil {
+		return err
+	}
+
+	w := &parseWorkers{
+		queueService:  queueService,
+		workerService: workerService,
+		config:        config,
+		workerId:      workerId,
+		running:       make(map[int]context.CancelFunc),
+	}
+
+	go w.heartbeat(ctx)
+	for i := 0; i < config.Workers; i++ {
+		go w.claim(ctx)
+	}
+
+	log.Printf("Started %d parse workers", config.Workers)
+	return nil
+}
+
+// heartbeat keeps the worker alive and stops parsing the files cancelled in the meantime
+func (w *parseWorkers) heartbeat(ctx context.Context) {
+	ticker := time.NewTicker(w.config.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("Parse workers stopped")
+			return
+		case <-ticker.C:
+			heartbeat, err := w.workerService.Heartbeat(ctx, w.workerId)
+			if err != nil {
+				log.Printf("Error sending parse worker heartbeat: %v", err)
+				continue
+			}
+
+			w.mu.Lock()
+			for _, fileId := range heartbeat.CancelledJobs {
+				if cancel, ok := w.running[fileId]; ok {
+					log.Println("Parsing cancelled for file:", fileId)
+					cancel()
+				}
+			}
+			w.mu.Unlock()
+		}
+	}
+}
+
+// claim parses one file after the other until the given context is cancelled
+func (w *parseWorkers) claim(ctx context.Context) {
+	options := ClaimOptions{WorkerID: w.workerId}
+	for ctx.Err() == nil {
+		jobs, err := w.queueService.WaitForFiles(ctx, options, 1, w.config.PollWait)
+		if errors.Is(err, er.ErrQueueEmpty) {
+			continue
+		}
+		if err != nil {
+			if !errors.Is(err, er.ErrQueuePaused) && ctx.Err() == nil {
+				log.Printf("Error claiming file for parsing: %v", err)
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(parseWorkerRetryDelay):
+			}
+			continue
+		}
+
+		for _, job := range jobs {
+			w.parse(ctx, job)
+		}
+	}
+}
+
+// parse parses the PDF of a job and reports the result under its lease. A file whose parsing is cancelled, or
+// whose lease is lost, is given up without a result.
+func (w *parseWorkers) parse(ctx context.Context, job models.Job) {
+	jobCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if job.ParseDeadline != nil {
+		var cancelDeadline context.CancelFunc
+		jobCtx, cancelDeadline = context.WithDeadline(jobCtx, *job.ParseDeadline)
+		defer cancelDeadline()
+	}
+
+	w.mu.Lock()
+	w.running[job.FileID] = cancel
+	w.mu.Unlock()
+	defer func() {
+		w.mu.Lock()
+		delete(w.running, job.FileID)
+		w.mu.Unlock()
+	}()
+
+	go w.keepLease(jobCtx, cancel, job)
+
+	result, err := w.extract(jobCtx, cancel, job)
+
+	parsedData := models.Parser{
+		LeaseToken:    job.LeaseToken,
+		Attempt:       job.Attempt,
+		ParserName:    parser.EngineName,
+		ParserVersion: parser.Version,
+	}
+	switch {
+	case ctx.Err() != nil:
+		// The server is stopping, the lease expires and the file is parsed again
+		return
+	case errors.Is(jobCtx.Err(), context.DeadlineExceeded):
+		parsedData.ParsedStatus = string(LimitExceeded)
+		parsedData.ParsedError = "Parse time limit exceeded"
+	case errors.Is(err, parser.ErrTooManyPages):
+		parsedData.ParsedStatus = string(LimitExceeded)
+		parsedData.ParsedError = "Page limit exceeded"
+	case jobCtx.Err() != nil:
+		log.Println("Stopped parsing file:", job.FileID)
+		return
+	case err != nil:
+		log.Printf("Error parsing file %d: %v", job.FileID, err)
+		parsedData.ParsedError = err.Error()
+	default:
+		parsedData.ParsedFile = result.Text()
+		parsedData.ParsedStatus = string(Success)
+		parsedData.Metadata = &models.DocumentMetadata{
+			Title:      result.Metadata.Title,
+			Author:     result.Metadata.Author,
+			Producer:   result.Metadata.Producer,
+			CreatedAt:  result.Metadata.Created,
+			ModifiedAt: result.Metadata.Modified,
+			PDFVersion: result.Version,
+			PageCount:  len(result.Pages),
+		}
+		parsedData.Outline = outlineItems(result.Outline)
+		for _, field := range result.Fields {
+			parsedData.Fields = append(parsedData.Fields, models.FormField{
+				Name:     field.Name,
+				Type:     field.Type,
+				Value:    field.Value,
+				Selected: field.Selected,
+				Options:  field.Options,
+				Page:     field.Page,
+			})
+		}
+		for _, page := range result.Pages {
+			parsedData.Pages = append(parsedData.Pages, models.ParsedPage{
+				Number:   page.Number,
+				Width:    page.Width,
+				Height:   page.Height,
+				Rotation: page.Rotation,
+				Text:     page.Text,
+			})
+		}
+	}
+
+	err = w.queueService.UploadParsedFile(ctx, job.FileID, parsedData)
+	if err != nil && !errors.Is(err, er.ErrLimitExceeded) {
+		log.Printf("Error submitting result of file %d: %v", job.FileID, err)
+	}
+}
+
+// extract reads the PDF of a job from the blob store and extracts its text, reporting progress as it goes
+func (w *parseWorkers) extract(ctx context.Context, cancel context.CancelFunc, job models.Job) (*parser.Result, error) {
+	blob, err := w.queueService.ReadJobFile(ctx, job, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(blob)
+	blob.Close()
+	if err != nil {
+		log.Printf("Error reading file %d: %v", job.FileID, err)
+		return nil, err
+	}
+
+	var lastReport time.Time
+	progress := func(pagesProcessed, totalPages int) {
+		if pagesProcessed < totalPages && time.Since(lastReport) < progressReportInterval {
+			return
+		}
+		lastReport = time.Now()
+
+		err := w.queueService.ReportProgress(ctx, job.FileID, models.ProgressReport{
+			LeaseToken:     job.LeaseToken,
+			PagesProcessed: pagesProcessed,
+			TotalPages:     totalPages,
+		})
+		if leaseLost(err) {
+			cancel()
+		}
+	}
+
+	return parser.Parse(ctx, data, parser.Options{MaxPages: job.MaxPages, Progress: progress})
+}
+
+// keepLease extends the lease of a job halfway through it until the job is done, and stops the job when its
+// lease is lost
+func (w *parseWorkers) keepLease(ctx context.Context, cancel context.CancelFunc, job models.Job) {
+	expiry := job.LeaseExpiry
+	for {
+		wait := max(time.Until(expiry)/2, time.Second)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		leasedUntil, err := w.queueService.ExtendLease(ctx, job.FileID, job.LeaseToken)
+		if leaseLost(err) {
+			log.Println("Lease lost while parsing file:", job.FileID)
+			cancel()
+			return
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Error extending lease of file %d: %v", job.FileID, err)
+			}
+			continue
+		}
+		expiry = leasedUntil
+	}
+}
+
+// leaseLost reports whether an error means the file is no longer leased to the worker
+func leaseLost(err error) bool {
+	return errors.Is(err, er.ErrNotLeased) || errors.Is(err, er.ErrJobCancelled) || errors.Is(err, er.ErrLimitExceeded)
+}
+
+// outlineItems converts the outline found by the parser to the items of a parse result
+func outlineItems(items []parser.OutlineItem) []models.OutlineItem {
+	if items == nil {
+		return nil
+	}
+	converted := make([]models.OutlineItem, len(items))
+	for i, item := range items {
+		converted[i] = models.OutlineItem{Title: item.Title, Page: item.Page, Children: outlineItems(item.Children)}
+	}
+	return converted
+}
@@ -0,0 +1,81 @@
+package service
+
+import (
+	"bytes"
+	"regexp"
+)
+
+// CapabilityOCR is required by files that look scanned, they have images but no text to extract
+const CapabilityOCR = "ocr"
+
+var (
+	pageObject   = regexp.MustCompile(`/Type\s*/Page\b`)
+	imageXObject = regexp.MustCompile(`/Subtype\s*/Image\b`)
+)
+
+// prescanOverlap is how many bytes of a chunk are scanned again with the next one, so markers split between
+// two chunks are found
+const prescanOverlap = 64
+
+// prescan inspects a PDF while it is streamed, as an io.Writer, for its page count and the worker capabilities
+// it needs. It only looks at the raw bytes, so it learns nothing from objects compressed in object streams.
+type prescan struct {
+	tail   []byte
+	offset int64
+
+	pages         int
+	lastPageEnd   int64
+	objectStreams bool
+	fonts         bool
+	images        bool
+}
+
+func (p *prescan) Write(chunk []byte) (int, error) {
+	buf := make([]byte, 0, len(p.tail)+len(chunk))
+	buf = append(append(buf, p.tail...), chunk...)
+	p.scan(buf, false)
+
+	keep := min(len(buf), prescanOverlap)
+	p.offset += int64(len(buf) - keep)
+	p.tail = buf[len(buf)-keep:]
+	return len(chunk), nil
+}
+
+// Close scans the end of the file, which was held back in case it continued in a further chunk
+func (p *prescan) Close() error {
+	p.scan(p.tail, true)
+	return nil
+}
+
+func (p *prescan) scan(buf []byte, final bool) {
+	p.objectStreams = p.objectStreams || bytes.Contains(buf, []byte("/ObjStm"))
+	p.fonts = p.fonts || bytes.Contains(buf, []byte("/Font"))
+	p.images = p.images || imageXObject.Match(buf)
+
+	// A match ending the buffer may be a longer name such as /Pages, it is counted once the next chunk tells
+	for _, match := range pageObject.FindAllIndex(buf, -1) {
+		end := p.offset + int64(match[1])
+		if (!final && match[1] == len(buf)) || end <= p.lastPageEnd {
+			continue
+		}
+		p.pages++
+		p.lastPageEnd = end
+	}
+}
+
+// pageCount returns the number of page objects, or 0 when the pages may be hidden in object streams
+func (p *prescan) pageCount() int {
+	if p.objectStreams {
+		return 0
+	}
+	return p.pages
+}
+
+// capabilities returns the worker capabilities the file needs
+func (p *prescan) capabilities() []string {
+	var capabilities []string
+	if !p.objectStreams && !p.fonts && p.images {
+		capabilities = append(capabilities, CapabilityOCR)
+	}
+	return capabilities
+}
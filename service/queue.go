@@ -4,55 +4,155 @@ import (
 	"PDFStoring/database"
 	er "PDFStoring/error"
 	"PDFStoring/models"
+	"PDFStoring/queue"
+	"PDFStoring/storage"
 	"context"
-	"encoding/hex"
 	"errors"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
 	"io"
 	"log"
-	"mime/multipart"
 	"time"
 )
 
+// queueChannel is the channel notified through LISTEN/NOTIFY whenever a file is added to the queue
+const queueChannel = "queue_files"
+
+// queuePollInterval bounds how long a waiting worker relies on notifications alone, since scheduled files
+// and files coming out of a retry backoff become due without one
+const queuePollInterval = 5 * time.Second
+
 type QueueServiceStruct struct {
 	dbService database.DatabaseService
+	backend   queue.Backend
+	blobStore storage.BlobStore
+	config    QueueConfig
+	listener  *queueListener
+}
+
+// QueueConfig holds the tunable settings of the parse queue
+type QueueConfig struct {
+	// Config holds the settings of the queue backend, deciding how files are leased and retried
+	queue.Config
+	// ReaperInterval is how often expired leases are looked for and put back in the queue
+	ReaperInterval time.Duration
+	// WorkerHeartbeatTimeout is how long a registered worker can go without a heartbeat before its leases
+	// are released back to the queue
+	WorkerHeartbeatTimeout time.Duration
+	// SubmissionRetention is how long the submitted results are remembered, so a worker resubmitting the same
+	// result in that time is told it was recorded
+	SubmissionRetention time.Duration
+	// Limits are the default limits of a job, users with their own limits override them
+	Limits Limits
+}
+
+// DefaultQueueConfig returns the queue settings used when nothing else is configured
+func DefaultQueueConfig() QueueConfig {
+	return QueueConfig{
+		Config: queue.Config{
+			LeaseDuration:         5 * time.Minute,
+			MaxAttempts:           3,
+			RetryBaseDelay:        30 * time.Second,
+			RetryMaxDelay:         30 * time.Minute,
+			PriorityAgingInterval: 10 * time.Minute,
+		},
+		ReaperInterval: 30 * time.Second,
+
+		WorkerHeartbeatTimeout: time.Minute,
+		SubmissionRetention:    24 * time.Hour,
+		Limits:                 DefaultLimits(),
+	}
+}
+
+// EnqueueOptions describes how a file is scheduled in the queue
+type EnqueueOptions struct {
+	// UserID is the user who uploaded the file, used to share the workers fairly between users
+	UserID int
+	// Priority is the queue lane the file waits in
+	Priority Priority
+	// NotBefore delays parsing until the given time, nil for as soon as possible
+	NotBefore *time.Time
+	// RequiredCapabilities are the capabilities a worker needs to be handed the file
+	RequiredCapabilities []string
+}
+
+// ClaimOptions describes the worker claiming a file from the queue
+type ClaimOptions struct {
+	// WorkerID is the registered worker the lease is held by, or 0 for an anonymous worker
+	WorkerID int
+	// Capabilities limits the claim to files the caller can handle. When nil, the capabilities the worker
+	// registered with are used, and an anonymous worker is only handed files without requirements.
+	Capabilities []string
 }
 
 // QueueService interface defines methods for user-related operations
 type QueueService interface {
-	AddFileToQueue(ctx context.Context, fileId int, file multipart.File) error
-	GetNextFile(ctx context.Context) (int, []byte, error)
+	AddFileToQueue(ctx context.Context, fileId int, blobKey string, options EnqueueOptions) error
+	GetNextFile(ctx context.Context, options ClaimOptions) (*models.Job, error)
+	ClaimFiles(ctx context.Context, options ClaimOptions, limit int) ([]models.Job, error)
+	WaitForFiles(ctx context.Context, options ClaimOptions, limit int, wait time.Duration) ([]models.Job, error)
+	StartListener(ctx context.Context)
 	UploadParsedFile(ctx context.Context, fileId int, parsedData models.Parser) error
+	UploadParsedFiles(ctx context.Context, results []models.BatchResult) []models.BatchOutcome
+	ReportProgress(ctx context.Context, fileId int, report models.ProgressReport) error
+	ExtendLease(ctx context.Context, fileId int, leaseToken string) (time.Time, error)
+	GetLeasedFile(ctx context.Context, fileId int, leaseToken string) (*models.Job, error)
+	ReadJobFile(ctx context.Context, job models.Job, offset int64, length int64) (io.ReadCloser, error)
+	RequeueExpiredLeases(ctx context.Context) (int, error)
+	ReleaseDeadWorkerLeases(ctx context.Context) (int, error)
+	ExpireParseBudgets(ctx context.Context) (int, error)
+	PruneSubmissions(ctx context.Context) (int, error)
+	GetDeadLetters(ctx context.Context) ([]models.DeadLetter, error)
+	RequeueDeadLetter(ctx context.Context, fileId int) error
+	GetLaneDepths(ctx context.Context) ([]models.LaneDepth, error)
+	GetQueueStats(ctx context.Context, window time.Duration) (*models.QueueStats, error)
+	GetQueueControl(ctx context.Context) (*models.QueueControl, error)
+	SetQueueState(ctx context.Context, state QueueState) (*models.QueueControl, error)
 }
 
-// NewQueueService creates a new instance of QueueServiceStruct, implementing QueueService
-func NewQueueService(dbService database.DatabaseService) QueueService {
+// NewQueueService creates a new instance of QueueServiceStruct, implementing QueueService. The files are queued
+// in the given backend, configured with the same settings.
+func NewQueueService(dbService database.DatabaseService, backend queue.Backend, blobStore storage.BlobStore, config QueueConfig) QueueService {
 	return &QueueServiceStruct{
 		dbService: dbService,
+		backend:   backend,
+		blobStore: blobStore,
+		config:    config,
+		listener:  newQueueListener(),
 	}
 }
 
-func (s *QueueServiceStruct) AddFileToQueue(ctx context.Context, fileId int, file multipart.File) error {
+// StartListener listens in the background for files being queued and wakes up the callers of WaitForFiles,
+// until the given context is cancelled. Without it the waiting callers look at the queue at every poll interval.
+func (s *QueueServiceStruct) StartListener(ctx context.Context) {
+	go s.listener.listen(ctx, s.dbService.GetPool())
+}
+
+// AddFileToQueue queues the file, already in the blob store under the given key, in the queue lane of the given
+// priority on behalf of the uploading user
+func (s *QueueServiceStruct) AddFileToQueue(ctx context.Context, fileId int, blobKey string, options EnqueueOptions) error {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	fileData, err := io.ReadAll(file)
+	tx, err := queue.Begin(ctx, s.dbService.GetPool())
 	if err != nil {
-		if err == er.HandleDeadlineExceededError(err) {
-			log.Println("Deadline exceeded while reading file data")
+		if er.HandleDeadlineExceededError(err) != nil {
+			log.Println("Deadline exceeded while starting queue transaction")
 			return err
 		}
-		log.Printf("Error reading file data: %v", err)
+		log.Printf("Error starting queue transaction: %v", err)
 		return err
 	}
+	defer tx.Rollback(ctx)
 
-	query := `INSERT INTO queue (file_id, pdf_file) VALUES ($1, $2)`
-	_, err = s.dbService.GetPool().Query(ctx, query, fileId, fileData)
+	err = enqueueFile(ctx, tx, s.backend, fileId, blobKey, options, s.config.Limits)
 	if err != nil {
-		if err == er.HandleDeadlineExceededError(err) {
-			log.Println("Deadline exceeded while adding file to queue")
-			return err
-		}
-		log.Printf("Error adding file to queue: %v", err)
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Printf("Error committing queue transaction: %v", err)
 		return err
 	}
 
@@ -60,86 +160,617 @@ func (s *QueueServiceStruct) AddFileToQueue(ctx context.Context, fileId int, fil
 	return nil
 }
 
-func (s *QueueServiceStruct) GetNextFile(ctx context.Context) (int, []byte, error) {
+// enqueueFile queues a file within the transaction, with the limits its user has at this time, and wakes up the
+// waiting workers once the transaction commits
+func enqueueFile(ctx context.Context, tx *queue.Tx, backend queue.Backend, fileId int, blobKey string, options EnqueueOptions, defaults Limits) error {
+	limits, err := userLimits(ctx, tx, options.UserID, defaults)
+	if err != nil {
+		return err
+	}
+
+	var fileSize int64
+	query := `SELECT COALESCE(file_size, 0) FROM files WHERE id = $1`
+	err = tx.QueryRow(ctx, query, fileId).Scan(&fileSize)
+	if err != nil {
+		if er.HandleDeadlineExceededError(err) != nil {
+			log.Println("Deadline exceeded while fetching file size")
+			return err
+		}
+		log.Printf("Error fetching file size: %v", err)
+		return err
+	}
+
+	entry := models.Queue{
+		FileID:               fileId,
+		BlobKey:              blobKey,
+		FileSize:             fileSize,
+		Priority:             string(options.Priority),
+		NotBefore:            options.NotBefore,
+		RequiredCapabilities: NormalizeCapabilities(options.RequiredCapabilities),
+		MaxPages:             limits.MaxPages,
+		MaxParseSeconds:      limits.MaxParseTime.Seconds(),
+	}
+	if options.UserID != 0 {
+		entry.UserID = &options.UserID
+	}
+
+	err = backend.Enqueue(queue.WithTx(ctx, tx), entry)
+	if err != nil {
+		return err
+	}
+
+	err = setFileStatus(ctx, tx, fileId, InQueue, options.Priority)
+	if err != nil {
+		return err
+	}
+
+	return notifyQueue(ctx, tx)
+}
+
+// GetNextFile claims the next unleased file in the queue, serving the higher priority lanes first and sharing
+// each lane round-robin between users. The file stays in the queue marked as leased until the worker reports
+// a result through UploadParsedFile.
+func (s *QueueServiceStruct) GetNextFile(ctx context.Context, options ClaimOptions) (*models.Job, error) {
+	jobs, err := s.ClaimFiles(ctx, options, 1)
+	if err != nil {
+		return nil, err
+	}
+	return &jobs[0], nil
+}
+
+// ClaimFiles claims up to limit files in a single transaction, so the whole batch is leased or none of it is.
+// It fails with ErrQueueEmpty when no file could be claimed, and with ErrQueuePaused while the queue is paused
+// or draining.
+func (s *QueueServiceStruct) ClaimFiles(ctx context.Context, options ClaimOptions, limit int) ([]models.Job, error) {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	query := `SELECT file_id, pdf_file FROM queue ORDER BY id LIMIT 1`
+	tx, err := queue.Begin(ctx, s.dbService.GetPool())
+	if err != nil {
+		if er.HandleDeadlineExceededError(err) != nil {
+			log.Println("Deadline exceeded while starting queue transaction")
+			return nil, err
+		}
+		log.Printf("Error starting queue transaction: %v", err)
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
 
-	var fileId int
-	var hasedFile string
-	err := s.dbService.GetPool().QueryRow(ctx, query).Scan(&fileId, &hasedFile)
+	if options.WorkerID != 0 {
+		err = touchWorker(ctx, tx, options.WorkerID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	state, err := queueState(ctx, tx)
 	if err != nil {
-		if err == er.HandleDeadlineExceededError(err) {
-			log.Println("Deadline exceeded while getting next file from queue")
-			return 0, nil, err
+		return nil, err
+	}
+
+	request := queue.ClaimRequest{WorkerID: options.WorkerID, Capabilities: options.Capabilities}
+	if request.Capabilities == nil {
+		request.Capabilities, err = workerCapabilities(ctx, tx, options.WorkerID)
+		if err != nil {
+			return nil, err
 		}
-		log.Printf("Error getting next file from queue: %v", err)
-		return 0, nil, err
 	}
 
-	if fileId == 0 {
-		return 0, nil, errors.New("File does not exist")
+	var jobs []models.Job
+	for state == QueueRunning && len(jobs) < limit {
+		job, err := s.claimFile(ctx, tx, request)
+		if errors.Is(err, er.ErrQueueEmpty) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, *job)
 	}
 
-	fileData, err := hex.DecodeString(hasedFile)
+	// Commit even when nothing was claimed, so polling still counts as a sign of life of the worker
+	err = tx.Commit(ctx)
 	if err != nil {
-		log.Printf("Error decoding file data: %v", err)
-		return 0, nil, err
+		log.Printf("Error committing queue transaction: %v", err)
+		return nil, err
+	}
+
+	if state != QueueRunning {
+		return nil, er.ErrQueuePaused
+	}
+	if len(jobs) == 0 {
+		return nil, er.ErrQueueEmpty
 	}
 
-	query = `DELETE FROM queue WHERE file_id = $1`
-	_, err = s.dbService.GetPool().Query(ctx, query, fileId)
+	for _, job := range jobs {
+		log.Println("File leased from queue:", job.FileID)
+	}
+	return jobs, nil
+}
+
+// claimFile leases the next claimable file within the transaction
+func (s *QueueServiceStruct) claimFile(ctx context.Context, tx *queue.Tx, request queue.ClaimRequest) (*models.Job, error) {
+	entry, err := s.backend.Claim(queue.WithTx(ctx, tx), request)
 	if err != nil {
-		if err == er.HandleDeadlineExceededError(err) {
-			log.Println("Deadline exceeded while deleting file from queue")
-			return 0, nil, err
-		}
-		log.Printf("Error deleting file from queue: %v", err)
-		return 0, nil, err
+		return nil, err
 	}
 
-	query = `UPDATE files SET status = $1 WHERE id = $2`
-	_, err = s.dbService.GetPool().Query(ctx, query, Parsing, fileId)
+	err = setFileStatus(ctx, tx, entry.FileID, Parsing, Priority(entry.Priority))
 	if err != nil {
-		if err == er.HandleDeadlineExceededError(err) {
-			log.Println("Deadline exceeded while updating file status")
-			return 0, nil, err
-		}
-		log.Printf("Error updating file status: %v", err)
-		return 0, nil, err
+		return nil, err
 	}
 
-	return fileId, fileData, nil
+	return newJob(*entry), nil
+}
+
+// newJob describes a leased file to the worker holding its lease
+func newJob(entry models.Queue) *models.Job {
+	job := &models.Job{
+		FileID:     entry.FileID,
+		LeaseToken: entry.LeaseToken,
+		Attempt:    entry.Attempts,
+		// The PDFs are stored under their hash
+		FileHash:             entry.BlobKey,
+		Priority:             entry.Priority,
+		RequiredCapabilities: entry.RequiredCapabilities,
+		MaxPages:             entry.MaxPages,
+		ParseDeadline:        entry.ParseDeadline,
+		FileSize:             entry.FileSize,
+		BlobKey:              entry.BlobKey,
+	}
+	if entry.LeasedUntil != nil {
+		job.LeaseExpiry = *entry.LeasedUntil
+	}
+	return job
 }
 
+// WaitForFiles claims up to limit files like ClaimFiles, but when the queue is empty it waits for the queue
+// listener to announce newly queued files for up to the given wait before giving up with ErrQueueEmpty. No
+// connection is held while waiting.
+func (s *QueueServiceStruct) WaitForFiles(ctx context.Context, options ClaimOptions, limit int, wait time.Duration) ([]models.Job, error) {
+	ctx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+
+	for {
+		// Take the notification channel before claiming, so a file queued during the claim is not missed
+		notified := s.listener.wait()
+
+		// Jobs claimed just as the wait runs out are still leased, so they are returned whatever the deadline
+		jobs, err := s.ClaimFiles(ctx, options, limit)
+		if err == nil {
+			return jobs, nil
+		}
+		if ctx.Err() != nil {
+			return nil, er.ErrQueueEmpty
+		}
+		if !errors.Is(err, er.ErrQueueEmpty) {
+			return nil, err
+		}
+
+		poll := time.NewTimer(queuePollInterval)
+		select {
+		case <-ctx.Done():
+			poll.Stop()
+			return nil, er.ErrQueueEmpty
+		case <-notified:
+		case <-poll.C:
+		}
+		poll.Stop()
+	}
+}
+
+// UploadParsedFile stores the result reported by a worker. A successful parse removes the file from the queue,
+// while a failed one is retried with backoff until the attempts run out and the file is dead-lettered. Only
+// the holder of the current lease can report a result, and reporting the same result again under that lease
+// succeeds without changing anything.
 func (s *QueueServiceStruct) UploadParsedFile(ctx context.Context, fileId int, parsedData models.Parser) error {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	if parsedData.ParsedError != "" {
-		query := `UPDATE files SET status = $1, parsed_file = $2 WHERE id = $3`
-		_, err := s.dbService.GetPool().Query(ctx, query, Error, parsedData, fileId)
+	if parsedData.LeaseToken == "" || parsedData.Attempt == 0 {
+		return er.ErrLeaseTokenRequired
+	}
+	if !validPages(parsedData.Pages) {
+		return er.ErrInvalidPages
+	}
+	if !validMetadata(parsedData.Metadata) {
+		return er.ErrInvalidMetadata
+	}
+	if !validOutline(parsedData.Outline) {
+		return er.ErrInvalidOutline
+	}
+	if !validFormFields(parsedData.Fields) {
+		return er.ErrInvalidFormFields
+	}
+	if parsedData.ParsedFile == "" && len(parsedData.Pages) > 0 {
+		parsedData.ParsedFile = pagesText(parsedData.Pages)
+	}
+
+	tx, err := queue.Begin(ctx, s.dbService.GetPool())
+	if err != nil {
+		if er.HandleDeadlineExceededError(err) != nil {
+			log.Println("Deadline exceeded while starting queue transaction")
+			return err
+		}
+		log.Printf("Error starting queue transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback(ctx)
+	txCtx := queue.WithTx(ctx, tx)
+
+	// Lock the queued file before looking for an earlier submission, so a duplicate sent while the first one
+	// is stored waits for it and is then recognized
+	entry, err := s.backend.Get(txCtx, fileId)
+	if err != nil && !errors.Is(err, er.ErrNotQueued) {
+		return err
+	}
+
+	resultHash := hashResult(parsedData)
+	submitted, err := checkSubmission(ctx, tx, fileId, parsedData, resultHash)
+	if err != nil {
+		return err
+	}
+	if submitted {
+		log.Printf("Result for file %d was already submitted with this lease", fileId)
+		return nil
+	}
+
+	err = checkLease(ctx, tx, fileId, entry, parsedData)
+	if err != nil {
+		return err
+	}
+
+	if entry.ParseDeadline != nil && entry.ParseDeadline.Before(time.Now()) {
+		// The result came in too late, it is dropped like the reaper would have dropped the job
+		err = exceedLimit(ctx, tx, *entry, "Parse time limit exceeded")
+		if err != nil {
+			return err
+		}
+
+		_, err = s.backend.Remove(txCtx, fileId)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Commit(ctx)
+		if err != nil {
+			log.Printf("Error committing queue transaction: %v", err)
+			return err
+		}
+		return er.ErrLimitExceeded
+	}
+
+	query := `INSERT INTO result_submissions (lease_token, file_id, attempt, result_hash) VALUES ($1, $2, $3, $4)`
+	_, err = tx.Exec(ctx, query, parsedData.LeaseToken, fileId, parsedData.Attempt, resultHash)
+	if err != nil {
+		if er.HandleDeadlineExceededError(err) != nil {
+			log.Println("Deadline exceeded while recording result submission")
+			return err
+		}
+		log.Printf("Error recording result submission: %v", err)
+		return err
+	}
+
+	if parsedData.ParsedStatus == string(LimitExceeded) {
+		err = exceedLimit(ctx, tx, *entry, parsedData.ParsedError)
+		if err != nil {
+			return err
+		}
+
+		_, err = s.backend.Remove(txCtx, fileId)
+		if err != nil {
+			return err
+		}
+	} else if parsedData.ParsedError != "" {
+		err = s.failAttempt(ctx, tx, fileId, entry.LeaseToken, parsedData.ParsedError)
+		if err != nil {
+			return err
+		}
+	} else {
+		err = setFileStatus(ctx, tx, fileId, Success, Priority(entry.Priority))
+		if err != nil {
+			return err
+		}
+
+		err = recordParseResult(ctx, tx, fileId, entry.WorkerID, parsedData)
 		if err != nil {
-			if err == er.HandleDeadlineExceededError(err) {
-				log.Println("Deadline exceeded while updating file status")
-				return err
-			}
-			log.Printf("Error updating file status: %v", err)
 			return err
 		}
+
+		_, err = s.backend.Ack(txCtx, fileId, entry.LeaseToken)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Printf("Error committing queue transaction: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// UploadParsedFiles stores a batch of results reported by a worker. Every result is stored on its own, so a
+// rejected result does not affect the rest of the batch.
+func (s *QueueServiceStruct) UploadParsedFiles(ctx context.Context, results []models.BatchResult) []models.BatchOutcome {
+	outcomes := make([]models.BatchOutcome, len(results))
+	for i, result := range results {
+		outcomes[i].FileID = result.FileID
+		err := s.UploadParsedFile(ctx, result.FileID, result.Parser)
+		if err != nil {
+			outcomes[i].Error = err.Error()
+			continue
+		}
+		outcomes[i].Accepted = true
+	}
+	return outcomes
+}
+
+// ReportProgress records how far the worker holding the lease of the file got with it
+func (s *QueueServiceStruct) ReportProgress(ctx context.Context, fileId int, report models.ProgressReport) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	err := s.backend.ReportProgress(ctx, fileId, report)
+	if errors.Is(err, er.ErrNotLeased) {
+		log.Println("Progress reported without a valid lease for file:", fileId)
+		return leaseLostError(ctx, s.dbService.GetPool(), fileId)
+	}
+	return err
+}
+
+// ExtendLease keeps a file leased to the worker holding its lease for another lease duration, so a file taking
+// longer to parse than a lease lasts is not handed to another worker, and returns the new expiry
+func (s *QueueServiceStruct) ExtendLease(ctx context.Context, fileId int, leaseToken string) (time.Time, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	leasedUntil, err := s.backend.ExtendLease(ctx, fileId, leaseToken, s.config.LeaseDuration)
+	if errors.Is(err, er.ErrNotLeased) {
+		log.Println("Lease extension requested without a valid lease for file:", fileId)
+		return time.Time{}, leaseLostError(ctx, s.dbService.GetPool(), fileId)
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return leasedUntil, nil
+}
+
+// GetLeasedFile returns a leased job to the worker holding its lease, so it can download the PDF again
+func (s *QueueServiceStruct) GetLeasedFile(ctx context.Context, fileId int, leaseToken string) (*models.Job, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	entry, err := s.backend.Get(ctx, fileId)
+	if err != nil && !errors.Is(err, er.ErrNotQueued) {
+		return nil, err
+	}
+	if entry == nil || entry.LeaseToken == "" || entry.LeaseToken != leaseToken {
+		log.Println("File is not leased:", fileId)
+		return nil, leaseLostError(ctx, s.dbService.GetPool(), fileId)
+	}
+
+	return newJob(*entry), nil
+}
+
+// ReadJobFile streams length bytes of the PDF of a job starting at offset, or the rest of it when length is
+// negative. The stream outlives the call, so the context must stay valid until it is read.
+func (s *QueueServiceStruct) ReadJobFile(ctx context.Context, job models.Job, offset int64, length int64) (io.ReadCloser, error) {
+	blob, err := s.blobStore.Get(ctx, job.BlobKey, offset, length)
+	if err != nil {
+		log.Printf("Error reading file %d from the blob store: %v", job.FileID, err)
+		return nil, err
+	}
+	return blob, nil
+}
+
+// RequeueExpiredLeases releases every lease that is past its visibility timeout and counts it as a failed
+// attempt, returning how many files were put back in the queue or dead-lettered
+func (s *QueueServiceStruct) RequeueExpiredLeases(ctx context.Context) (int, error) {
+	return s.releaseLeases(ctx, func(ctx context.Context) ([]queue.Failure, error) {
+		return s.backend.ExpireLeases(ctx, "Lease expired")
+	})
+}
+
+// ReleaseDeadWorkerLeases releases the leases held by workers that stopped sending heartbeats and counts them
+// as failed attempts, returning how many files were put back in the queue or dead-lettered
+func (s *QueueServiceStruct) ReleaseDeadWorkerLeases(ctx context.Context) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	leased, err := s.backend.Leased(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var leaseHolders []int
+	for _, entry := range leased {
+		if entry.WorkerID != nil {
+			leaseHolders = append(leaseHolders, *entry.WorkerID)
+		}
+	}
+	if len(leaseHolders) == 0 {
+		return 0, nil
+	}
+
+	query := `SELECT id FROM workers WHERE id = ANY($1) AND last_seen < NOW() - make_interval(secs => $2)`
+	rows, err := s.dbService.GetPool().Query(ctx, query, leaseHolders, s.config.WorkerHeartbeatTimeout.Seconds())
+	if err != nil {
+		if er.HandleDeadlineExceededError(err) != nil {
+			log.Println("Deadline exceeded while looking for dead workers")
+			return 0, err
+		}
+		log.Printf("Error looking for dead workers: %v", err)
+		return 0, err
+	}
+
+	var deadWorkers []int
+	for rows.Next() {
+		var workerId int
+		err = rows.Scan(&workerId)
+		if err != nil {
+			rows.Close()
+			log.Printf("Error scanning dead workers: %v", err)
+			return 0, err
+		}
+		deadWorkers = append(deadWorkers, workerId)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		log.Printf("Error iterating over rows: %v", err)
+		return 0, err
+	}
+	if len(deadWorkers) == 0 {
+		return 0, nil
+	}
+
+	return s.releaseLeases(ctx, func(ctx context.Context) ([]queue.Failure, error) {
+		return s.backend.ReleaseWorkers(ctx, deadWorkers, "Worker stopped sending heartbeats")
+	})
+}
+
+// releaseLeases fails the current attempt of the leases released by release within a transaction, and updates
+// the status of their files in the same transaction
+func (s *QueueServiceStruct) releaseLeases(ctx context.Context, release func(ctx context.Context) ([]queue.Failure, error)) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	tx, err := queue.Begin(ctx, s.dbService.GetPool())
+	if err != nil {
+		if er.HandleDeadlineExceededError(err) != nil {
+			log.Println("Deadline exceeded while starting queue transaction")
+			return 0, err
+		}
+		log.Printf("Error starting queue transaction: %v", err)
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	failures, err := release(queue.WithTx(ctx, tx))
+	if err != nil {
+		return 0, err
+	}
+
+	for _, failure := range failures {
+		log.Printf("%s for file %d after %d attempts", failure.Entry.LastError, failure.Entry.FileID, failure.Entry.Attempts)
+		err = s.recordFailure(ctx, tx, failure)
+		if err != nil {
+			return 0, err
+		}
 	}
 
-	query := `UPDATE files SET status = $1, parsed_file = $2 WHERE id = $3`
-	_, err := s.dbService.GetPool().Query(ctx, query, parsedData.ParsedStatus, parsedData, fileId)
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Printf("Error committing queue transaction: %v", err)
+		return 0, err
+	}
+
+	return len(failures), nil
+}
+
+// failAttempt releases the lease of a file whose attempt failed. The file is retried after an exponential
+// backoff, or moved to the dead-letter queue once it has used up all of its attempts.
+func (s *QueueServiceStruct) failAttempt(ctx context.Context, tx *queue.Tx, fileId int, leaseToken string, reason string) error {
+	failure, err := s.backend.Nack(queue.WithTx(ctx, tx), fileId, leaseToken, reason)
 	if err != nil {
-		if err == er.HandleDeadlineExceededError(err) {
-			log.Println("Deadline exceeded while updating file status")
+		return err
+	}
+
+	return s.recordFailure(ctx, tx, *failure)
+}
+
+// recordFailure sets the status of a file after a failed attempt, depending on whether it is retried
+func (s *QueueServiceStruct) recordFailure(ctx context.Context, tx pgx.Tx, failure queue.Failure) error {
+	entry := failure.Entry
+	if failure.DeadLettered {
+		err := setFileStatus(ctx, tx, entry.FileID, Error, Priority(entry.Priority))
+		if err != nil {
 			return err
 		}
-		log.Printf("Error updating file status: %v", err)
+
+		log.Println("File moved to dead-letter queue:", entry.FileID)
+		return nil
+	}
+
+	err := setFileStatus(ctx, tx, entry.FileID, InQueue, Priority(entry.Priority))
+	if err != nil {
 		return err
 	}
 
+	log.Printf("File %d failed attempt %d, retrying in %v", entry.FileID, entry.Attempts, s.config.RetryDelay(entry.Attempts))
 	return nil
 }
+
+// GetLaneDepths returns how many files are waiting in and leased from each priority lane
+func (s *QueueServiceStruct) GetLaneDepths(ctx context.Context) ([]models.LaneDepth, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	stats, err := s.backend.Stats(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	depths := make(map[string]models.LaneDepth)
+	for _, depth := range stats {
+		depths[depth.Priority] = depth
+	}
+
+	// Report every lane, including the empty ones, from the most to the least urgent
+	var laneDepths []models.LaneDepth
+	for _, priority := range Priorities {
+		depth := depths[string(priority)]
+		depth.Priority = string(priority)
+		laneDepths = append(laneDepths, depth)
+	}
+
+	return laneDepths, nil
+}
+
+// executor is satisfied by both the connection pool and a transaction
+type executor interface {
+	Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error)
+}
+
+// querier is satisfied by both the connection pool and a transaction
+type querier interface {
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// notifyQueue wakes up the workers waiting for a file. Inside a transaction the notification is only sent
+// once the transaction commits.
+func notifyQueue(ctx context.Context, db executor) error {
+	_, err := db.Exec(ctx, "SELECT pg_notify($1, '')", queueChannel)
+	if err != nil {
+		if er.HandleDeadlineExceededError(err) != nil {
+			log.Println("Deadline exceeded while notifying queue listeners")
+			return err
+		}
+		log.Printf("Error notifying queue listeners: %v", err)
+		return err
+	}
+	return nil
+}
+
+// leaseLostError explains why a worker no longer holds the lease of a file, telling cancelled files apart so
+// the worker knows to stop working on them
+func leaseLostError(ctx context.Context, db querier, fileId int) error {
+	var status FileStatus
+	query := `SELECT status FROM files WHERE id = $1`
+	err := db.QueryRow(ctx, query, fileId).Scan(&status)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		log.Printf("Error checking file status: %v", err)
+		return err
+	}
+
+	if status == Cancelled {
+		return er.ErrJobCancelled
+	}
+	if status == LimitExceeded {
+		return er.ErrLimitExceeded
+	}
+	return er.ErrNotLeased
+}
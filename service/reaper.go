@@ -0,0 +1,55 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// StartLeaseReaper runs in the background and every interval ends the jobs over their time budget, then
+// requeues the files whose lease has expired or whose worker stopped sending heartbeats, and forgets the result
+// submissions past their retention, until the given context is cancelled
+func StartLeaseReaper(ctx context.Context, queueService QueueService, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				log.Println("Lease reaper stopped")
+				return
+			case <-ticker.C:
+				// Jobs over their budget are ended first, so they are not retried when their lease expired too. A
+				// step that fails does not hold up the others.
+				expired, err := queueService.ExpireParseBudgets(ctx)
+				if err != nil {
+					log.Printf("Error ending jobs over their time budget: %v", err)
+				} else if expired > 0 {
+					log.Printf("Ended %d files over their parse time budget", expired)
+				}
+
+				requeued, err := queueService.RequeueExpiredLeases(ctx)
+				if err != nil {
+					log.Printf("Error requeueing expired leases: %v", err)
+				} else if requeued > 0 {
+					log.Printf("Requeued %d files with expired leases", requeued)
+				}
+
+				released, err := queueService.ReleaseDeadWorkerLeases(ctx)
+				if err != nil {
+					log.Printf("Error releasing leases of dead workers: %v", err)
+				} else if released > 0 {
+					log.Printf("Requeued %d files held by dead workers", released)
+				}
+
+				pruned, err := queueService.PruneSubmissions(ctx)
+				if err != nil {
+					log.Printf("Error pruning result submissions: %v", err)
+				} else if pruned > 0 {
+					log.Printf("Pruned %d result submissions", pruned)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,200 @@
+package service
+
+import (
+	er "PDFStoring/error"
+	"PDFStoring/models"
+	"context"
+	"log"
+	"time"
+)
+
+// maxUnroutableJobs caps how many unroutable files are listed in the queue statistics
+const maxUnroutableJobs = 100
+
+// GetQueueStats reports the depth of every status and priority lane, and the throughput, parse durations and
+// error rate of every lane over the given sliding window. The numbers are computed from the files and queue
+// tables and from the history of status changes.
+func (s *QueueServiceStruct) GetQueueStats(ctx context.Context, window time.Duration) (*models.QueueStats, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	stats := &models.QueueStats{
+		WindowSeconds: window.Seconds(),
+		ByStatus:      []models.StatusStats{},
+	}
+
+	// A file has been in its status since its last status change
+	query := `
+	SELECT f.status, COUNT(*),
+		COALESCE(EXTRACT(EPOCH FROM NOW() - MIN(COALESCE(h.changed_at, f.created_at))), 0)::float8
+	FROM files f
+	LEFT JOIN LATERAL (
+		SELECT changed_at FROM file_status_history WHERE file_id = f.id ORDER BY id DESC LIMIT 1
+	) h ON TRUE
+	GROUP BY f.status
+	ORDER BY f.status
+	`
+
+	rows, err := s.dbService.GetPool().Query(ctx, query)
+	if err != nil {
+		if er.HandleDeadlineExceededError(err) != nil {
+			log.Println("Deadline exceeded while fetching status stats")
+			return nil, err
+		}
+		log.Printf("Error fetching status stats: %v", err)
+		return nil, err
+	}
+	for rows.Next() {
+		var statusStats models.StatusStats
+		err = rows.Scan(&statusStats.Status, &statusStats.Depth, &statusStats.OldestAgeSeconds)
+		if err != nil {
+			rows.Close()
+			log.Printf("Error scanning status stats: %v", err)
+			return nil, err
+		}
+		stats.ByStatus = append(stats.ByStatus, statusStats)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		log.Printf("Error iterating over rows: %v", err)
+		return nil, err
+	}
+
+	lanes := make(map[string]*models.LaneStats)
+	for _, priority := range Priorities {
+		lanes[string(priority)] = &models.LaneStats{Priority: string(priority)}
+	}
+
+	depths, err := s.backend.Stats(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, depth := range depths {
+		if lane, ok := lanes[depth.Priority]; ok {
+			lane.Depth = depth.Waiting
+			lane.OldestAgeSeconds = depth.OldestAgeSeconds
+		}
+		stats.Total.Depth += depth.Waiting
+		if depth.OldestAgeSeconds > stats.Total.OldestAgeSeconds {
+			stats.Total.OldestAgeSeconds = depth.OldestAgeSeconds
+		}
+	}
+
+	// Every change to parsing starts an attempt, and the next change of the same file ends it: success is a
+	// parse, in_queue a failure that is retried, error a failure that was dead-lettered and limit_exceeded a
+	// failure that is not retried. The rollup row without a priority holds the totals.
+	query = `
+	WITH params AS (
+		SELECT NOW() - make_interval(secs => $1) AS since
+	),
+	attempts AS (
+		SELECT COALESCE(priority, 'normal') AS priority, status, changed_at,
+			LEAD(status) OVER w AS next_status,
+			LEAD(changed_at) OVER w AS finished_at
+		FROM file_status_history
+		WHERE file_id IN (SELECT h.file_id FROM file_status_history h, params p WHERE h.changed_at > p.since)
+		WINDOW w AS (PARTITION BY file_id ORDER BY id)
+	)
+	SELECT a.priority,
+		COUNT(*) FILTER (WHERE a.changed_at > p.since),
+		COUNT(*) FILTER (WHERE a.finished_at > p.since AND a.next_status = 'success'),
+		COUNT(*) FILTER (WHERE a.finished_at > p.since AND a.next_status IN ('in_queue', 'error', 'limit_exceeded')),
+		COALESCE(AVG(EXTRACT(EPOCH FROM a.finished_at - a.changed_at))
+			FILTER (WHERE a.finished_at > p.since AND a.next_status = 'success'), 0)::float8,
+		COALESCE(PERCENTILE_CONT(0.95) WITHIN GROUP (ORDER BY EXTRACT(EPOCH FROM a.finished_at - a.changed_at))
+			FILTER (WHERE a.finished_at > p.since AND a.next_status = 'success'), 0)::float8
+	FROM attempts a CROSS JOIN params p
+	WHERE a.status = 'parsing'
+	GROUP BY ROLLUP (a.priority)
+	`
+
+	rows, err = s.dbService.GetPool().Query(ctx, query, window.Seconds())
+	if err != nil {
+		if er.HandleDeadlineExceededError(err) != nil {
+			log.Println("Deadline exceeded while fetching throughput stats")
+			return nil, err
+		}
+		log.Printf("Error fetching throughput stats: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	minutes := window.Minutes()
+	for rows.Next() {
+		var priority *string
+		var claimed, completed, failed int
+		var meanParse, p95Parse float64
+		err = rows.Scan(&priority, &claimed, &completed, &failed, &meanParse, &p95Parse)
+		if err != nil {
+			log.Printf("Error scanning throughput stats: %v", err)
+			return nil, err
+		}
+
+		lane := &stats.Total
+		if priority != nil {
+			var ok bool
+			if lane, ok = lanes[*priority]; !ok {
+				continue
+			}
+		}
+
+		lane.ClaimedPerMinute = float64(claimed) / minutes
+		lane.CompletedPerMinute = float64(completed) / minutes
+		lane.MeanParseSeconds = meanParse
+		lane.P95ParseSeconds = p95Parse
+		if finished := completed + failed; finished > 0 {
+			lane.ErrorRate = float64(failed) / float64(finished)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Printf("Error iterating over rows: %v", err)
+		return nil, err
+	}
+
+	for _, priority := range Priorities {
+		stats.ByPriority = append(stats.ByPriority, *lanes[string(priority)])
+	}
+
+	stats.Unroutable, stats.UnroutableCount, err = s.getUnroutableJobs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// getUnroutableJobs lists the oldest waiting files whose required capabilities are not all declared by any
+// worker that sent a sign of life within the heartbeat timeout, along with how many such files there are
+func (s *QueueServiceStruct) getUnroutableJobs(ctx context.Context) ([]models.UnroutableJob, int, error) {
+	query := `SELECT capabilities FROM workers WHERE last_seen > NOW() - make_interval(secs => $1)`
+
+	rows, err := s.dbService.GetPool().Query(ctx, query, s.config.WorkerHeartbeatTimeout.Seconds())
+	if err != nil {
+		if er.HandleDeadlineExceededError(err) != nil {
+			log.Println("Deadline exceeded while fetching worker capabilities")
+			return nil, 0, err
+		}
+		log.Printf("Error fetching worker capabilities: %v", err)
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var capabilities [][]string
+	for rows.Next() {
+		var workerCapabilities []string
+		err := rows.Scan(&workerCapabilities)
+		if err != nil {
+			log.Printf("Error scanning worker capabilities: %v", err)
+			return nil, 0, err
+		}
+		capabilities = append(capabilities, workerCapabilities)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Printf("Error iterating over rows: %v", err)
+		return nil, 0, err
+	}
+
+	return s.backend.Unroutable(ctx, capabilities, maxUnroutableJobs)
+}
@@ -0,0 +1,32 @@
+package service
+
+import (
+	er "PDFStoring/error"
+	"context"
+	"log"
+)
+
+// setFileStatus updates the status of a file and records the change in the status history, together with the
+// queue lane of the file, so the pipeline statistics can be computed from it. The priority is empty for files
+// that are not in the queue.
+func setFileStatus(ctx context.Context, db executor, fileId int, status FileStatus, priority Priority) error {
+	query := `
+	WITH updated AS (
+		UPDATE files SET status = $1 WHERE id = $2 RETURNING id
+	)
+	INSERT INTO file_status_history (file_id, status, priority)
+	SELECT id, $1, NULLIF($3, '') FROM updated
+	`
+
+	_, err := db.Exec(ctx, query, status, fileId, string(priority))
+	if err != nil {
+		if er.HandleDeadlineExceededError(err) != nil {
+			log.Println("Deadline exceeded while updating file status")
+			return err
+		}
+		log.Printf("Error updating file status: %v", err)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,118 @@
+package service
+
+import (
+	er "PDFStoring/error"
+	"PDFStoring/models"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// hashResult fingerprints a reported result, so a resubmission can be told apart from a different result
+func hashResult(parsedData models.Parser) string {
+	hasher := sha256.New()
+	for _, field := range []string{parsedData.ParsedStatus, parsedData.ParsedError, parsedData.ParserName,
+		parsedData.ParserVersion, parsedData.ParsedFile} {
+		hasher.Write([]byte(field))
+		hasher.Write([]byte{0})
+	}
+	if metadata := parsedData.Metadata; metadata != nil {
+		for _, field := range []string{metadata.Title, metadata.Author, metadata.Producer, formatTime(metadata.CreatedAt),
+			formatTime(metadata.ModifiedAt), metadata.PDFVersion, strconv.Itoa(metadata.PageCount)} {
+			hasher.Write([]byte(field))
+			hasher.Write([]byte{0})
+		}
+	}
+	if parsedData.Outline != nil {
+		outline, _ := json.Marshal(parsedData.Outline)
+		hasher.Write(outline)
+		hasher.Write([]byte{0})
+	}
+	if parsedData.Fields != nil {
+		fields, _ := json.Marshal(parsedData.Fields)
+		hasher.Write(fields)
+		hasher.Write([]byte{0})
+	}
+	for _, page := range parsedData.Pages {
+		for _, field := range []string{strconv.Itoa(page.Number), strconv.FormatFloat(page.Width, 'g', -1, 64),
+			strconv.FormatFloat(page.Height, 'g', -1, 64), strconv.Itoa(page.Rotation), page.Text} {
+			hasher.Write([]byte(field))
+			hasher.Write([]byte{0})
+		}
+	}
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+// formatTime formats an optional time for hashing, a missing time is empty
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// checkSubmission reports whether the result was already submitted under its lease. A different result
+// submitted under the same lease fails with ErrDuplicateSubmission.
+func checkSubmission(ctx context.Context, db querier, fileId int, parsedData models.Parser, resultHash string) (bool, error) {
+	query := `SELECT file_id, attempt, result_hash FROM result_submissions WHERE lease_token = $1`
+
+	var submittedFileId, submittedAttempt int
+	var submittedHash string
+	err := db.QueryRow(ctx, query, parsedData.LeaseToken).Scan(&submittedFileId, &submittedAttempt, &submittedHash)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		}
+		if er.HandleDeadlineExceededError(err) != nil {
+			log.Println("Deadline exceeded while looking for earlier submission")
+			return false, err
+		}
+		log.Printf("Error looking for earlier submission: %v", err)
+		return false, err
+	}
+
+	if submittedFileId != fileId || submittedAttempt != parsedData.Attempt || submittedHash != resultHash {
+		return false, er.ErrDuplicateSubmission
+	}
+	return true, nil
+}
+
+// checkLease fails unless the result was reported under the current lease of the file, whose entry is nil when
+// the file is no longer queued. A result from an earlier attempt fails with ErrStaleLease.
+func checkLease(ctx context.Context, db querier, fileId int, entry *models.Queue, parsedData models.Parser) error {
+	if entry == nil || entry.LeaseToken == "" {
+		log.Println("File is not leased:", fileId)
+		return leaseLostError(ctx, db, fileId)
+	}
+	if entry.LeaseToken != parsedData.LeaseToken || entry.Attempts != parsedData.Attempt {
+		log.Printf("Stale result for file %d from attempt %d, attempt %d holds the lease", fileId, parsedData.Attempt, entry.Attempts)
+		return er.ErrStaleLease
+	}
+	return nil
+}
+
+// PruneSubmissions forgets the result submissions older than the retention, returning how many were deleted. A
+// result resubmitted after that is rejected like any result whose lease is over, without changing the file.
+func (s *QueueServiceStruct) PruneSubmissions(ctx context.Context) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	query := `DELETE FROM result_submissions WHERE submitted_at < NOW() - make_interval(secs => $1)`
+	tag, err := s.dbService.GetPool().Exec(ctx, query, s.config.SubmissionRetention.Seconds())
+	if err != nil {
+		if er.HandleDeadlineExceededError(err) != nil {
+			log.Println("Deadline exceeded while pruning result submissions")
+			return 0, err
+		}
+		log.Printf("Error pruning result submissions: %v", err)
+		return 0, err
+	}
+	return int(tag.RowsAffected()), nil
+}
@@ -0,0 +1,109 @@
+package service
+
+import (
+	er "PDFStoring/error"
+	"PDFStoring/models"
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// fakeRow is the row a fakeQuerier answers with, or its error
+type fakeRow struct {
+	values []interface{}
+	err    error
+}
+
+func (r fakeRow) Scan(dest ...interface{}) error {
+	if r.err != nil {
+		return r.err
+	}
+	for i, value := range r.values {
+		reflect.ValueOf(dest[i]).Elem().Set(reflect.ValueOf(value))
+	}
+	return nil
+}
+
+// fakeQuerier answers every query with the same row
+type fakeQuerier struct {
+	row fakeRow
+}
+
+func (q fakeQuerier) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	return q.row
+}
+
+func TestCheckSubmission(t *testing.T) {
+	parsedData := models.Parser{LeaseToken: "lease", Attempt: 2, ParsedStatus: string(Success), ParsedFile: "text"}
+	resultHash := hashResult(parsedData)
+
+	different := parsedData
+	different.ParsedFile = "other text"
+
+	tests := []struct {
+		name       string
+		row        fakeRow
+		parsedData models.Parser
+		submitted  bool
+		err        error
+	}{
+		{"First", fakeRow{err: pgx.ErrNoRows}, parsedData, false, nil},
+		{"Identical", fakeRow{values: []interface{}{7, 2, resultHash}}, parsedData, true, nil},
+		{"DifferentResult", fakeRow{values: []interface{}{7, 2, resultHash}}, different, false, er.ErrDuplicateSubmission},
+		{"DifferentAttempt", fakeRow{values: []interface{}{7, 1, resultHash}}, parsedData, false, er.ErrDuplicateSubmission},
+		{"DifferentFile", fakeRow{values: []interface{}{8, 2, resultHash}}, parsedData, false, er.ErrDuplicateSubmission},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			submitted, err := checkSubmission(context.Background(), fakeQuerier{test.row}, 7, test.parsedData, hashResult(test.parsedData))
+			if submitted != test.submitted || !errors.Is(err, test.err) {
+				t.Errorf("checkSubmission = %v, %v, want %v, %v", submitted, err, test.submitted, test.err)
+			}
+		})
+	}
+}
+
+func TestCheckLease(t *testing.T) {
+	parsedData := models.Parser{LeaseToken: "lease", Attempt: 2}
+
+	tests := []struct {
+		name  string
+		entry *models.Queue
+		// status is the status of the file, looked up once it is no longer leased
+		status FileStatus
+		err    error
+	}{
+		{"Current", &models.Queue{LeaseToken: "lease", Attempts: 2}, InQueue, nil},
+		{"EarlierAttempt", &models.Queue{LeaseToken: "lease", Attempts: 3}, InQueue, er.ErrStaleLease},
+		{"OtherLease", &models.Queue{LeaseToken: "other", Attempts: 2}, InQueue, er.ErrStaleLease},
+		{"Released", &models.Queue{}, InQueue, er.ErrNotLeased},
+		{"Cancelled", nil, Cancelled, er.ErrJobCancelled},
+		{"LimitExceeded", nil, LimitExceeded, er.ErrLimitExceeded},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := fakeQuerier{fakeRow{values: []interface{}{test.status}}}
+			if err := checkLease(context.Background(), db, 7, test.entry, parsedData); !errors.Is(err, test.err) {
+				t.Errorf("checkLease = %v, want %v", err, test.err)
+			}
+		})
+	}
+}
+
+func TestHashResult(t *testing.T) {
+	parsedData := models.Parser{ParsedStatus: string(Success), Pages: []models.ParsedPage{{Number: 1, Text: "a"}}}
+	if hashResult(parsedData) != hashResult(parsedData) {
+		t.Fatalf("hashResult differs for the same result")
+	}
+
+	changed := parsedData
+	changed.Pages = []models.ParsedPage{{Number: 1, Text: "b"}}
+	if hashResult(parsedData) == hashResult(changed) {
+		t.Errorf("hashResult is the same for results with different pages")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"PDFStoring/database"
 	er "PDFStoring/error"
 	"PDFStoring/models"
+	"PDFStoring/queue"
 	"context"
 	"log"
 	"time"
@@ -11,18 +12,22 @@ import (
 
 type UserServiceStruct struct {
 	dbService database.DatabaseService
+	backend   queue.Backend
 }
 
 // UserService interface defines methods for user-related operations
 type UserService interface {
 	CreateUser(ctx context.Context) (int, error)
-	GetUserFiles(ctx context.Context, userId int) ([]models.UserFile, error)
+	GetUserFiles(ctx context.Context, userId int, filter FileFilter) ([]models.UserFile, error)
+	SetUserLimits(ctx context.Context, userId int, limits models.UserLimits) error
 }
 
-// NewUserService creates a new instance of UserServiceStruct, implementing UserService
-func NewUserService(dbService database.DatabaseService) UserService {
+// NewUserService creates a new instance of UserServiceStruct, implementing UserService. The concurrency caps of
+// the users are kept by the queue backend.
+func NewUserService(dbService database.DatabaseService, backend queue.Backend) UserService {
 	return &UserServiceStruct{
 		dbService: dbService,
+		backend:   backend,
 	}
 }
 
@@ -31,7 +36,7 @@ func (s *UserServiceStruct) CreateUser(ctx context.Context) (int, error) {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	query := `INSERT INTO users () VALUES () RETURNING ID`
+	query := `INSERT INTO users DEFAULT VALUES RETURNING id`
 
 	var userId int
 	err := s.dbService.GetPool().QueryRow(ctx, query).Scan(&userId)
@@ -46,20 +51,15 @@ func (s *UserServiceStruct) CreateUser(ctx context.Context) (int, error) {
 	return userId, nil
 }
 
-// GetUserFiles retrieves all files uploaded by a user
-func (s *UserServiceStruct) GetUserFiles(ctx context.Context, userId int) ([]models.UserFile, error) {
+// GetUserFiles retrieves the files uploaded by a user that pass the filter, in the order it asks for
+func (s *UserServiceStruct) GetUserFiles(ctx context.Context, userId int, filter FileFilter) ([]models.UserFile, error) {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	query := `
-	SELECT uf.filename, uf.upload_date, f.status, f.parsed_file 
-	FROM user_files uf
-	INNER JOIN files f ON uf.file_id = f.id
-	WHERE uf.user_id = $1
-	ORDER BY uf.upload_date DESC
-	`
+	conditions, args := filter.where([]interface{}{userId})
+	query := userFilesQuery + `WHERE uf.user_id = $1` + conditions + filter.orderBy()
 
-	rows, err := s.dbService.GetPool().Query(ctx, query, userId)
+	rows, err := s.dbService.GetPool().Query(ctx, query, args...)
 	if err != nil {
 		if er.HandleDeadlineExceededError(err) != nil {
 			return nil, err
@@ -71,8 +71,7 @@ func (s *UserServiceStruct) GetUserFiles(ctx context.Context, userId int) ([]mod
 
 	var userFiles []models.UserFile
 	for rows.Next() {
-		var userFile models.UserFile
-		err := rows.Scan(&userFile.Filename, &userFile.UploadDate, &userFile.Status)
+		userFile, err := scanUserFile(rows)
 		if err != nil {
 			log.Printf("Error scanning user files: %v", err)
 			return nil, err
@@ -85,5 +84,53 @@ func (s *UserServiceStruct) GetUserFiles(ctx context.Context, userId int) ([]mod
 		return nil, err
 	}
 
+	err = addQueueState(ctx, s.backend, userFiles)
+	if err != nil {
+		return nil, err
+	}
+
 	return userFiles, nil
 }
+
+// SetUserLimits stores the per-user overrides of the queue settings
+func (s *UserServiceStruct) SetUserLimits(ctx context.Context, userId int, limits models.UserLimits) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	tx, err := queue.Begin(ctx, s.dbService.GetPool())
+	if err != nil {
+		if er.HandleDeadlineExceededError(err) != nil {
+			return err
+		}
+		log.Printf("Error starting user transaction: %v", err)
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `UPDATE users SET max_file_bytes = $1, max_pages = $2, max_parse_seconds = $3 WHERE id = $4`
+
+	tag, err := tx.Exec(ctx, query, limits.MaxFileBytes, limits.MaxPages, limits.MaxParseSeconds, userId)
+	if err != nil {
+		if er.HandleDeadlineExceededError(err) != nil {
+			return err
+		}
+		log.Printf("Error updating user limits: %v", err)
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return er.ErrUserNotFound
+	}
+
+	err = s.backend.SetUserMaxInFlight(queue.WithTx(ctx, tx), userId, limits.MaxInFlight)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		log.Printf("Error committing user transaction: %v", err)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"PDFStoring/models"
+	"PDFStoring/queue"
+	"context"
+	"github.com/jackc/pgx/v4"
+	"math"
+	"time"
+)
+
+// userFilesQuery selects files the way they are reported to their users, with the metadata of their current
+// parse result, and is completed with a WHERE clause. The state of the queued files is added afterwards with
+// addQueueState.
+const userFilesQuery = `
+	SELECT uf.user_id, uf.file_id, uf.filename, uf.upload_date, f.status, r.id, r.title, r.author, r.producer,
+		r.document_created_at, r.document_modified_at, r.pdf_version, r.page_count
+	FROM user_files uf
+	INNER JOIN files f ON uf.file_id = f.id
+	LEFT JOIN parse_results r ON r.file_id = f.id AND r.is_current
+	`
+
+// scanUserFile scans a row selected by userFilesQuery
+func scanUserFile(row pgx.Row) (models.UserFile, error) {
+	var userFile models.UserFile
+	var resultId, pageCount *int
+	var title, author, producer, pdfVersion *string
+	var metadata models.DocumentMetadata
+	err := row.Scan(&userFile.UserID, &userFile.FileID, &userFile.Filename, &userFile.UploadDate, &userFile.Status,
+		&resultId, &title, &author, &producer, &metadata.CreatedAt, &metadata.ModifiedAt, &pdfVersion, &pageCount)
+	if err != nil {
+		return userFile, err
+	}
+
+	if resultId != nil {
+		metadata.Title = stringValue(title)
+		metadata.Author = stringValue(author)
+		metadata.Producer = stringValue(producer)
+		metadata.PDFVersion = stringValue(pdfVersion)
+		if pageCount != nil {
+			metadata.PageCount = *pageCount
+		}
+		userFile.Metadata = &metadata
+	}
+	return userFile, nil
+}
+
+// stringValue returns the value of a nullable column, empty for NULL
+func stringValue(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
+
+// addQueueState completes the files with their state in the queue. Queued files that are not due yet are
+// reported as scheduled with their planned time, and files being parsed carry the progress last reported by
+// their worker.
+func addQueueState(ctx context.Context, backend queue.Backend, userFiles []models.UserFile) error {
+	var fileIds []int
+	for _, userFile := range userFiles {
+		if userFile.Status == string(InQueue) || userFile.Status == string(Parsing) {
+			fileIds = append(fileIds, userFile.FileID)
+		}
+	}
+	if len(fileIds) == 0 {
+		return nil
+	}
+
+	entries, err := backend.List(ctx, fileIds)
+	if err != nil {
+		return err
+	}
+
+	queued := make(map[int]models.Queue)
+	for _, entry := range entries {
+		queued[entry.FileID] = entry
+	}
+
+	now := time.Now()
+	for i, userFile := range userFiles {
+		entry, ok := queued[userFile.FileID]
+		if !ok {
+			continue
+		}
+
+		if entry.NotBefore != nil && entry.NotBefore.After(now) {
+			if userFile.Status == string(InQueue) {
+				userFiles[i].Status = string(Scheduled)
+			}
+			userFiles[i].ScheduledFor = entry.NotBefore
+		}
+
+		if entry.PagesProcessed != nil && entry.TotalPages != nil && entry.ClaimedAt != nil && entry.ProgressAt != nil {
+			userFiles[i].Progress = newProgress(*entry.PagesProcessed, *entry.TotalPages, entry.Stage, *entry.ClaimedAt, *entry.ProgressAt)
+		}
+	}
+
+	return nil
+}
+
+// newProgress computes the completion percentage of a file, and estimates when it will be done by assuming
+// the remaining pages take as long as the pages processed since the file was claimed
+func newProgress(pagesProcessed int, totalPages int, stage string, claimedAt time.Time, progressAt time.Time) *models.Progress {
+	progress := &models.Progress{
+		PagesProcessed: pagesProcessed,
+		TotalPages:     totalPages,
+		Stage:          stage,
+		UpdatedAt:      progressAt,
+	}
+
+	if totalPages > 0 {
+		progress.Percent = math.Round(float64(pagesProcessed)/float64(totalPages)*1000) / 10
+	}
+
+	if pagesProcessed > 0 {
+		perPage := progressAt.Sub(claimedAt) / time.Duration(pagesProcessed)
+		eta := progressAt.Add(perPage * time.Duration(totalPages-pagesProcessed))
+		progress.ETA = &eta
+	}
+
+	return progress
+}
@@ -1,11 +1,54 @@
 package service
 
+import er "PDFStoring/error"
+
 type FileStatus string
 
 const (
-	InQueue  FileStatus = "in_queue"
-	Parsing  FileStatus = "parsing"
-	Error    FileStatus = "error"
-	Success  FileStatus = "success"
-	Imported FileStatus = "imported"
+	InQueue   FileStatus = "in_queue"
+	Parsing   FileStatus = "parsing"
+	Error     FileStatus = "error"
+	Success   FileStatus = "success"
+	Imported  FileStatus = "imported"
+	Cancelled FileStatus = "cancelled"
+	// LimitExceeded is set when parsing a file went over its time budget or another limit
+	LimitExceeded FileStatus = "limit_exceeded"
+	// Scheduled is reported for queued files that are not due yet, it is never stored
+	Scheduled FileStatus = "scheduled"
 )
+
+type Priority string
+
+const (
+	PriorityHigh   Priority = "high"
+	PriorityNormal Priority = "normal"
+	PriorityBulk   Priority = "bulk"
+)
+
+// Priorities lists the queue lanes from the most to the least urgent one
+var Priorities = []Priority{PriorityHigh, PriorityNormal, PriorityBulk}
+
+// QueueState controls whether files are handed out to workers, uploads are queued in every state
+type QueueState string
+
+const (
+	QueueRunning QueueState = "running"
+	QueuePaused  QueueState = "paused"
+	// QueueDraining stops claims like QueuePaused while the leased files are finished
+	QueueDraining QueueState = "draining"
+)
+
+// ParsePriority converts a requested priority to a queue lane, defaulting to the normal lane when none is given
+func ParsePriority(value string) (Priority, error) {
+	if value == "" {
+		return PriorityNormal, nil
+	}
+
+	for _, priority := range Priorities {
+		if string(priority) == value {
+			return priority, nil
+		}
+	}
+
+	return "", er.ErrInvalidPriority
+}
@@ -0,0 +1,230 @@
+package service
+
+import (
+	"PDFStoring/database"
+	er "PDFStoring/error"
+	"PDFStoring/models"
+	"PDFStoring/queue"
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+type WorkerServiceStruct struct {
+	dbService        database.DatabaseService
+	backend          queue.Backend
+	heartbeatTimeout time.Duration
+}
+
+// WorkerService interface defines methods for worker-related operations
+type WorkerService interface {
+	RegisterWorker(ctx context.Context, worker models.Worker) (int, error)
+	Heartbeat(ctx context.Context, workerId int) (*models.Heartbeat, error)
+	GetWorkers(ctx context.Context) ([]models.Worker, error)
+}
+
+// NewWorkerService creates a new instance of WorkerServiceStruct, implementing WorkerService. Workers that
+// have not sent a heartbeat within heartbeatTimeout are reported as not alive. Their leases are looked up in
+// the queue backend.
+func NewWorkerService(dbService database.DatabaseService, backend queue.Backend, heartbeatTimeout time.Duration) WorkerService {
+	return &WorkerServiceStruct{
+		dbService:        dbService,
+		backend:          backend,
+		heartbeatTimeout: heartbeatTimeout,
+	}
+}
+
+// RegisterWorker registers a new worker and returns the id it claims files under
+func (s *WorkerServiceStruct) RegisterWorker(ctx context.Context, worker models.Worker) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	worker.Capabilities = NormalizeCapabilities(worker.Capabilities)
+
+	query := `INSERT INTO workers (name, version, capabilities) VALUES ($1, $2, $3) RETURNING id`
+
+	var workerId int
+	err := s.dbService.GetPool().QueryRow(ctx, query, worker.Name, worker.Version, worker.Capabilities).Scan(&workerId)
+	if err != nil {
+		if er.HandleDeadlineExceededError(err) != nil {
+			log.Println("Deadline exceeded while registering worker")
+			return 0, err
+		}
+		log.Printf("Error registering worker: %v", err)
+		return 0, err
+	}
+
+	log.Printf("Worker %s %s registered with id %d", worker.Name, worker.Version, workerId)
+	return workerId, nil
+}
+
+// Heartbeat records that the worker is still alive and hands it the files cancelled while it was parsing them.
+// Each cancellation is only delivered once.
+func (s *WorkerServiceStruct) Heartbeat(ctx context.Context, workerId int) (*models.Heartbeat, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	err := touchWorker(ctx, s.dbService.GetPool(), workerId)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `DELETE FROM cancellations WHERE worker_id = $1 RETURNING file_id`
+	rows, err := s.dbService.GetPool().Query(ctx, query, workerId)
+	if err != nil {
+		if er.HandleDeadlineExceededError(err) != nil {
+			log.Println("Deadline exceeded while fetching cancellations")
+			return nil, err
+		}
+		log.Printf("Error fetching cancellations: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	heartbeat := &models.Heartbeat{CancelledJobs: []int{}}
+	for rows.Next() {
+		var fileId int
+		err := rows.Scan(&fileId)
+		if err != nil {
+			log.Printf("Error scanning cancellations: %v", err)
+			return nil, err
+		}
+		heartbeat.CancelledJobs = append(heartbeat.CancelledJobs, fileId)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Printf("Error iterating over rows: %v", err)
+		return nil, err
+	}
+
+	return heartbeat, nil
+}
+
+// GetWorkers returns every registered worker with the files it currently holds a lease on
+func (s *WorkerServiceStruct) GetWorkers(ctx context.Context) ([]models.Worker, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	leased, err := s.backend.Leased(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	currentJobs := make(map[int][]int)
+	for _, entry := range leased {
+		if entry.WorkerID != nil {
+			currentJobs[*entry.WorkerID] = append(currentJobs[*entry.WorkerID], entry.FileID)
+		}
+	}
+
+	query := `SELECT id, name, version, capabilities, registered_at, last_seen, last_seen >= NOW() - make_interval(secs => $1)
+	FROM workers ORDER BY last_seen DESC`
+
+	rows, err := s.dbService.GetPool().Query(ctx, query, s.heartbeatTimeout.Seconds())
+	if err != nil {
+		if er.HandleDeadlineExceededError(err) != nil {
+			log.Println("Deadline exceeded while fetching workers")
+			return nil, err
+		}
+		log.Printf("Error fetching workers: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	workers := []models.Worker{}
+	for rows.Next() {
+		var worker models.Worker
+		err := rows.Scan(&worker.ID, &worker.Name, &worker.Version, &worker.Capabilities, &worker.RegisteredAt,
+			&worker.LastSeen, &worker.Alive)
+		if err != nil {
+			log.Printf("Error scanning workers: %v", err)
+			return nil, err
+		}
+		worker.CurrentJobs = currentJobs[worker.ID]
+		if worker.CurrentJobs == nil {
+			worker.CurrentJobs = []int{}
+		}
+		sort.Ints(worker.CurrentJobs)
+		workers = append(workers, worker)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Printf("Error iterating over rows: %v", err)
+		return nil, err
+	}
+
+	return workers, nil
+}
+
+// touchWorker marks the worker as seen now, failing with ErrWorkerNotFound for an unregistered worker
+func touchWorker(ctx context.Context, db executor, workerId int) error {
+	query := `UPDATE workers SET last_seen = NOW() WHERE id = $1`
+	tag, err := db.Exec(ctx, query, workerId)
+	if err != nil {
+		if er.HandleDeadlineExceededError(err) != nil {
+			log.Println("Deadline exceeded while updating worker")
+			return err
+		}
+		log.Printf("Error updating worker: %v", err)
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		log.Println("Worker does not exist:", workerId)
+		return er.ErrWorkerNotFound
+	}
+
+	return nil
+}
+
+// workerCapabilities returns the capabilities a worker registered with, none for an anonymous worker
+func workerCapabilities(ctx context.Context, db querier, workerId int) ([]string, error) {
+	if workerId == 0 {
+		return []string{}, nil
+	}
+
+	var capabilities []string
+	query := `SELECT capabilities FROM workers WHERE id = $1`
+	err := db.QueryRow(ctx, query, workerId).Scan(&capabilities)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			log.Println("Worker does not exist:", workerId)
+			return nil, er.ErrWorkerNotFound
+		}
+		if er.HandleDeadlineExceededError(err) != nil {
+			log.Println("Deadline exceeded while fetching worker capabilities")
+			return nil, err
+		}
+		log.Printf("Error fetching worker capabilities: %v", err)
+		return nil, err
+	}
+	if capabilities == nil {
+		capabilities = []string{}
+	}
+
+	return capabilities, nil
+}
+
+// recordCancellation tells the worker holding the lease of the entry to stop parsing it on its next heartbeat
+func recordCancellation(ctx context.Context, db executor, entry models.Queue) error {
+	if entry.WorkerID == nil || entry.LeaseToken == "" {
+		return nil
+	}
+
+	query := `INSERT INTO cancellations (file_id, worker_id, lease_token) VALUES ($1, $2, $3)
+	ON CONFLICT (worker_id, file_id) DO UPDATE SET lease_token = EXCLUDED.lease_token, cancelled_at = CURRENT_TIMESTAMP`
+	_, err := db.Exec(ctx, query, entry.FileID, *entry.WorkerID, entry.LeaseToken)
+	if err != nil {
+		if er.HandleDeadlineExceededError(err) != nil {
+			log.Println("Deadline exceeded while recording cancellation")
+			return err
+		}
+		log.Printf("Error recording cancellation: %v", err)
+		return err
+	}
+
+	return nil
+}
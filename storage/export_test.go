@@ -0,0 +1,11 @@
+package storage
+
+import (
+	"net/http"
+	"time"
+)
+
+// SignAt signs the request as if it were sent at the given time
+func (s *S3Store) SignAt(req *http.Request, payloadHash string, now time.Time) {
+	s.sign(req, payloadHash, now)
+}
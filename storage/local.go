@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// LocalStore is a BlobStore keeping every blob in a file under a root directory
+type LocalStore struct {
+	root string
+}
+
+// NewLocalStore creates a LocalStore in the given directory, creating the directory when it does not exist
+func NewLocalStore(root string) (*LocalStore, error) {
+	err := os.MkdirAll(root, 0o755)
+	if err != nil {
+		return nil, err
+	}
+	return &LocalStore{root: root}, nil
+}
+
+// path spreads the blobs over subdirectories named after the first two characters of their key, so no
+// directory grows too large
+func (s *LocalStore) path(key string) string {
+	if len(key) < 2 {
+		return filepath.Join(s.root, key)
+	}
+	return filepath.Join(s.root, key[:2], key)
+}
+
+// Put writes the blob to a temporary file first, so a failed or concurrent write never leaves a partial blob
+func (s *LocalStore) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+
+	path := s.path(key)
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(path), key+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	written, err := io.Copy(file, r)
+	if err == nil && written != size {
+		err = io.ErrUnexpectedEOF
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), path)
+}
+
+func (s *LocalStore) Get(ctx context.Context, key string, offset int64, length int64) (io.ReadCloser, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(s.path(key))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, ErrBlobNotFound
+		}
+		return nil, err
+	}
+
+	if offset > 0 {
+		_, err = file.Seek(offset, io.SeekStart)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	if length < 0 {
+		return file, nil
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(file, length), file}, nil
+}
+
+func (s *LocalStore) Stat(ctx context.Context, key string) (int64, error) {
+	if err := checkKey(key); err != nil {
+		return 0, err
+	}
+
+	info, err := os.Stat(s.path(key))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return 0, ErrBlobNotFound
+		}
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+func (s *LocalStore) Delete(ctx context.Context, key string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+
+	err := os.Remove(s.path(key))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,15 @@
+package storage_test
+
+import (
+	"PDFStoring/storage"
+	"PDFStoring/storage/storagetest"
+	"testing"
+)
+
+func TestLocalStore(t *testing.T) {
+	store, err := storage.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStore: %v", err)
+	}
+	storagetest.Run(t, store)
+}
@@ -0,0 +1,285 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// emptyPayloadHash is the SHA-256 of an empty request body
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// S3Config configures an S3Store
+type S3Config struct {
+	// Endpoint is the base URL of the S3 API, such as https://s3.eu-central-1.amazonaws.com or the URL of
+	// an S3-compatible server
+	Endpoint        string
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// PathStyle addresses the bucket in the path instead of the host name, as most S3-compatible servers need
+	PathStyle bool
+}
+
+// S3Store is a BlobStore keeping every blob as an object of an S3 bucket, signing its requests with
+// AWS Signature Version 4
+type S3Store struct {
+	config   S3Config
+	endpoint *url.URL
+	client   *http.Client
+}
+
+// NewS3Store creates an S3Store for the configured bucket
+func NewS3Store(config S3Config) (*S3Store, error) {
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, errors.New("S3 endpoint must be an absolute URL")
+	}
+	if config.Bucket == "" || config.Region == "" {
+		return nil, errors.New("S3 bucket and region are required")
+	}
+
+	return &S3Store{
+		config:   config,
+		endpoint: endpoint,
+		client:   &http.Client{},
+	}, nil
+}
+
+// objectURL returns the URL of the object stored under the key
+func (s *S3Store) objectURL(key string) string {
+	objectURL := *s.endpoint
+	basePath := strings.TrimSuffix(objectURL.Path, "/")
+	if s.config.PathStyle {
+		objectURL.Path = basePath + "/" + s.config.Bucket + "/" + key
+	} else {
+		objectURL.Host = s.config.Bucket + "." + objectURL.Host
+		objectURL.Path = basePath + "/" + key
+	}
+	return objectURL.String()
+}
+
+// Put uploads the blob without signing its body, so it is streamed instead of being read twice
+func (s *S3Store) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.objectURL(key), r)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/pdf")
+
+	resp, err := s.do(req, "UNSIGNED-PAYLOAD")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return responseError(req, resp)
+	}
+	return nil
+}
+
+func (s *S3Store) Get(ctx context.Context, key string, offset int64, length int64) (io.ReadCloser, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.objectURL(key), nil)
+	if err != nil {
+		return nil, err
+	}
+	if length == 0 {
+		// An empty range cannot be requested, so read nothing of a blob that exists
+		req.Method = http.MethodHead
+	} else if length > 0 {
+		req.Header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-"+strconv.FormatInt(offset+length-1, 10))
+	} else if offset > 0 {
+		req.Header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+	}
+
+	resp, err := s.do(req, emptyPayloadHash)
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, ErrBlobNotFound
+	default:
+		defer resp.Body.Close()
+		return nil, responseError(req, resp)
+	}
+}
+
+func (s *S3Store) Stat(ctx context.Context, key string) (int64, error) {
+	if err := checkKey(key); err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, s.objectURL(key), nil)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := s.do(req, emptyPayloadHash)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.ContentLength, nil
+	case http.StatusNotFound:
+		return 0, ErrBlobNotFound
+	default:
+		return 0, responseError(req, resp)
+	}
+}
+
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, s.objectURL(key), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.do(req, emptyPayloadHash)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return responseError(req, resp)
+	}
+	return nil
+}
+
+// do signs the request and sends it
+func (s *S3Store) do(req *http.Request, payloadHash string) (*http.Response, error) {
+	s.sign(req, payloadHash, time.Now().UTC())
+	return s.client.Do(req)
+}
+
+// sign adds the AWS Signature Version 4 of the request to its headers. Only the host, the range and the x-amz
+// headers are signed, which is all S3 requires.
+func (s *S3Store) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	canonicalURI := req.URL.EscapedPath()
+	if canonicalURI == "" {
+		canonicalURI = "/"
+	}
+
+	// The headers are listed in the order of their lowercase names
+	canonicalHeaders := "host:" + req.URL.Host + "\n"
+	signedHeaders := "host"
+	if byteRange := req.Header.Get("Range"); byteRange != "" {
+		canonicalHeaders += "range:" + strings.TrimSpace(byteRange) + "\n"
+		signedHeaders += ";range"
+	}
+	canonicalHeaders += "x-amz-content-sha256:" + payloadHash + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+	signedHeaders += ";x-amz-content-sha256;x-amz-date"
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalURI,
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.config.Region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	signingKey := hmacSHA256([]byte("AWS4"+s.config.SecretAccessKey), date)
+	signingKey = hmacSHA256(signingKey, s.config.Region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.config.AccessKeyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// canonicalQuery encodes the query parameters sorted by name and then by value, every one with an equals sign
+// even when it has no value
+func canonicalQuery(query url.Values) string {
+	var params [][2]string
+	for name, values := range query {
+		for _, value := range values {
+			params = append(params, [2]string{uriEncode(name), uriEncode(value)})
+		}
+	}
+	sort.Slice(params, func(i, j int) bool {
+		if params[i][0] != params[j][0] {
+			return params[i][0] < params[j][0]
+		}
+		return params[i][1] < params[j][1]
+	})
+
+	encoded := make([]string, len(params))
+	for i, param := range params {
+		encoded[i] = param[0] + "=" + param[1]
+	}
+	return strings.Join(encoded, "&")
+}
+
+// uriEncode percent-encodes every byte but the unreserved characters, the way Signature Version 4 requires
+func uriEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// responseError describes a failed request, including the start of the error document S3 answered with
+func responseError(req *http.Request, resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("S3 %s %s failed with status %d: %s", req.Method, req.URL.Path, resp.StatusCode, strings.TrimSpace(string(body)))
+}
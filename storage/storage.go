@@ -0,0 +1,36 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"regexp"
+)
+
+// ErrBlobNotFound is returned when no blob is stored under the requested key
+var ErrBlobNotFound = errors.New("Blob does not exist")
+
+// ErrInvalidKey is returned for keys that are not made of letters, digits, dots, dashes and underscores
+var ErrInvalidKey = errors.New("Invalid blob key")
+
+// BlobStore stores the uploaded PDFs outside of the database, keyed by their file hash
+type BlobStore interface {
+	// Put stores size bytes read from r under the key, replacing any blob stored under it
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	// Get reads length bytes of the blob starting at offset, or the rest of the blob when length is negative
+	Get(ctx context.Context, key string, offset int64, length int64) (io.ReadCloser, error)
+	// Stat returns the size of the blob
+	Stat(ctx context.Context, key string) (int64, error)
+	// Delete removes the blob, deleting a missing blob is not an error
+	Delete(ctx context.Context, key string) error
+}
+
+var validKey = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// checkKey rejects keys that could escape the directory or bucket of the store
+func checkKey(key string) error {
+	if !validKey.MatchString(key) || key == "." || key == ".." {
+		return ErrInvalidKey
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package storagetest
+
+import (
+	"PDFStoring/storage"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The credentials and the region FakeS3 accepts signatures for
+const (
+	FakeAccessKeyID     = "AKIDEXAMPLE"
+	FakeSecretAccessKey = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+	FakeRegion          = "us-east-1"
+)
+
+// FakeS3 is an in-process stand-in for the subset of the S3 API used by storage.S3Store. It rejects the
+// requests whose AWS Signature Version 4 does not match the fake credentials.
+type FakeS3 struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+// NewFakeS3 starts a FakeS3 serving path-style requests, it must be closed when it is no longer needed
+func NewFakeS3() *FakeS3 {
+	fake := &FakeS3{objects: make(map[string][]byte)}
+	fake.Server = httptest.NewServer(http.HandlerFunc(fake.serveHTTP))
+	return fake
+}
+
+// Config returns the settings of an S3Store keeping its blobs in the given bucket of the fake
+func (f *FakeS3) Config(bucket string) storage.S3Config {
+	return storage.S3Config{
+		Endpoint:        f.URL,
+		Region:          FakeRegion,
+		Bucket:          bucket,
+		AccessKeyID:     FakeAccessKeyID,
+		SecretAccessKey: FakeSecretAccessKey,
+		PathStyle:       true,
+	}
+}
+
+// Objects returns the number of objects stored in every bucket
+func (f *FakeS3) Objects() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.objects)
+}
+
+func (f *FakeS3) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := checkSignature(r, body); err != nil {
+		http.Error(w, "<Error><Code>SignatureDoesNotMatch</Code><Message>"+err.Error()+"</Message></Error>", http.StatusForbidden)
+		return
+	}
+
+	// The path is /bucket/key
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	if !strings.Contains(name, "/") {
+		http.Error(w, "<Error><Code>InvalidRequest</Code></Error>", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		f.mu.Lock()
+		f.objects[name] = body
+		f.mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+
+	case http.MethodGet, http.MethodHead:
+		f.mu.Lock()
+		data, ok := f.objects[name]
+		f.mu.Unlock()
+		if !ok {
+			http.Error(w, "<Error><Code>NoSuchKey</Code></Error>", http.StatusNotFound)
+			return
+		}
+		// ServeContent answers range requests the way S3 does
+		http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(data))
+
+	case http.MethodDelete:
+		f.mu.Lock()
+		delete(f.objects, name)
+		f.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// checkSignature recomputes the Signature Version 4 of the request from the headers it says it signed
+func checkSignature(r *http.Request, body []byte) error {
+	fields := make(map[string]string)
+	authorization, ok := strings.CutPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ")
+	if !ok {
+		return fmt.Errorf("request is not signed")
+	}
+	for _, field := range strings.Split(authorization, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(field), "=")
+		fields[name] = value
+	}
+
+	amzDate := r.Header.Get("X-Amz-Date")
+	if len(amzDate) != len("20060102T150405Z") {
+		return fmt.Errorf("X-Amz-Date %q is not a timestamp", amzDate)
+	}
+	scope := amzDate[:8] + "/" + FakeRegion + "/s3/aws4_request"
+	if fields["Credential"] != FakeAccessKeyID+"/"+scope {
+		return fmt.Errorf("credential %q is not %s/%s", fields["Credential"], FakeAccessKeyID, scope)
+	}
+
+	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	if payloadHash != "UNSIGNED-PAYLOAD" {
+		sum := sha256.Sum256(body)
+		if payloadHash != hex.EncodeToString(sum[:]) {
+			return fmt.Errorf("X-Amz-Content-Sha256 does not match the body")
+		}
+	}
+
+	signedHeaders := strings.Split(fields["SignedHeaders"], ";")
+	required := map[string]bool{"host": true, "x-amz-content-sha256": true, "x-amz-date": true}
+	var canonicalHeaders strings.Builder
+	for _, name := range signedHeaders {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+		delete(required, name)
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	if len(required) > 0 || !sort.StringsAreSorted(signedHeaders) {
+		return fmt.Errorf("signed headers %q are not sorted or miss host and x-amz headers", fields["SignedHeaders"])
+	}
+
+	var params []string
+	for name, values := range r.URL.Query() {
+		for _, value := range values {
+			params = append(params, url.QueryEscape(name)+"="+url.QueryEscape(value))
+		}
+	}
+	sort.Strings(params)
+
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		r.URL.EscapedPath(),
+		strings.ReplaceAll(strings.Join(params, "&"), "+", "%20"),
+		canonicalHeaders.String(),
+		fields["SignedHeaders"],
+		payloadHash,
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + FakeSecretAccessKey)
+	for _, part := range []string{amzDate[:8], FakeRegion, "s3", "aws4_request", stringToSign} {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(part))
+		key = mac.Sum(nil)
+	}
+	if !hmac.Equal([]byte(fields["Signature"]), []byte(hex.EncodeToString(key))) {
+		return fmt.Errorf("signature does not match")
+	}
+	return nil
+}
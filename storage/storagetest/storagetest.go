@@ -0,0 +1,115 @@
+// Package storagetest checks implementations of storage.BlobStore, in the way testing/fstest checks file systems
+package storagetest
+
+import (
+	"PDFStoring/storage"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+)
+
+// Run checks that the store behaves like a storage.BlobStore. The store must be empty, and it is left with
+// no blobs when every check passes.
+func Run(t *testing.T, store storage.BlobStore) {
+	ctx := context.Background()
+	data := []byte("%PDF-1.7\n" + string(bytes.Repeat([]byte("0123456789"), 100)) + "\n%%EOF\n")
+
+	t.Run("PutGet", func(t *testing.T) {
+		put(t, store, "blob", data)
+		if got := get(t, store, "blob", 0, -1); !bytes.Equal(got, data) {
+			t.Fatalf("Get returned %d bytes, want the %d bytes put", len(got), len(data))
+		}
+		remove(t, store, "blob")
+	})
+
+	t.Run("Range", func(t *testing.T) {
+		put(t, store, "blob", data)
+		if got := get(t, store, "blob", 9, 10); !bytes.Equal(got, data[9:19]) {
+			t.Errorf("Get(9, 10) = %q, want %q", got, data[9:19])
+		}
+		if got := get(t, store, "blob", 100, -1); !bytes.Equal(got, data[100:]) {
+			t.Errorf("Get(100, -1) returned %d bytes, want %d", len(got), len(data)-100)
+		}
+		remove(t, store, "blob")
+	})
+
+	t.Run("Stat", func(t *testing.T) {
+		put(t, store, "blob", data)
+		size, err := store.Stat(ctx, "blob")
+		if err != nil {
+			t.Fatalf("Stat: %v", err)
+		}
+		if size != int64(len(data)) {
+			t.Errorf("Stat = %d, want %d", size, len(data))
+		}
+		remove(t, store, "blob")
+	})
+
+	t.Run("Replace", func(t *testing.T) {
+		put(t, store, "blob", data)
+		put(t, store, "blob", data[:10])
+		if got := get(t, store, "blob", 0, -1); !bytes.Equal(got, data[:10]) {
+			t.Errorf("Get after replacing returned %q, want %q", got, data[:10])
+		}
+		remove(t, store, "blob")
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		if _, err := store.Get(ctx, "missing", 0, -1); !errors.Is(err, storage.ErrBlobNotFound) {
+			t.Errorf("Get of a missing blob returned %v, want ErrBlobNotFound", err)
+		}
+		if _, err := store.Stat(ctx, "missing"); !errors.Is(err, storage.ErrBlobNotFound) {
+			t.Errorf("Stat of a missing blob returned %v, want ErrBlobNotFound", err)
+		}
+		if err := store.Delete(ctx, "missing"); err != nil {
+			t.Errorf("Delete of a missing blob returned %v", err)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		put(t, store, "blob", data)
+		remove(t, store, "blob")
+		if _, err := store.Stat(ctx, "blob"); !errors.Is(err, storage.ErrBlobNotFound) {
+			t.Errorf("Stat of a deleted blob returned %v, want ErrBlobNotFound", err)
+		}
+	})
+
+	t.Run("InvalidKey", func(t *testing.T) {
+		for _, key := range []string{"", "..", "../blob", "dir/blob"} {
+			if err := store.Put(ctx, key, bytes.NewReader(data), int64(len(data))); !errors.Is(err, storage.ErrInvalidKey) {
+				t.Errorf("Put(%q) returned %v, want ErrInvalidKey", key, err)
+			}
+		}
+	})
+}
+
+func put(t *testing.T, store storage.BlobStore, key string, data []byte) {
+	t.Helper()
+	if err := store.Put(context.Background(), key, bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Fatalf("Put(%q): %v", key, err)
+	}
+}
+
+func get(t *testing.T, store storage.BlobStore, key string, offset int64, length int64) []byte {
+	t.Helper()
+	blob, err := store.Get(context.Background(), key, offset, length)
+	if err != nil {
+		t.Fatalf("Get(%q): %v", key, err)
+	}
+	defer blob.Close()
+
+	data, err := io.ReadAll(blob)
+	if err != nil {
+		t.Fatalf("Reading %q: %v", key, err)
+	}
+	return data
+}
+
+func remove(t *testing.T, store storage.BlobStore, key string) {
+	t.Helper()
+	if err := store.Delete(context.Background(), key); err != nil {
+		t.Fatalf("Delete(%q): %v", key, err)
+	}
+}
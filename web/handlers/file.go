@@ -1,11 +1,14 @@
 package handlers
 
 import (
+	er "PDFStoring/error"
 	"PDFStoring/service"
+	"errors"
 	"github.com/gofiber/fiber/v2"
 	"log"
 	"net/http"
 	"strconv"
+	"time"
 )
 
 type FileApiStruct struct {
@@ -16,6 +19,16 @@ type FileApi interface {
 	UploadFile(c *fiber.Ctx) error
 	DeleteFile(c *fiber.Ctx) error
 	ImportFile(c *fiber.Ctx) error
+	GetFile(c *fiber.Ctx) error
+	CancelFile(c *fiber.Ctx) error
+	ReparseFile(c *fiber.Ctx) error
+	GetParseResults(c *fiber.Ctx) error
+	GetParseResult(c *fiber.Ctx) error
+	SetCurrentParseResult(c *fiber.Ctx) error
+	GetPage(c *fiber.Ctx) error
+	GetPages(c *fiber.Ctx) error
+	GetOutline(c *fiber.Ctx) error
+	GetFormFields(c *fiber.Ctx) error
 }
 
 // NewFileApiService creates a new instance of FileApiStruct, which implements the FileApi interface
@@ -40,24 +53,40 @@ func (s *FileApiStruct) UploadFile(c *fiber.Ctx) error {
 		return c.Status(http.StatusBadRequest).SendString(err.Error())
 	}
 
-	const maxFileSize = 10 << 20
-	if file.Size > maxFileSize {
-		log.Println("File is too large")
-		return c.Status(http.StatusBadRequest).SendString("File is too large, it must be less than 10MB")
-	}
-
 	if file.Header.Get("Content-Type") != "application/pdf" {
 		log.Println("Invalid file type")
 		return c.Status(http.StatusBadRequest).SendString("Invalid file type, only PDF files are allowed")
 	}
 
-	fileId, err := s.fileService.UploadFile(c.Context(), userId, file)
+	priority, err := service.ParsePriority(c.FormValue("priority"))
+	if err != nil {
+		log.Printf("Error while parsing priority: %v", err)
+		return c.Status(http.StatusBadRequest).SendString(err.Error())
+	}
+
+	options := service.EnqueueOptions{
+		Priority:             priority,
+		RequiredCapabilities: service.ParseCapabilities(c.FormValue("capabilities")),
+	}
+	if value := c.FormValue("not_before"); value != "" {
+		notBefore, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			log.Printf("Error while parsing not_before: %v", err)
+			return c.Status(http.StatusBadRequest).SendString("Invalid not_before, it must be an RFC 3339 timestamp")
+		}
+		options.NotBefore = &notBefore
+	}
+
+	fileId, err := s.fileService.UploadFile(c.Context(), userId, file, options)
+	if errors.Is(err, er.ErrFileTooLarge) || errors.Is(err, er.ErrTooManyPages) {
+		return c.Status(http.StatusRequestEntityTooLarge).SendString(err.Error())
+	}
 	if err != nil {
 		log.Printf("Error uploading file: %v", err)
 		return c.Status(http.StatusInternalServerError).SendString(err.Error())
 	}
 
-	return c.Status(http.StatusCreated).SendString("File was successfully uploaded with id: " + string(fileId))
+	return c.Status(http.StatusCreated).SendString("File was successfully uploaded with id: " + strconv.Itoa(fileId))
 }
 
 func (s *FileApiStruct) DeleteFile(c *fiber.Ctx) error {
@@ -106,5 +135,66 @@ func (s *FileApiStruct) ImportFile(c *fiber.Ctx) error {
 		return c.Status(http.StatusInternalServerError).SendString(err.Error())
 	}
 
-	return c.Status(http.StatusCreated).SendString("File was successfully imported with id: " + string(fileId))
+	return c.Status(http.StatusCreated).SendString("File was successfully imported with id: " + strconv.Itoa(fileId))
+}
+
+// GetFile handles the request for the details of one file of a user
+func (s *FileApiStruct) GetFile(c *fiber.Ctx) error {
+
+	uId := c.Params("user_id")
+	fId := c.Params("file_id")
+	userId, err := strconv.Atoi(uId)
+	if err != nil {
+		log.Printf("Error while converting id to int: %v", err)
+		return c.Status(http.StatusBadRequest).SendString(err.Error())
+	}
+
+	fileId, err := strconv.Atoi(fId)
+	if err != nil {
+		log.Printf("Error while converting id to int: %v", err)
+		return c.Status(http.StatusBadRequest).SendString(err.Error())
+	}
+
+	userFile, err := s.fileService.GetFile(c.Context(), userId, fileId)
+	if errors.Is(err, er.ErrFileNotFound) {
+		return c.Status(http.StatusNotFound).SendString(err.Error())
+	}
+	if err != nil {
+		log.Printf("Error fetching file: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch file"})
+	}
+
+	return c.Status(http.StatusOK).JSON(userFile)
+}
+
+// CancelFile handles the request to stop parsing a queued or in-flight file
+func (s *FileApiStruct) CancelFile(c *fiber.Ctx) error {
+
+	uId := c.Params("user_id")
+	fId := c.Params("file_id")
+	userId, err := strconv.Atoi(uId)
+	if err != nil {
+		log.Printf("Error while converting id to int: %v", err)
+		return c.Status(http.StatusBadRequest).SendString(err.Error())
+	}
+
+	fileId, err := strconv.Atoi(fId)
+	if err != nil {
+		log.Printf("Error while converting id to int: %v", err)
+		return c.Status(http.StatusBadRequest).SendString(err.Error())
+	}
+
+	err = s.fileService.CancelFile(c.Context(), userId, fileId)
+	if errors.Is(err, er.ErrFileNotFound) {
+		return c.Status(http.StatusNotFound).SendString(err.Error())
+	}
+	if errors.Is(err, er.ErrFileFinal) {
+		return c.Status(http.StatusConflict).SendString(err.Error())
+	}
+	if err != nil {
+		log.Printf("Error cancelling file: %v", err)
+		return c.Status(http.StatusInternalServerError).SendString(err.Error())
+	}
+
+	return c.Status(http.StatusOK).SendString("File was successfully cancelled with id: " + strconv.Itoa(fileId))
 }
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	er "PDFStoring/error"
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// GetPage returns one page of the current parse result of a file, by its number starting from 1
+func (s *FileApiStruct) GetPage(c *fiber.Ctx) error {
+
+	userId, fileId, err := userFileParams(c)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).SendString(err.Error())
+	}
+
+	number, err := strconv.Atoi(c.Params("n"))
+	if err != nil || number < 1 {
+		log.Printf("Error while converting page number to int: %v", err)
+		return c.Status(http.StatusBadRequest).SendString("Invalid page number, it must be a number from 1")
+	}
+
+	page, err := s.fileService.GetPage(c.Context(), userId, fileId, number)
+	if errors.Is(err, er.ErrFileNotFound) || errors.Is(err, er.ErrNotParsed) || errors.Is(err, er.ErrPageNotFound) {
+		return c.Status(http.StatusNotFound).SendString(err.Error())
+	}
+	if err != nil {
+		log.Printf("Error fetching page: %v", err)
+		return c.Status(http.StatusInternalServerError).SendString(err.Error())
+	}
+
+	return c.Status(http.StatusOK).JSON(page)
+}
+
+// GetPages returns a range of pages of the current parse result of a file, from the page given by from, 1 by
+// default, up to the page given by to, the last page by default
+func (s *FileApiStruct) GetPages(c *fiber.Ctx) error {
+
+	userId, fileId, err := userFileParams(c)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).SendString(err.Error())
+	}
+
+	first, last := 1, 0
+	if value := c.Query("from"); value != "" {
+		first, err = strconv.Atoi(value)
+		if err != nil || first < 1 {
+			return c.Status(http.StatusBadRequest).SendString("Invalid from, it must be a page number from 1")
+		}
+	}
+	if value := c.Query("to"); value != "" {
+		last, err = strconv.Atoi(value)
+		if err != nil || last < first {
+			return c.Status(http.StatusBadRequest).SendString("Invalid to, it must be a page number not before from")
+		}
+	}
+
+	pages, err := s.fileService.GetPages(c.Context(), userId, fileId, first, last)
+	if errors.Is(err, er.ErrFileNotFound) || errors.Is(err, er.ErrNotParsed) {
+		return c.Status(http.StatusNotFound).SendString(err.Error())
+	}
+	if err != nil {
+		log.Printf("Error fetching pages: %v", err)
+		return c.Status(http.StatusInternalServerError).SendString(err.Error())
+	}
+
+	return c.Status(http.StatusOK).JSON(pages)
+}
+
+// GetOutline returns the bookmarks of the current parse result of a file as nested JSON
+func (s *FileApiStruct) GetOutline(c *fiber.Ctx) error {
+
+	userId, fileId, err := userFileParams(c)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).SendString(err.Error())
+	}
+
+	outline, err := s.fileService.GetOutline(c.Context(), userId, fileId)
+	if errors.Is(err, er.ErrFileNotFound) || errors.Is(err, er.ErrNotParsed) {
+		return c.Status(http.StatusNotFound).SendString(err.Error())
+	}
+	if err != nil {
+		log.Printf("Error fetching outline: %v", err)
+		return c.Status(http.StatusInternalServerError).SendString(err.Error())
+	}
+
+	return c.Status(http.StatusOK).JSON(outline)
+}
+
+// GetFormFields returns the form fields of the current parse result of a file with their values as JSON
+func (s *FileApiStruct) GetFormFields(c *fiber.Ctx) error {
+
+	userId, fileId, err := userFileParams(c)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).SendString(err.Error())
+	}
+
+	fields, err := s.fileService.GetFormFields(c.Context(), userId, fileId)
+	if errors.Is(err, er.ErrFileNotFound) || errors.Is(err, er.ErrNotParsed) {
+		return c.Status(http.StatusNotFound).SendString(err.Error())
+	}
+	if err != nil {
+		log.Printf("Error fetching form fields: %v", err)
+		return c.Status(http.StatusInternalServerError).SendString(err.Error())
+	}
+
+	return c.Status(http.StatusOK).JSON(fields)
+}
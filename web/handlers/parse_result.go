@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	er "PDFStoring/error"
+	"PDFStoring/service"
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ReparseFile puts a parsed file back in the queue, optionally with a priority and a not_before time
+func (s *FileApiStruct) ReparseFile(c *fiber.Ctx) error {
+
+	userId, fileId, err := userFileParams(c)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).SendString(err.Error())
+	}
+
+	priority, err := service.ParsePriority(c.FormValue("priority"))
+	if err != nil {
+		log.Printf("Error while parsing priority: %v", err)
+		return c.Status(http.StatusBadRequest).SendString(err.Error())
+	}
+
+	options := service.EnqueueOptions{
+		Priority:             priority,
+		RequiredCapabilities: service.ParseCapabilities(c.FormValue("capabilities")),
+	}
+	if value := c.FormValue("not_before"); value != "" {
+		notBefore, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			log.Printf("Error while parsing not_before: %v", err)
+			return c.Status(http.StatusBadRequest).SendString("Invalid not_before, it must be an RFC 3339 timestamp")
+		}
+		options.NotBefore = &notBefore
+	}
+
+	err = s.fileService.ReparseFile(c.Context(), userId, fileId, options)
+	if errors.Is(err, er.ErrFileNotFound) {
+		return c.Status(http.StatusNotFound).SendString(err.Error())
+	}
+	if errors.Is(err, er.ErrNotReparsable) || errors.Is(err, er.ErrPDFNotStored) {
+		return c.Status(http.StatusConflict).SendString(err.Error())
+	}
+	if err != nil {
+		log.Printf("Error reparsing file: %v", err)
+		return c.Status(http.StatusInternalServerError).SendString(err.Error())
+	}
+
+	return c.Status(http.StatusAccepted).SendString("File was queued for reparsing with id: " + strconv.Itoa(fileId))
+}
+
+// GetParseResults lists the parse results of a file, without the parsed files
+func (s *FileApiStruct) GetParseResults(c *fiber.Ctx) error {
+
+	userId, fileId, err := userFileParams(c)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).SendString(err.Error())
+	}
+
+	results, err := s.fileService.GetParseResults(c.Context(), userId, fileId)
+	if errors.Is(err, er.ErrFileNotFound) {
+		return c.Status(http.StatusNotFound).SendString(err.Error())
+	}
+	if err != nil {
+		log.Printf("Error fetching parse results: %v", err)
+		return c.Status(http.StatusInternalServerError).SendString(err.Error())
+	}
+
+	return c.Status(http.StatusOK).JSON(results)
+}
+
+// GetParseResult returns one parse result of a file, including its parsed file
+func (s *FileApiStruct) GetParseResult(c *fiber.Ctx) error {
+
+	userId, fileId, err := userFileParams(c)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).SendString(err.Error())
+	}
+
+	resultId, err := strconv.Atoi(c.Params("result_id"))
+	if err != nil {
+		log.Printf("Error while converting id to int: %v", err)
+		return c.Status(http.StatusBadRequest).SendString(err.Error())
+	}
+
+	result, err := s.fileService.GetParseResult(c.Context(), userId, fileId, resultId)
+	if errors.Is(err, er.ErrFileNotFound) || errors.Is(err, er.ErrParseResultNotFound) {
+		return c.Status(http.StatusNotFound).SendString(err.Error())
+	}
+	if err != nil {
+		log.Printf("Error fetching parse result: %v", err)
+		return c.Status(http.StatusInternalServerError).SendString(err.Error())
+	}
+
+	return c.Status(http.StatusOK).JSON(result)
+}
+
+// SetCurrentParseResult chooses which parse result of a file is served as its parsed file
+func (s *FileApiStruct) SetCurrentParseResult(c *fiber.Ctx) error {
+
+	userId, fileId, err := userFileParams(c)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).SendString(err.Error())
+	}
+
+	resultId, err := strconv.Atoi(c.Params("result_id"))
+	if err != nil {
+		log.Printf("Error while converting id to int: %v", err)
+		return c.Status(http.StatusBadRequest).SendString(err.Error())
+	}
+
+	err = s.fileService.SetCurrentParseResult(c.Context(), userId, fileId, resultId)
+	if errors.Is(err, er.ErrFileNotFound) || errors.Is(err, er.ErrParseResultNotFound) {
+		return c.Status(http.StatusNotFound).SendString(err.Error())
+	}
+	if err != nil {
+		log.Printf("Error setting current parse result: %v", err)
+		return c.Status(http.StatusInternalServerError).SendString(err.Error())
+	}
+
+	return c.Status(http.StatusOK).SendString("Parse result " + strconv.Itoa(resultId) + " is now current for file: " + strconv.Itoa(fileId))
+}
+
+// userFileParams reads the user and file ids of the /file/:user_id/:file_id routes
+func userFileParams(c *fiber.Ctx) (int, int, error) {
+	userId, err := strconv.Atoi(c.Params("user_id"))
+	if err != nil {
+		log.Printf("Error while converting id to int: %v", err)
+		return 0, 0, err
+	}
+
+	fileId, err := strconv.Atoi(c.Params("file_id"))
+	if err != nil {
+		log.Printf("Error while converting id to int: %v", err)
+		return 0, 0, err
+	}
+
+	return userId, fileId, nil
+}
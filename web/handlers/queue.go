@@ -1,11 +1,15 @@
 package handlers
 
 import (
+	er "PDFStoring/error"
 	"PDFStoring/models"
 	"PDFStoring/service"
 	"encoding/json"
+	"errors"
 	"github.com/gofiber/fiber/v2"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type QueueApiStruct struct {
@@ -14,7 +18,19 @@ type QueueApiStruct struct {
 
 type QueueApi interface {
 	GetQueue(c *fiber.Ctx) error
+	DownloadFile(c *fiber.Ctx) error
 	UploadFile(c *fiber.Ctx) error
+	GetDeadLetters(c *fiber.Ctx) error
+	RequeueDeadLetter(c *fiber.Ctx) error
+	UploadFiles(c *fiber.Ctx) error
+	ReportProgress(c *fiber.Ctx) error
+	ExtendLease(c *fiber.Ctx) error
+	GetLaneDepths(c *fiber.Ctx) error
+	GetQueueStats(c *fiber.Ctx) error
+	GetQueueControl(c *fiber.Ctx) error
+	PauseQueue(c *fiber.Ctx) error
+	ResumeQueue(c *fiber.Ctx) error
+	DrainQueue(c *fiber.Ctx) error
 }
 
 // NewQueueApiService creates a new instance of QueueApiStruct, which implements the QueueApi interface
@@ -24,14 +40,228 @@ func NewQueueApiService(queueService service.QueueService) QueueApi {
 	}
 }
 
+// maxQueueWait caps how long a worker can long-poll the queue in a single request
+const maxQueueWait = 60 * time.Second
+
+// pausedRetryAfter is how long workers are asked to back off while the queue is paused
+const pausedRetryAfter = 30 * time.Second
+
+// defaultStatsWindow is the sliding window of the queue statistics when none is requested
+const defaultStatsWindow = 15 * time.Minute
+
+// maxBatchSize caps how many files a worker can claim in a single request
+const maxBatchSize = 100
+
+// GetQueue claims the next file from the queue, returning the lease token and expiry in the response headers.
+// Registered workers claim under their id with ?worker_id=. With ?max=N up to N files are claimed at once and
+// returned as a JSON array of jobs. With a wait parameter such as ?wait=30s the request blocks until a file is
+// queued or the wait runs out. An empty queue is answered with 204 No Content.
 func (s *QueueApiStruct) GetQueue(c *fiber.Ctx) error {
 
-	fileId, fileData, err := s.queueService.GetNextFile(c.Context())
+	var options service.ClaimOptions
+	if value := c.Query("worker_id"); value != "" {
+		workerId, err := strconv.Atoi(value)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+		}
+		options.WorkerID = workerId
+	}
+	if c.Context().QueryArgs().Has("capabilities") {
+		options.Capabilities = service.ParseCapabilities(c.Query("capabilities"))
+	}
+
+	var wait time.Duration
+	if value := c.Query("wait"); value != "" {
+		var err error
+		wait, err = time.ParseDuration(value)
+		if err != nil || wait < 0 {
+			return c.Status(fiber.StatusBadRequest).SendString("Invalid wait, it must be a duration such as 30s")
+		}
+		if wait > maxQueueWait {
+			wait = maxQueueWait
+		}
+	}
+
+	limit := 1
+	batch := c.Query("max") != ""
+	if batch {
+		var err error
+		limit, err = strconv.Atoi(c.Query("max"))
+		if err != nil || limit < 1 {
+			return c.Status(fiber.StatusBadRequest).SendString("Invalid max, it must be a positive number")
+		}
+		if limit > maxBatchSize {
+			limit = maxBatchSize
+		}
+	}
+
+	var jobs []models.Job
+	var err error
+	if wait > 0 {
+		jobs, err = s.queueService.WaitForFiles(c.Context(), options, limit, wait)
+	} else {
+		jobs, err = s.queueService.ClaimFiles(c.Context(), options, limit)
+	}
+	if errors.Is(err, er.ErrQueueEmpty) {
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+	if errors.Is(err, er.ErrQueuePaused) {
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(pausedRetryAfter.Seconds())))
+		return c.Status(fiber.StatusServiceUnavailable).SendString(err.Error())
+	}
+	if errors.Is(err, er.ErrWorkerNotFound) {
+		return c.Status(fiber.StatusNotFound).SendString(err.Error())
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	for i := range jobs {
+		jobs[i].DownloadURL = c.BaseURL() + "/queue/" + strconv.Itoa(jobs[i].FileID) + "/pdf"
+	}
+
+	if batch {
+		return c.Status(fiber.StatusOK).JSON(jobs)
+	}
+
+	job := jobs[0]
+	if c.Query("format") == "json" {
+		return c.Status(fiber.StatusOK).JSON(job)
+	}
+
+	setJobHeaders(c, job)
+	c.Set("X-Required-Capabilities", strings.Join(job.RequiredCapabilities, ","))
+	if job.MaxPages > 0 {
+		c.Set("X-Max-Pages", strconv.Itoa(job.MaxPages))
+	}
+	if job.ParseDeadline != nil {
+		c.Set("X-Parse-Deadline", job.ParseDeadline.Format(time.RFC3339))
+	}
+	c.Set("X-Download-Url", job.DownloadURL)
+
+	// The file stays leased when it cannot be sent, the worker can still download it from the download URL
+	blob, err := s.queueService.ReadJobFile(c.Context(), job, 0, -1)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	c.Set(fiber.HeaderContentType, "application/pdf")
+	return c.Status(fiber.StatusOK).SendStream(blob, int(job.FileSize))
+}
+
+// DownloadFile sends the PDF of a leased file to the worker holding the lease, given in the X-Lease-Token
+// header or the lease_token query parameter. A single byte range can be requested to resume a download.
+func (s *QueueApiStruct) DownloadFile(c *fiber.Ctx) error {
+	fileId, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+
+	leaseToken := c.Get("X-Lease-Token")
+	if leaseToken == "" {
+		leaseToken = c.Query("lease_token")
+	}
+	if leaseToken == "" {
+		return c.Status(fiber.StatusBadRequest).SendString(er.ErrLeaseTokenRequired.Error())
+	}
+
+	job, err := s.queueService.GetLeasedFile(c.Context(), fileId, leaseToken)
+	if errors.Is(err, er.ErrJobCancelled) || errors.Is(err, er.ErrLimitExceeded) {
+		return c.Status(fiber.StatusGone).SendString(err.Error())
+	}
+	if errors.Is(err, er.ErrNotLeased) {
+		return c.Status(fiber.StatusConflict).SendString(err.Error())
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	setJobHeaders(c, *job)
+	c.Set(fiber.HeaderContentType, "application/pdf")
+	c.Set(fiber.HeaderAcceptRanges, "bytes")
+	c.Set(fiber.HeaderETag, `"`+job.FileHash+`"`)
+
+	size := job.FileSize
+	header := c.Get(fiber.HeaderRange)
+	// A range is only served for the file it was requested for, a changed file is sent whole
+	if ifRange := c.Get(fiber.HeaderIfRange); ifRange != "" && ifRange != `"`+job.FileHash+`"` {
+		header = ""
+	}
+	if header == "" {
+		blob, err := s.queueService.ReadJobFile(c.Context(), *job, 0, -1)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+		}
+		return c.Status(fiber.StatusOK).SendStream(blob, int(size))
+	}
+
+	start, end, ok := parseByteRange(header, size)
+	if !ok {
+		c.Set(fiber.HeaderContentRange, "bytes */"+strconv.FormatInt(size, 10))
+		return c.SendStatus(fiber.StatusRequestedRangeNotSatisfiable)
+	}
+
+	blob, err := s.queueService.ReadJobFile(c.Context(), *job, start, end-start+1)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
 	}
 
-	return c.Status(fiber.StatusOK).SendString("File ID: " + string(fileId) + " File Data: " + string(fileData))
+	c.Set(fiber.HeaderContentRange, "bytes "+strconv.FormatInt(start, 10)+"-"+strconv.FormatInt(end, 10)+"/"+strconv.FormatInt(size, 10))
+	return c.Status(fiber.StatusPartialContent).SendStream(blob, int(end-start+1))
+}
+
+// setJobHeaders describes a job in the response headers, when its PDF is the response body
+func setJobHeaders(c *fiber.Ctx, job models.Job) {
+	c.Set("X-File-Id", strconv.Itoa(job.FileID))
+	c.Set("X-File-Hash", job.FileHash)
+	c.Set("X-Priority", job.Priority)
+	c.Set("X-Lease-Token", job.LeaseToken)
+	c.Set("X-Lease-Expiry", job.LeaseExpiry.Format(time.RFC3339))
+	c.Set("X-Attempt", strconv.Itoa(job.Attempt))
+}
+
+// parseByteRange parses a Range header asking for a single byte range, such as "bytes=100-", "bytes=100-199"
+// or "bytes=-100", into the first and last byte to send from a body of the given size
+func parseByteRange(header string, size int64) (int64, int64, bool) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, 0, false
+	}
+
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, 0, false
+	}
+
+	if first == "" {
+		// A suffix range asks for the last bytes of the body
+		suffix, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || suffix <= 0 || size == 0 {
+			return 0, 0, false
+		}
+		if suffix > size {
+			suffix = size
+		}
+		return size - suffix, size - 1, true
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, false
+	}
+
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, false
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+
+	return start, end, true
 }
 
 func (s *QueueApiStruct) UploadFile(c *fiber.Ctx) error {
@@ -48,10 +278,214 @@ func (s *QueueApiStruct) UploadFile(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
 	}
 
+	// The lease can also be sent back in the headers it was handed out in
+	if parsedFileData.LeaseToken == "" {
+		parsedFileData.LeaseToken = c.Get("X-Lease-Token")
+	}
+	if parsedFileData.Attempt == 0 && c.Get("X-Attempt") != "" {
+		parsedFileData.Attempt, err = strconv.Atoi(c.Get("X-Attempt"))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString("Invalid X-Attempt, it must be a number")
+		}
+	}
+
 	err = s.queueService.UploadParsedFile(c.Context(), fileId, parsedFileData)
+	if errors.Is(err, er.ErrLeaseTokenRequired) || errors.Is(err, er.ErrInvalidPages) || errors.Is(err, er.ErrInvalidMetadata) ||
+		errors.Is(err, er.ErrInvalidOutline) || errors.Is(err, er.ErrInvalidFormFields) {
+		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+	if errors.Is(err, er.ErrJobCancelled) || errors.Is(err, er.ErrLimitExceeded) {
+		return c.Status(fiber.StatusGone).SendString(err.Error())
+	}
+	if errors.Is(err, er.ErrNotLeased) || errors.Is(err, er.ErrStaleLease) || errors.Is(err, er.ErrDuplicateSubmission) {
+		return c.Status(fiber.StatusConflict).SendString(err.Error())
+	}
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
 	}
 
-	return c.Status(fiber.StatusCreated).SendString("File was successfully added to queue")
+	return c.Status(fiber.StatusCreated).SendString("Parse result was successfully recorded for file: " + strconv.Itoa(fileId))
+}
+
+// UploadFiles accepts a batch of results, one per claimed file, and reports which of them were accepted
+func (s *QueueApiStruct) UploadFiles(c *fiber.Ctx) error {
+
+	var results []models.BatchResult
+	err := json.Unmarshal(c.Body(), &results)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+
+	if len(results) == 0 {
+		return c.Status(fiber.StatusBadRequest).SendString("Batch does not contain any results")
+	}
+
+	outcomes := s.queueService.UploadParsedFiles(c.Context(), results)
+
+	return c.Status(fiber.StatusOK).JSON(outcomes)
+}
+
+// ReportProgress records the pages processed by the worker holding the lease of a file
+func (s *QueueApiStruct) ReportProgress(c *fiber.Ctx) error {
+	id := c.Params("id")
+	fileId, err := strconv.Atoi(id)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+
+	var report models.ProgressReport
+	err = json.Unmarshal(c.Body(), &report)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+
+	if report.LeaseToken == "" {
+		return c.Status(fiber.StatusBadRequest).SendString("Lease token is required")
+	}
+	if report.TotalPages <= 0 || report.PagesProcessed < 0 || report.PagesProcessed > report.TotalPages {
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid progress, pages processed must be between 0 and total pages")
+	}
+
+	err = s.queueService.ReportProgress(c.Context(), fileId, report)
+	if errors.Is(err, er.ErrJobCancelled) || errors.Is(err, er.ErrLimitExceeded) {
+		return c.Status(fiber.StatusGone).SendString(err.Error())
+	}
+	if errors.Is(err, er.ErrNotLeased) {
+		return c.Status(fiber.StatusConflict).SendString(err.Error())
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// ExtendLease keeps a file leased to the worker holding the lease, given in the X-Lease-Token header or the
+// lease_token query parameter, for another lease duration. The new expiry is returned in the X-Lease-Expiry
+// header and the response body.
+func (s *QueueApiStruct) ExtendLease(c *fiber.Ctx) error {
+	fileId, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+
+	leaseToken := c.Get("X-Lease-Token")
+	if leaseToken == "" {
+		leaseToken = c.Query("lease_token")
+	}
+	if leaseToken == "" {
+		return c.Status(fiber.StatusBadRequest).SendString(er.ErrLeaseTokenRequired.Error())
+	}
+
+	leaseExpiry, err := s.queueService.ExtendLease(c.Context(), fileId, leaseToken)
+	if errors.Is(err, er.ErrJobCancelled) || errors.Is(err, er.ErrLimitExceeded) {
+		return c.Status(fiber.StatusGone).SendString(err.Error())
+	}
+	if errors.Is(err, er.ErrNotLeased) {
+		return c.Status(fiber.StatusConflict).SendString(err.Error())
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	c.Set("X-Lease-Expiry", leaseExpiry.Format(time.RFC3339))
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"lease_expiry": leaseExpiry})
+}
+
+// GetDeadLetters lists the files that failed every parse attempt
+func (s *QueueApiStruct) GetDeadLetters(c *fiber.Ctx) error {
+
+	deadLetters, err := s.queueService.GetDeadLetters(c.Context())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch dead letters"})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(deadLetters)
+}
+
+// RequeueDeadLetter puts a file from the dead-letter queue back in the queue
+func (s *QueueApiStruct) RequeueDeadLetter(c *fiber.Ctx) error {
+	id := c.Params("id")
+	fileId, err := strconv.Atoi(id)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+
+	err = s.queueService.RequeueDeadLetter(c.Context(), fileId)
+	if errors.Is(err, er.ErrNotDeadLettered) {
+		return c.Status(fiber.StatusNotFound).SendString(err.Error())
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	return c.Status(fiber.StatusOK).SendString("File was successfully requeued with id: " + strconv.Itoa(fileId))
+}
+
+// GetLaneDepths reports how many files are waiting in and leased from each priority lane
+func (s *QueueApiStruct) GetLaneDepths(c *fiber.Ctx) error {
+
+	laneDepths, err := s.queueService.GetLaneDepths(c.Context())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch lane depths"})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(laneDepths)
+}
+
+// GetQueueStats reports the backlog and throughput of the pipeline, over the sliding window given as ?window=
+func (s *QueueApiStruct) GetQueueStats(c *fiber.Ctx) error {
+
+	window := defaultStatsWindow
+	if value := c.Query("window"); value != "" {
+		var err error
+		window, err = time.ParseDuration(value)
+		if err != nil || window < time.Minute {
+			return c.Status(fiber.StatusBadRequest).SendString("Invalid window, it must be a duration of at least 1m")
+		}
+	}
+
+	stats, err := s.queueService.GetQueueStats(c.Context(), window)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch queue stats"})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(stats)
+}
+
+// GetQueueControl reports whether the queue is running, paused or draining, and whether a drain has finished
+func (s *QueueApiStruct) GetQueueControl(c *fiber.Ctx) error {
+
+	control, err := s.queueService.GetQueueControl(c.Context())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch queue state"})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(control)
+}
+
+// PauseQueue stops handing out files to workers, uploads are still queued
+func (s *QueueApiStruct) PauseQueue(c *fiber.Ctx) error {
+	return s.setQueueState(c, service.QueuePaused)
+}
+
+// ResumeQueue hands out files to workers again
+func (s *QueueApiStruct) ResumeQueue(c *fiber.Ctx) error {
+	return s.setQueueState(c, service.QueueRunning)
+}
+
+// DrainQueue stops handing out files and lets the workers finish the leased ones, the drain is done once
+// GetQueueControl reports the queue as drained
+func (s *QueueApiStruct) DrainQueue(c *fiber.Ctx) error {
+	return s.setQueueState(c, service.QueueDraining)
+}
+
+func (s *QueueApiStruct) setQueueState(c *fiber.Ctx, state service.QueueState) error {
+
+	control, err := s.queueService.SetQueueState(c.Context(), state)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update queue state"})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(control)
 }
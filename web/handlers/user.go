@@ -1,11 +1,17 @@
 package handlers
 
 import (
+	er "PDFStoring/error"
+	"PDFStoring/models"
 	"PDFStoring/service"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/gofiber/fiber/v2"
 	"log"
 	"net/http"
 	"strconv"
+	"time"
 )
 
 type UserApiStruct struct {
@@ -15,6 +21,7 @@ type UserApiStruct struct {
 type UserApi interface {
 	CreateUser(c *fiber.Ctx) error
 	GetUserFiles(c *fiber.Ctx) error
+	SetUserLimits(c *fiber.Ctx) error
 }
 
 // NewUserApiService creates a new instance of UserApiStruct, which implements the UserApi interface
@@ -29,11 +36,11 @@ func (s *UserApiStruct) CreateUser(c *fiber.Ctx) error {
 
 	userId, err := s.userService.CreateUser(c.Context())
 	if err != nil {
-		log.Println("Error while creating user: %v", err)
+		log.Printf("Error while creating user: %v", err)
 		return c.Status(http.StatusInternalServerError).SendString(err.Error())
 	}
 
-	return c.Status(http.StatusCreated).SendString("User was successfully created with id: " + string(userId))
+	return c.Status(http.StatusCreated).SendString("User was successfully created with id: " + strconv.Itoa(userId))
 }
 
 func (s *UserApiStruct) GetUserFiles(c *fiber.Ctx) error {
@@ -45,7 +52,13 @@ func (s *UserApiStruct) GetUserFiles(c *fiber.Ctx) error {
 		return c.Status(http.StatusBadRequest).SendString(err.Error())
 	}
 
-	userFiles, err := s.userService.GetUserFiles(c.Context(), userId)
+	filter, err := fileFilterParams(c)
+	if err != nil {
+		log.Printf("Error while parsing file filter: %v", err)
+		return c.Status(http.StatusBadRequest).SendString(err.Error())
+	}
+
+	userFiles, err := s.userService.GetUserFiles(c.Context(), userId, filter)
 	if err != nil {
 		log.Printf("Error fetching user files: %v", err)
 		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch user files"})
@@ -53,3 +66,122 @@ func (s *UserApiStruct) GetUserFiles(c *fiber.Ctx) error {
 
 	return c.Status(http.StatusOK).JSON(userFiles)
 }
+
+// fileFilterParams reads the filter and order of a file listing from the query: title, author and producer
+// match part of the metadata, pdf_version matches it exactly, created_after, created_before, modified_after and
+// modified_before are RFC 3339 timestamps, min_pages and max_pages bound the page count, and sort and order
+// choose how the files are listed
+func fileFilterParams(c *fiber.Ctx) (service.FileFilter, error) {
+	filter := service.FileFilter{
+		Title:      c.Query("title"),
+		Author:     c.Query("author"),
+		Producer:   c.Query("producer"),
+		PDFVersion: c.Query("pdf_version"),
+	}
+
+	times := []struct {
+		key    string
+		target **time.Time
+	}{
+		{"created_after", &filter.CreatedAfter},
+		{"created_before", &filter.CreatedBefore},
+		{"modified_after", &filter.ModifiedAfter},
+		{"modified_before", &filter.ModifiedBefore},
+	}
+	for _, param := range times {
+		key, target := param.key, param.target
+		value := c.Query(key)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return filter, errors.New("Invalid " + key + ", it must be an RFC 3339 timestamp")
+		}
+		*target = &t
+	}
+
+	pages := []struct {
+		key    string
+		target *int
+	}{
+		{"min_pages", &filter.MinPages},
+		{"max_pages", &filter.MaxPages},
+	}
+	for _, param := range pages {
+		key, target := param.key, param.target
+		value := c.Query(key)
+		if value == "" {
+			continue
+		}
+		number, err := strconv.Atoi(value)
+		if err != nil || number < 0 {
+			return filter, errors.New("Invalid " + key + ", it must be a number of pages")
+		}
+		*target = number
+	}
+
+	var err error
+	filter.Sort, err = service.ParseFileSort(c.Query("sort"))
+	if err != nil {
+		return filter, err
+	}
+	switch c.Query("order") {
+	case "":
+		// The newest uploads are listed first by default, other columns are listed in ascending order
+		filter.Descending = filter.Sort == service.SortUploadDate
+	case "asc":
+	case "desc":
+		filter.Descending = true
+	default:
+		return filter, errors.New("Invalid order, it must be asc or desc")
+	}
+
+	return filter, nil
+}
+
+// SetUserLimits handles the request to override the queue settings for one user
+func (s *UserApiStruct) SetUserLimits(c *fiber.Ctx) error {
+
+	id := c.Params("id")
+	userId, err := strconv.Atoi(id)
+	if err != nil {
+		log.Printf("Error while converting id to int: %v", err)
+		return c.Status(http.StatusBadRequest).SendString(err.Error())
+	}
+
+	var limits models.UserLimits
+	err = json.Unmarshal(c.Body(), &limits)
+	if err != nil {
+		log.Printf("Error while decoding user limits: %v", err)
+		return c.Status(http.StatusBadRequest).SendString(err.Error())
+	}
+
+	if limits.MaxInFlight != nil && *limits.MaxInFlight < 0 {
+		return c.Status(http.StatusBadRequest).SendString("max_in_flight must not be negative")
+	}
+	if limits.MaxFileBytes != nil && *limits.MaxFileBytes < 0 {
+		return c.Status(http.StatusBadRequest).SendString("max_file_bytes must not be negative")
+	}
+	// Larger uploads are turned away by the request body limit before the limits of their user are checked
+	if bodyLimit := c.App().Config().BodyLimit; limits.MaxFileBytes != nil && *limits.MaxFileBytes > int64(bodyLimit) {
+		return c.Status(http.StatusBadRequest).SendString(fmt.Sprintf("max_file_bytes must not be above the request body limit of %d bytes", bodyLimit))
+	}
+	if limits.MaxPages != nil && *limits.MaxPages < 0 {
+		return c.Status(http.StatusBadRequest).SendString("max_pages must not be negative")
+	}
+	if limits.MaxParseSeconds != nil && *limits.MaxParseSeconds < 0 {
+		return c.Status(http.StatusBadRequest).SendString("max_parse_seconds must not be negative")
+	}
+
+	err = s.userService.SetUserLimits(c.Context(), userId, limits)
+	if errors.Is(err, er.ErrUserNotFound) {
+		return c.Status(http.StatusNotFound).SendString(err.Error())
+	}
+	if err != nil {
+		log.Printf("Error updating user limits: %v", err)
+		return c.Status(http.StatusInternalServerError).SendString(err.Error())
+	}
+
+	return c.Status(http.StatusOK).JSON(limits)
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	er "PDFStoring/error"
+	"PDFStoring/models"
+	"PDFStoring/service"
+	"encoding/json"
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+type WorkerApiStruct struct {
+	workerService service.WorkerService
+}
+
+type WorkerApi interface {
+	RegisterWorker(c *fiber.Ctx) error
+	Heartbeat(c *fiber.Ctx) error
+	GetWorkers(c *fiber.Ctx) error
+}
+
+// NewWorkerApiService creates a new instance of WorkerApiStruct, which implements the WorkerApi interface
+func NewWorkerApiService(workerService service.WorkerService) WorkerApi {
+	return &WorkerApiStruct{
+		workerService: workerService,
+	}
+}
+
+// RegisterWorker handles the request of a worker announcing its name, version and capabilities
+func (s *WorkerApiStruct) RegisterWorker(c *fiber.Ctx) error {
+
+	var worker models.Worker
+	err := json.Unmarshal(c.Body(), &worker)
+	if err != nil {
+		log.Printf("Error while decoding worker: %v", err)
+		return c.Status(http.StatusBadRequest).SendString(err.Error())
+	}
+
+	if worker.Name == "" {
+		return c.Status(http.StatusBadRequest).SendString("Worker name is required")
+	}
+
+	workerId, err := s.workerService.RegisterWorker(c.Context(), worker)
+	if err != nil {
+		log.Printf("Error registering worker: %v", err)
+		return c.Status(http.StatusInternalServerError).SendString(err.Error())
+	}
+
+	return c.Status(http.StatusCreated).JSON(fiber.Map{"id": workerId})
+}
+
+// Heartbeat handles the periodic sign of life of a registered worker, answering with the files it should stop parsing
+func (s *WorkerApiStruct) Heartbeat(c *fiber.Ctx) error {
+
+	id := c.Params("id")
+	workerId, err := strconv.Atoi(id)
+	if err != nil {
+		log.Printf("Error while converting id to int: %v", err)
+		return c.Status(http.StatusBadRequest).SendString(err.Error())
+	}
+
+	heartbeat, err := s.workerService.Heartbeat(c.Context(), workerId)
+	if errors.Is(err, er.ErrWorkerNotFound) {
+		return c.Status(http.StatusNotFound).SendString(err.Error())
+	}
+	if err != nil {
+		log.Printf("Error recording heartbeat: %v", err)
+		return c.Status(http.StatusInternalServerError).SendString(err.Error())
+	}
+
+	return c.Status(http.StatusOK).JSON(heartbeat)
+}
+
+// GetWorkers lists the registered workers with their last-seen times and current jobs
+func (s *WorkerApiStruct) GetWorkers(c *fiber.Ctx) error {
+
+	workers, err := s.workerService.GetWorkers(c.Context())
+	if err != nil {
+		log.Printf("Error fetching workers: %v", err)
+		return c.Status(http.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch workers"})
+	}
+
+	return c.Status(http.StatusOK).JSON(workers)
+}
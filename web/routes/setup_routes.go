@@ -5,24 +5,54 @@ import (
 	"github.com/gofiber/fiber/v2"
 )
 
-func SetupRoutes(app *fiber.App, userHendler handlers.UserApi, fileHandler handlers.FileApi, queueHandler handlers.QueueApi) {
+func SetupRoutes(app *fiber.App, userHendler handlers.UserApi, fileHandler handlers.FileApi, queueHandler handlers.QueueApi, workerHandler handlers.WorkerApi) {
 	setupUserRoutes(app, userHendler)
 	setupFileRoutes(app, fileHandler)
 	setupQueueRoutes(app, queueHandler)
+	setupWorkerRoutes(app, workerHandler)
 }
 
 func setupUserRoutes(app *fiber.App, handler handlers.UserApi) {
 	app.Post("/user", handler.CreateUser)
 	app.Get("/user/:id", handler.GetUserFiles)
+	app.Put("/user/:id/limits", handler.SetUserLimits)
 }
 
 func setupFileRoutes(app *fiber.App, handler handlers.FileApi) {
 	app.Post("/file/:id", handler.UploadFile)
+	app.Get("/file/:user_id/:file_id", handler.GetFile)
 	app.Delete("/file/:user_id/file_id/delete", handler.DeleteFile)
 	app.Post("/file/:user_id/:file_id/import", handler.ImportFile)
+	app.Post("/file/:user_id/:file_id/cancel", handler.CancelFile)
+	app.Post("/file/:user_id/:file_id/reparse", handler.ReparseFile)
+	app.Get("/file/:user_id/:file_id/results", handler.GetParseResults)
+	app.Get("/file/:user_id/:file_id/results/:result_id", handler.GetParseResult)
+	app.Put("/file/:user_id/:file_id/results/:result_id/current", handler.SetCurrentParseResult)
+	app.Get("/file/:user_id/:file_id/pages", handler.GetPages)
+	app.Get("/file/:user_id/:file_id/pages/:n", handler.GetPage)
+	app.Get("/file/:user_id/:file_id/outline", handler.GetOutline)
+	app.Get("/file/:user_id/:file_id/fields", handler.GetFormFields)
 }
 
 func setupQueueRoutes(app *fiber.App, handler handlers.QueueApi) {
 	app.Get("/queue/", handler.GetQueue)
-	app.Get("/queue/:id", handler.UploadFile)
+	app.Post("/queue/batch", handler.UploadFiles)
+	app.Get("/queue/lanes", handler.GetLaneDepths)
+	app.Get("/queue/stats", handler.GetQueueStats)
+	app.Get("/queue/control", handler.GetQueueControl)
+	app.Post("/queue/pause", handler.PauseQueue)
+	app.Post("/queue/resume", handler.ResumeQueue)
+	app.Post("/queue/drain", handler.DrainQueue)
+	app.Get("/queue/dead-letter", handler.GetDeadLetters)
+	app.Post("/queue/dead-letter/:id/requeue", handler.RequeueDeadLetter)
+	app.Post("/queue/:id", handler.UploadFile)
+	app.Get("/queue/:id/pdf", handler.DownloadFile)
+	app.Post("/queue/:id/progress", handler.ReportProgress)
+	app.Post("/queue/:id/extend", handler.ExtendLease)
+}
+
+func setupWorkerRoutes(app *fiber.App, handler handlers.WorkerApi) {
+	app.Post("/workers", handler.RegisterWorker)
+	app.Get("/workers", handler.GetWorkers)
+	app.Post("/workers/:id/heartbeat", handler.Heartbeat)
 }
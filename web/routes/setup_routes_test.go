@@ -0,0 +1,71 @@
+package routes
+
+import (
+	er "PDFStoring/error"
+	"PDFStoring/models"
+	"PDFStoring/service"
+	"PDFStoring/web/handlers"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// fakeQueueService answers result submissions with a fixed error, and records the last one
+type fakeQueueService struct {
+	service.QueueService
+
+	err        error
+	fileId     int
+	parsedData models.Parser
+}
+
+func (s *fakeQueueService) UploadParsedFile(ctx context.Context, fileId int, parsedData models.Parser) error {
+	s.fileId, s.parsedData = fileId, parsedData
+	return s.err
+}
+
+func TestUploadParsedFile(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		err    error
+		status int
+	}{
+		{"Recorded", http.MethodPost, nil, fiber.StatusCreated},
+		{"StaleLease", http.MethodPost, er.ErrStaleLease, fiber.StatusConflict},
+		{"DuplicateSubmission", http.MethodPost, er.ErrDuplicateSubmission, fiber.StatusConflict},
+		{"Cancelled", http.MethodPost, er.ErrJobCancelled, fiber.StatusGone},
+		{"Get", http.MethodGet, nil, fiber.StatusMethodNotAllowed},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			queueService := &fakeQueueService{err: test.err}
+			app := fiber.New()
+			setupQueueRoutes(app, handlers.NewQueueApiService(queueService))
+
+			body := `{"lease_token": "lease", "attempt": 2, "parsed_status": "success", "parsed_file": "text"}`
+			req := httptest.NewRequest(test.method, "/queue/7", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("Test: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != test.status {
+				reply, _ := io.ReadAll(resp.Body)
+				t.Fatalf("status = %d (%s), want %d", resp.StatusCode, reply, test.status)
+			}
+			if test.method == http.MethodPost && (queueService.fileId != 7 || queueService.parsedData.LeaseToken != "lease" || queueService.parsedData.Attempt != 2) {
+				t.Errorf("submitted file %d with lease %q and attempt %d, want file 7 with lease %q and attempt 2",
+					queueService.fileId, queueService.parsedData.LeaseToken, queueService.parsedData.Attempt, "lease")
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"PDFStoring/database"
+	"PDFStoring/queue"
+	"PDFStoring/service"
+	"PDFStoring/storage"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// defaultBlobDir is where the uploaded PDFs are kept when the local blob store is used
+const defaultBlobDir = "blobs"
+
+// blobStoreFromEnv opens the blob store selected by BLOB_STORE, a local directory by default or an S3 bucket
+func blobStoreFromEnv() (storage.BlobStore, error) {
+	switch os.Getenv("BLOB_STORE") {
+	case "", "local":
+		dir := os.Getenv("BLOB_STORE_DIR")
+		if dir == "" {
+			dir = defaultBlobDir
+		}
+		return storage.NewLocalStore(dir)
+	case "s3":
+		pathStyle, _ := strconv.ParseBool(os.Getenv("S3_PATH_STYLE"))
+		return storage.NewS3Store(storage.S3Config{
+			Endpoint:        os.Getenv("S3_ENDPOINT"),
+			Region:          os.Getenv("S3_REGION"),
+			Bucket:          os.Getenv("S3_BUCKET"),
+			AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+			PathStyle:       pathStyle,
+		})
+	default:
+		return nil, errors.New("BLOB_STORE must be local or s3")
+	}
+}
+
+// queueBackendFromEnv opens the queue backend selected by QUEUE_BACKEND, the Postgres tables by default or an
+// in-memory queue, which is only suited to a single API instance and is lost on restart. The in-memory queue
+// does not remove the need for Postgres: the files, users, workers and parse results are kept there either way.
+func queueBackendFromEnv(db database.DatabaseService, config queue.Config) (queue.Backend, error) {
+	switch os.Getenv("QUEUE_BACKEND") {
+	case "", "postgres":
+		return queue.NewPostgres(db.GetPool(), config), nil
+	case "memory":
+		log.Println("Keeping the queue in memory, the files, users and results are still stored in PostgreSQL")
+		return queue.NewMemory(config), nil
+	default:
+		return nil, errors.New("QUEUE_BACKEND must be postgres or memory")
+	}
+}
+
+// queueConfigFromEnv builds the queue configuration from the environment, keeping the defaults for unset values
+func queueConfigFromEnv() service.QueueConfig {
+	config := service.DefaultQueueConfig()
+	config.LeaseDuration = durationFromEnv("QUEUE_LEASE_DURATION", config.LeaseDuration)
+	config.ReaperInterval = durationFromEnv("QUEUE_REAPER_INTERVAL", config.ReaperInterval)
+	config.MaxAttempts = intFromEnv("QUEUE_MAX_ATTEMPTS", config.MaxAttempts)
+	config.RetryBaseDelay = durationFromEnv("QUEUE_RETRY_BASE_DELAY", config.RetryBaseDelay)
+	config.RetryMaxDelay = durationFromEnv("QUEUE_RETRY_MAX_DELAY", config.RetryMaxDelay)
+	config.WorkerHeartbeatTimeout = durationFromEnv("QUEUE_WORKER_HEARTBEAT_TIMEOUT", config.WorkerHeartbeatTimeout)
+	config.SubmissionRetention = durationFromEnv("QUEUE_SUBMISSION_RETENTION", config.SubmissionRetention)
+	config.UserMaxInFlight = limitFromEnv("QUEUE_USER_MAX_IN_FLIGHT", config.UserMaxInFlight)
+	config.PriorityAgingInterval = durationFromEnv("QUEUE_PRIORITY_AGING_INTERVAL", config.PriorityAgingInterval)
+	config.Limits.MaxFileBytes = int64(limitFromEnv("LIMIT_MAX_FILE_BYTES", int(config.Limits.MaxFileBytes)))
+	config.Limits.MaxPages = limitFromEnv("LIMIT_MAX_PAGES", config.Limits.MaxPages)
+	config.Limits.MaxParseTime = limitDurationFromEnv("LIMIT_MAX_PARSE_TIME", config.Limits.MaxParseTime)
+	return config
+}
+
+// parseWorkerConfigFromEnv builds the settings of the in-process parse workers from the environment, they are
+// disabled unless PARSE_WORKERS is set. The workers are limited to half of the database connections left by the
+// queue listener, so the API keeps connections while they claim and report files.
+func parseWorkerConfigFromEnv(maxConns int) service.ParseWorkerConfig {
+	config := service.DefaultParseWorkerConfig()
+	config.Workers = intFromEnv("PARSE_WORKERS", config.Workers)
+	if maxWorkers := max((maxConns-1)/2, 1); config.Workers > maxWorkers {
+		log.Printf("PARSE_WORKERS is too high for %d database connections, using %d", maxConns, maxWorkers)
+		config.Workers = maxWorkers
+	}
+	config.PollWait = durationFromEnv("PARSE_WORKER_POLL_WAIT", config.PollWait)
+	config.HeartbeatInterval = durationFromEnv("PARSE_WORKER_HEARTBEAT_INTERVAL", config.HeartbeatInterval)
+	return config
+}
+
+const (
+	// defaultBodyLimit is the size of the largest request body accepted when the file size limit is lower
+	defaultBodyLimit = 100 << 20
+	// bodyLimitOverhead is the room left in a request body for the multipart encoding around an uploaded file
+	bodyLimitOverhead = 1 << 20
+)
+
+// bodyLimitFromEnv returns the size of the largest request body accepted, HTTP_BODY_LIMIT or else 100MB raised
+// to fit the largest upload the default limits allow. The body limit caps the file size limits of the users
+// too: an upload larger than it is rejected with 413 whatever the limits of its user, and setting a user a
+// larger limit is refused, so HTTP_BODY_LIMIT must be raised to allow a user larger files.
+func bodyLimitFromEnv(limits service.Limits) int {
+	bodyLimit := defaultBodyLimit
+	if limits.MaxFileBytes > 0 {
+		bodyLimit = max(bodyLimit, int(limits.MaxFileBytes)+bodyLimitOverhead)
+	}
+	bodyLimit = intFromEnv("HTTP_BODY_LIMIT", bodyLimit)
+
+	if limits.MaxFileBytes == 0 || int64(bodyLimit) < limits.MaxFileBytes {
+		log.Printf("Uploads are limited to the request body limit of %d bytes", bodyLimit)
+	}
+	return bodyLimit
+}
+
+// durationFromEnv reads a duration such as "90s" or "5m" from the given environment variable
+func durationFromEnv(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		log.Printf("Invalid value for %s, using default %v", key, fallback)
+		return fallback
+	}
+
+	return duration
+}
+
+// intFromEnv reads a positive integer from the given environment variable
+func intFromEnv(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil || number <= 0 {
+		log.Printf("Invalid value for %s, using default %v", key, fallback)
+		return fallback
+	}
+
+	return number
+}
+
+// limitDurationFromEnv reads a time limit such as "90s" or "5m" from the given environment variable, or 0 for no
+// limit
+func limitDurationFromEnv(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < 0 {
+		log.Printf("Invalid value for %s, using default %v", key, fallback)
+		return fallback
+	}
+
+	return duration
+}
+
+// limitFromEnv reads a limit from the given environment variable, a positive integer or 0 for no limit
+func limitFromEnv(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil || number < 0 {
+		log.Printf("Invalid value for %s, using default %v", key, fallback)
+		return fallback
+	}
+
+	return number
+}
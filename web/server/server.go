@@ -5,22 +5,28 @@ import (
 	"PDFStoring/service"
 	"PDFStoring/web/handlers"
 	"PDFStoring/web/routes"
+	"context"
 	"github.com/gofiber/fiber/v2"
 	"log"
 	"os"
 )
 
 type Server struct {
-	App        *fiber.App
-	PostgreSQL *database.PostgreSQLConnection
+	App            *fiber.App
+	PostgreSQL     *database.PostgreSQLConnection
+	stopBackground context.CancelFunc
 }
 
 // CreateServer initializes and confugures the server, database connection, services, handlers, and routes
 func CreateServer(connStr, dbName string) *Server {
-	app := fiber.New()
+	queueConfig := queueConfigFromEnv()
+	app := fiber.New(fiber.Config{
+		BodyLimit: bodyLimitFromEnv(queueConfig.Limits),
+	})
 
 	// Initialize PostgreSQL connection
-	databaseService := database.NewDatabaseService()
+	maxConns := intFromEnv("DB_MAX_CONNS", database.DefaultMaxConns)
+	databaseService := database.NewDatabaseService(int32(maxConns))
 	db, err := databaseService.NewDatabase(connStr, dbName)
 	if err != nil {
 		log.Println(err.Error())
@@ -29,23 +35,51 @@ func CreateServer(connStr, dbName string) *Server {
 
 	log.Println("Connected to PostgreSQL")
 
+	// Initialize the blob store keeping the uploaded PDFs
+	blobStore, err := blobStoreFromEnv()
+	if err != nil {
+		log.Println(err.Error())
+		panic("Cannot open the blob store")
+	}
+
+	// Initialize the queue backend holding the files waiting to be parsed
+	queueBackend, err := queueBackendFromEnv(db, queueConfig.Config)
+	if err != nil {
+		log.Println(err.Error())
+		panic("Cannot open the queue backend")
+	}
+
 	// Service initialization
-	userService := service.NewUserService(db)
-	fileService := service.NewFileService(db)
-	queueService := service.NewQueueService(db)
+	userService := service.NewUserService(db, queueBackend)
+	queueService := service.NewQueueService(db, queueBackend, blobStore, queueConfig)
+	fileService := service.NewFileService(db, blobStore, queueBackend, queueConfig.Limits)
+	workerService := service.NewWorkerService(db, queueBackend, queueConfig.WorkerHeartbeatTimeout)
 
 	// Handlers initialization
 	userHandler := handlers.NewUserApiService(userService)
 	fileHandler := handlers.NewFileApiService(fileService)
 	queueHandler := handlers.NewQueueApiService(queueService)
+	workerHandler := handlers.NewWorkerApiService(workerService)
 
 	// Routes initialization
-	routes.SetupRoutes(app, userHandler, fileHandler, queueHandler)
+	routes.SetupRoutes(app, userHandler, fileHandler, queueHandler, workerHandler)
+
+	// Background tasks initialization
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	queueService.StartListener(backgroundCtx)
+	service.StartLeaseReaper(backgroundCtx, queueService, queueConfig.ReaperInterval)
+	err = service.StartParseWorkers(backgroundCtx, queueService, workerService, parseWorkerConfigFromEnv(maxConns))
+	if err != nil {
+		stopBackground()
+		log.Println(err.Error())
+		panic("Cannot start the parse workers")
+	}
 
 	// Server initialization
 	server := &Server{
-		App:        app,
-		PostgreSQL: db,
+		App:            app,
+		PostgreSQL:     db,
+		stopBackground: stopBackground,
 	}
 
 	return server
@@ -60,8 +94,9 @@ func (s *Server) Start() error {
 	return nil
 }
 
-// Close gracefully shuts down the database connection when the server is stopped
+// Close gracefully stops the background tasks and the database connection when the server is stopped
 func (s *Server) Close() {
+	s.stopBackground()
 	s.PostgreSQL.Close()
 	log.Println("Server and database connection closed")
 }